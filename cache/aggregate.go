@@ -0,0 +1,290 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// 聚合方式
+type AggOp byte
+
+const (
+	AGG_COUNT AggOp = 1 // 数量
+	AGG_SUM   AggOp = 2 // 求和
+	AGG_MIN   AggOp = 3 // 最小值
+	AGG_MAX   AggOp = 4 // 最大值
+)
+
+var aggOpNames = map[AggOp]string{
+	AGG_COUNT: "count",
+	AGG_SUM:   "sum",
+	AGG_MIN:   "min",
+	AGG_MAX:   "max",
+}
+
+// 聚合配置
+type AggregateConfig struct {
+	Name    string                            // 聚合名称
+	Op      AggOp                             // 聚合方式
+	Field   string                            // 统计的数值字段(AGG_COUNT不需要)，按int64统计
+	GroupBy string                            // 分组字段(为空时不分组)
+	Group   func(obj interface{}) interface{} // 自定义分组(如等级段)，优先于GroupBy
+	Skip    func(obj interface{}) bool        // 不参与统计的obj(可为nil)
+}
+
+// 聚合的prof信息
+type AggregateProf struct {
+	Name   string
+	Op     string
+	Total  int64            // 所有分组的结果
+	Groups map[string]int64 // 分组 -> 结果
+}
+
+// 容器上增量维护的聚合(count/sum/min/max)，obj被Replace、Delete、加载和回收时更新
+//
+// 非预加载容器只统计已加载到内存中的cell
+type Aggregate struct {
+	cfg     AggregateConfig
+	field   *reflect.StructField // 统计字段
+	groupBy *reflect.StructField // 分组字段
+	lock    sync.RWMutex
+	groups  map[interface{}]*aggGroup
+	items   map[string]aggItem // 主键 -> 统计时的分组和值
+}
+
+// 一个分组的统计
+type aggGroup struct {
+	count  int64
+	sum    int64
+	values map[int64]int64 // 值 -> 数量(min/max删除时重新计算使用)
+	min    int64
+	max    int64
+}
+
+// obj统计时的分组和值(原地修改obj后通过主键找到之前的统计)
+type aggItem struct {
+	group interface{}
+	value int64
+}
+
+// 在容器上建立增量聚合，通过Container.Aggregate(name)查询
+func WithAggregate(cfg AggregateConfig) ContainerOption {
+	return func(o *containerOptions) {
+		if cfg.Op == 0 {
+			cfg.Op = AGG_COUNT
+		}
+		o.aggregates = append(o.aggregates, cfg)
+	}
+}
+
+func newAggregate(objType reflect.Type, cfg AggregateConfig) *Aggregate {
+	a := &Aggregate{
+		cfg:    cfg,
+		groups: make(map[interface{}]*aggGroup),
+		items:  make(map[string]aggItem),
+	}
+	if cfg.Op != AGG_COUNT {
+		field, exit := objType.FieldByName(cfg.Field)
+		if !exit {
+			panic(fmt.Sprintf("cache aggregate field not found, objType:%s field:%s", objType, cfg.Field))
+		}
+		a.field = &field
+	}
+	if cfg.Group == nil && cfg.GroupBy != "" {
+		field, exit := objType.FieldByName(cfg.GroupBy)
+		if !exit {
+			panic(fmt.Sprintf("cache aggregate group field not found, objType:%s field:%s", objType, cfg.GroupBy))
+		}
+		a.groupBy = &field
+	}
+	return a
+}
+
+// 获取聚合，不存在时返回nil
+func (c *Container) Aggregate(name string) *Aggregate {
+	return c.aggregates[name]
+}
+
+func (a *Aggregate) groupOf(obj interface{}) interface{} {
+	if a.cfg.Group != nil {
+		return a.cfg.Group(obj)
+	}
+	if a.groupBy != nil {
+		return reflect.ValueOf(obj).Elem().FieldByIndex(a.groupBy.Index).Interface()
+	}
+	return nil
+}
+
+func (a *Aggregate) valueOf(obj interface{}) int64 {
+	if a.field == nil {
+		return 0
+	}
+	v := reflect.ValueOf(obj).Elem().FieldByIndex(a.field.Index)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return int64(v.Float())
+	default:
+		return 0
+	}
+}
+
+func (a *Aggregate) observe(sid uint64, keys []interface{}, old interface{}, obj interface{}) {
+	key := indexKey(sid, keys)
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if item, exit := a.items[key]; exit {
+		a.remove(item)
+		delete(a.items, key)
+	}
+	if obj == nil || (a.cfg.Skip != nil && a.cfg.Skip(obj)) {
+		return
+	}
+	item := aggItem{group: a.groupOf(obj), value: a.valueOf(obj)}
+	a.add(item)
+	a.items[key] = item
+}
+
+func (a *Aggregate) add(item aggItem) {
+	g, exit := a.groups[item.group]
+	if !exit {
+		g = &aggGroup{min: item.value, max: item.value}
+		if a.cfg.Op == AGG_MIN || a.cfg.Op == AGG_MAX {
+			g.values = make(map[int64]int64)
+		}
+		a.groups[item.group] = g
+	}
+	g.count++
+	g.sum += item.value
+	if g.values != nil {
+		g.values[item.value]++
+		if item.value < g.min {
+			g.min = item.value
+		}
+		if item.value > g.max {
+			g.max = item.value
+		}
+	}
+}
+
+func (a *Aggregate) remove(item aggItem) {
+	g, exit := a.groups[item.group]
+	if !exit {
+		return
+	}
+	g.count--
+	g.sum -= item.value
+	if g.count <= 0 {
+		delete(a.groups, item.group)
+		return
+	}
+	if g.values != nil {
+		g.values[item.value]--
+		if g.values[item.value] > 0 {
+			return
+		}
+		delete(g.values, item.value)
+		// 删除的是当前最值时重新计算
+		if item.value == g.min || item.value == g.max {
+			first := true
+			for value := range g.values {
+				if first || value < g.min {
+					g.min = value
+				}
+				if first || value > g.max {
+					g.max = value
+				}
+				first = false
+			}
+		}
+	}
+}
+
+func (a *Aggregate) result(g *aggGroup) int64 {
+	switch a.cfg.Op {
+	case AGG_SUM:
+		return g.sum
+	case AGG_MIN:
+		return g.min
+	case AGG_MAX:
+		return g.max
+	default:
+		return g.count
+	}
+}
+
+// 查询值转换成分组字段类型(如int转换成uint32)
+func (a *Aggregate) convert(group interface{}) interface{} {
+	if a.groupBy == nil || group == nil {
+		return group
+	}
+	v := reflect.ValueOf(group)
+	if v.Type() != a.groupBy.Type && v.Type().ConvertibleTo(a.groupBy.Type) {
+		return v.Convert(a.groupBy.Type).Interface()
+	}
+	return group
+}
+
+// 某个分组的结果，分组不存在时返回0(不分组时group传nil)
+func (a *Aggregate) Value(group interface{}) int64 {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	g, exit := a.groups[a.convert(group)]
+	if !exit {
+		return 0
+	}
+	return a.result(g)
+}
+
+// 所有分组合起来的结果
+func (a *Aggregate) Total() int64 {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	var total int64 = 0
+	first := true
+	for _, g := range a.groups {
+		value := a.result(g)
+		switch a.cfg.Op {
+		case AGG_MIN:
+			if first || value < total {
+				total = value
+			}
+		case AGG_MAX:
+			if first || value > total {
+				total = value
+			}
+		default:
+			total += value
+		}
+		first = false
+	}
+	return total
+}
+
+// 所有分组的结果
+func (a *Aggregate) Groups() map[interface{}]int64 {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	groups := make(map[interface{}]int64, len(a.groups))
+	for group, g := range a.groups {
+		groups[group] = a.result(g)
+	}
+	return groups
+}
+
+func (a *Aggregate) prof() *AggregateProf {
+	prof := &AggregateProf{
+		Name:   a.cfg.Name,
+		Op:     aggOpNames[a.cfg.Op],
+		Total:  a.Total(),
+		Groups: make(map[string]int64),
+	}
+	for group, value := range a.Groups() {
+		prof.Groups[fmt.Sprint(group)] = value
+	}
+	return prof
+}
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fengzhu0601/gotools/cache/internal/fakedb"
+)
+
+func TestAggregate(t *testing.T) {
+	byName := func(op AggOp) AggregateConfig {
+		return AggregateConfig{Name: "agg", Op: op, Field: "Num", GroupBy: "Name"}
+	}
+	inPlace := func(c *Container, sid uint64, id uint32, modify func(obj *testItem)) {
+		obj := c.Lookup(sid, id).(*testItem)
+		modify(obj)
+		c.Replace(obj)
+	}
+	tests := []struct {
+		name   string
+		cfg    AggregateConfig
+		write  func(c *Container)
+		groups map[interface{}]int64
+		total  int64
+	}{
+		{"count", byName(AGG_COUNT), nil, map[interface{}]int64{"a": 3, "b": 1}, 4},
+		{"sum", byName(AGG_SUM), nil, map[interface{}]int64{"a": 21, "b": 2}, 23},
+		{"min", byName(AGG_MIN), nil, map[interface{}]int64{"a": 5, "b": 2}, 2},
+		{"max", byName(AGG_MAX), nil, map[interface{}]int64{"a": 9, "b": 2}, 9},
+		{"no group", AggregateConfig{Name: "agg", Op: AGG_SUM, Field: "Num"}, nil, map[interface{}]int64{nil: 23}, 23},
+		{"custom group", AggregateConfig{Name: "agg", Op: AGG_COUNT, Group: func(obj interface{}) interface{} {
+			return obj.(*testItem).Num / 5
+		}}, nil, map[interface{}]int64{0: 1, 1: 3}, 4},
+		{"skip", AggregateConfig{Name: "agg", Op: AGG_COUNT, GroupBy: "Name", Skip: func(obj interface{}) bool {
+			return obj.(*testItem).Num < 6
+		}}, nil, map[interface{}]int64{"a": 2}, 2},
+		{"max delete extreme", byName(AGG_MAX), func(c *Container) {
+			c.Delete(&testItem{Sid: 1, Id: 2})
+		}, map[interface{}]int64{"a": 7, "b": 2}, 7},
+		{"min delete extreme", byName(AGG_MIN), func(c *Container) {
+			c.Delete(&testItem{Sid: 1, Id: 1})
+		}, map[interface{}]int64{"a": 7, "b": 2}, 2},
+		{"min delete last of group", byName(AGG_MIN), func(c *Container) {
+			c.Delete(&testItem{Sid: 1, Id: 3})
+		}, map[interface{}]int64{"a": 5}, 5},
+		{"max delete duplicated extreme", byName(AGG_MAX), func(c *Container) {
+			c.Replace(&testItem{Sid: 2, Id: 2, Num: 9, Name: "a"})
+			c.Delete(&testItem{Sid: 1, Id: 2})
+		}, map[interface{}]int64{"a": 9, "b": 2}, 9},
+		{"min in place raise extreme", byName(AGG_MIN), func(c *Container) {
+			inPlace(c, 1, 1, func(obj *testItem) { obj.Num = 8 })
+		}, map[interface{}]int64{"a": 7, "b": 2}, 2},
+		{"max in place lower extreme", byName(AGG_MAX), func(c *Container) {
+			inPlace(c, 1, 2, func(obj *testItem) { obj.Num = 1 })
+		}, map[interface{}]int64{"a": 7, "b": 2}, 7},
+		{"sum in place move group", byName(AGG_SUM), func(c *Container) {
+			inPlace(c, 1, 3, func(obj *testItem) { obj.Name = "a" })
+		}, map[interface{}]int64{"a": 23}, 23},
+		{"count delete objs", byName(AGG_COUNT), func(c *Container) {
+			c.DeleteObjs(1)
+		}, map[interface{}]int64{"a": 1}, 1},
+		{"min insert new extreme", byName(AGG_MIN), func(c *Container) {
+			c.Replace(&testItem{Sid: 3, Id: 1, Num: -4, Name: "b"})
+		}, map[interface{}]int64{"a": 5, "b": -4}, -4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContainer(t, fakedb.New(), testItemType, WithPreload(true), WithAggregate(tt.cfg))
+			for _, obj := range []*testItem{
+				{Sid: 1, Id: 1, Num: 5, Name: "a"},
+				{Sid: 1, Id: 2, Num: 9, Name: "a"},
+				{Sid: 1, Id: 3, Num: 2, Name: "b"},
+				{Sid: 2, Id: 1, Num: 7, Name: "a"},
+			} {
+				c.Replace(obj)
+			}
+			if tt.write != nil {
+				tt.write(c)
+			}
+			a := c.Aggregate("agg")
+			groups := a.Groups()
+			if !reflect.DeepEqual(groups, tt.groups) {
+				t.Fatalf("Groups() = %v, want %v", groups, tt.groups)
+			}
+			for group, want := range tt.groups {
+				if got := a.Value(group); got != want {
+					t.Fatalf("Value(%v) = %d, want %d", group, got, want)
+				}
+			}
+			if got := a.Total(); got != tt.total {
+				t.Fatalf("Total() = %d, want %d", got, tt.total)
+			}
+		})
+	}
+}
+
+// 查询值转换成分组字段类型，加载和回收时更新
+func TestAggregateLoadAndEvict(t *testing.T) {
+	db := fakedb.New()
+	num := int64(4)
+	loadTestItemNum(db, &num)
+	c := newTestContainer(t, db, testItemType, WithPreload(false),
+		WithAggregate(AggregateConfig{Name: "agg", Op: AGG_SUM, Field: "Num", GroupBy: "Id"}))
+	a := c.Aggregate("agg")
+	if c.Lookup(1, 1) == nil || a.Value(1) != 4 || a.Value(uint32(1)) != 4 {
+		t.Fatalf("Value(1) = %d after load, want 4", a.Value(1))
+	}
+	cell, _ := c.cellLoad(1)
+	cell.releaseTime = 1
+	c.updater.batchUpdate()
+	if a.Value(1) != 0 || len(a.Groups()) != 0 {
+		t.Fatalf("Groups() = %v after evict, want empty", a.Groups())
+	}
+}
@@ -0,0 +1,254 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fengzhu0601/gotools/logger"
+	"github.com/natefinch/lumberjack"
+)
+
+const (
+	auditBatchSize     = 1000   // 每次批量写入的记录数
+	auditPendingMax    = 100000 // 写入失败时最多保留的记录数
+	auditPruneInterval = time.Hour
+	auditTableSuffix   = "_history"
+)
+
+// 变更记录的存储方式
+type AuditMode byte
+
+const (
+	AUDIT_TABLE AuditMode = 0 // 写入<table>_history表(默认)
+	AUDIT_FILE  AuditMode = 1 // 写入本地滚动文件
+)
+
+// 变更记录配置
+type AuditConfig struct {
+	Mode      AuditMode
+	Dir       string        // 文件目录(AUDIT_FILE)
+	MaxSize   int           // 单个文件大小(M，AUDIT_FILE)
+	Retention time.Duration // 保留时长(0表示永久保留)
+}
+
+// 一条变更记录
+type AuditRecord struct {
+	Id     uint64    `gorm:"primaryKey;autoIncrement"`
+	Time   time.Time `gorm:"index:idx_sid_time,priority:2"`
+	Sid    uint64    `gorm:"index:idx_sid_time,priority:1"`
+	Keys   string    `gorm:"size:64"` // 除sid外的主键(逗号分隔)
+	Op     Op        // 变更类型
+	Reason string    `gorm:"size:128"`  // 调用方提供的原因
+	Before string    `gorm:"type:text"` // 变更前的obj json(插入时为空)
+	After  string    `gorm:"type:text"` // 变更后的obj json(删除时为空)
+}
+
+// 容器的变更记录器
+type auditor struct {
+	container *Container
+	cfg       AuditConfig
+	table     string             // 记录表名
+	file      *lumberjack.Logger // 记录文件
+	lock      sync.Mutex
+	pending   []*AuditRecord // 等待写入的记录
+	pruneTime time.Time      // 上次清理过期记录的时间
+}
+
+func newAuditor(c *Container, cfg AuditConfig) *auditor {
+	a := &auditor{
+		container: c,
+		cfg:       cfg,
+		table:     c.tableName + auditTableSuffix,
+	}
+	if cfg.Mode == AUDIT_FILE {
+		a.file = &lumberjack.Logger{
+			Filename: filepath.Join(cfg.Dir, a.table+".log"),
+			MaxSize:  cfg.MaxSize,
+			MaxAge:   int((cfg.Retention + 24*time.Hour - 1) / (24 * time.Hour)),
+		}
+	} else {
+		err := c.db.Table(a.table).AutoMigrate(&AuditRecord{})
+		if err != nil {
+			panic(err)
+		}
+	}
+	return a
+}
+
+// 记录一次变更，old为变更前的值(原地修改时由容器的快照还原，见swapSnap)
+func (a *auditor) record(op Op, sid uint64, keys []interface{}, old interface{}, obj interface{}, reason string) {
+	keyStr := joinKeys(keys)
+	rec := &AuditRecord{
+		Time:   time.Now(),
+		Sid:    sid,
+		Keys:   keyStr,
+		Op:     op,
+		Reason: reason,
+	}
+	if old != nil {
+		data, _ := json.Marshal(old)
+		rec.Before = string(data)
+	}
+	if obj != nil {
+		data, _ := json.Marshal(obj)
+		rec.After = string(data)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if len(a.pending) >= auditPendingMax {
+		logger.Error("cache audit pending full, drop record", a.container.objType, sid, keyStr)
+		return
+	}
+	a.pending = append(a.pending, rec)
+}
+
+// 是否有某个玩家等待写入的记录
+func (a *auditor) hasPending(sid uint64) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for _, rec := range a.pending {
+		if rec.Sid == sid {
+			return true
+		}
+	}
+	return false
+}
+
+// 批量写入等待中的记录，失败时保留等下次写入
+func (a *auditor) flush() {
+	a.lock.Lock()
+	records := a.pending
+	a.pending = nil
+	a.lock.Unlock()
+
+	if len(records) > 0 {
+		err := a.write(records)
+		if err != nil {
+			logger.Error("cache audit write error:", a.container.objType, len(records), err)
+			a.lock.Lock()
+			a.pending = append(records, a.pending...)
+			a.lock.Unlock()
+		}
+	}
+	a.prune()
+}
+
+func (a *auditor) write(records []*AuditRecord) error {
+	if a.file == nil {
+		return a.container.db.Table(a.table).CreateInBatches(records, auditBatchSize).Error
+	}
+	var sb strings.Builder
+	for _, rec := range records {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		sb.Write(data)
+		sb.WriteByte('\n')
+	}
+	_, err := a.file.Write([]byte(sb.String()))
+	return err
+}
+
+// 清理过期的记录(文件按MaxAge由lumberjack清理)
+func (a *auditor) prune() {
+	if a.file != nil || a.cfg.Retention <= 0 || time.Since(a.pruneTime) < auditPruneInterval {
+		return
+	}
+	a.pruneTime = time.Now()
+	expire := a.pruneTime.Add(-a.cfg.Retention)
+	err := a.container.db.Table(a.table).Where("time < ?", expire).Delete(&AuditRecord{}).Error
+	if err != nil {
+		logger.Error("cache audit prune error:", a.container.objType, err)
+	}
+}
+
+// 查询某个玩家在[from, to]时间范围内的变更记录(按时间排序，包含还未写入的记录)
+func (a *auditor) history(ctx context.Context, sid uint64, from time.Time, to time.Time) ([]*AuditRecord, error) {
+	var records []*AuditRecord
+	var err error
+	if a.file == nil {
+		err = a.container.db.WithContext(ctx).Table(a.table).
+			Where("sid = ? AND time >= ? AND time <= ?", sid, from, to).
+			Order("id").Find(&records).Error
+	} else {
+		records, err = a.historyFromFiles(sid, from, to)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a.lock.Lock()
+	for _, rec := range a.pending {
+		if rec.Sid == sid && !rec.Time.Before(from) && !rec.Time.After(to) {
+			records = append(records, rec)
+		}
+	}
+	a.lock.Unlock()
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	return records, nil
+}
+
+// 从记录文件(包括滚动后的旧文件)中查询
+func (a *auditor) historyFromFiles(sid uint64, from time.Time, to time.Time) ([]*AuditRecord, error) {
+	files, err := filepath.Glob(filepath.Join(a.cfg.Dir, a.table+"*.log"))
+	if err != nil {
+		return nil, err
+	}
+	var records []*AuditRecord
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			rec := &AuditRecord{}
+			if json.Unmarshal(scanner.Bytes(), rec) != nil {
+				continue
+			}
+			if rec.Sid == sid && !rec.Time.Before(from) && !rec.Time.After(to) {
+				records = append(records, rec)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// 有等待写入的变更记录时cell不会被回收，还原变更前的值需要的快照一直保留到记录写入
+func (c *Container) hasAuditPending(sid uint64) bool {
+	return c.auditor != nil && c.auditor.hasPending(sid)
+}
+
+func joinKeys(keys []interface{}) string {
+	strs := make([]string, len(keys))
+	for i, key := range keys {
+		strs[i] = fmt.Sprint(key)
+	}
+	return strings.Join(strs, ",")
+}
+
+// 查询某个玩家在[from, to]时间范围内的变更记录(容器需开启WithAudit)
+func (c *Container) History(ctx context.Context, sid uint64, from time.Time, to time.Time) ([]*AuditRecord, error) {
+	if c.auditor == nil {
+		return nil, fmt.Errorf("audit not enabled, objType:%s", c.objType)
+	}
+	return c.auditor.history(ctx, sid, from, to)
+}
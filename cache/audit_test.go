@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fengzhu0601/gotools/cache/internal/fakedb"
+)
+
+// 数据库中sid 1的数据，num可以在测试中修改
+func loadTestItemNum(db *fakedb.DB, num *int64) {
+	db.QueryFn = func(query string, args []interface{}) (*fakedb.Rows, error) {
+		if !strings.Contains(query, "FROM `test_item`") {
+			return nil, nil
+		}
+		return &fakedb.Rows{
+			Columns: []string{"sid", "id", "num", "name"},
+			Values:  [][]driver.Value{{int64(1), int64(1), *num, "db"}},
+		}, nil
+	}
+}
+
+// 最后一条变更记录的Before
+func lastBefore(t *testing.T, c *Container, sid uint64) *testItem {
+	records, err := c.History(context.Background(), sid, time.Time{}, time.Now())
+	if err != nil || len(records) == 0 {
+		t.Fatalf("History() = %v %v, want records", records, err)
+	}
+	before := &testItem{}
+	if err := json.Unmarshal([]byte(records[len(records)-1].Before), before); err != nil {
+		t.Fatalf("Before %q: %v", records[len(records)-1].Before, err)
+	}
+	return before
+}
+
+// 加载或重新加载后原地修改再Replace，Before为数据库中的值
+func TestAuditBeforeInPlace(t *testing.T) {
+	tests := []struct {
+		name    string
+		preload bool
+		reload  int64 // 重新加载前数据库中的新值(0表示不重新加载)
+		want    *testItem
+	}{
+		{"preload", true, 0, &testItem{Sid: 1, Id: 1, Num: 1, Name: "db"}},
+		{"load on demand", false, 0, &testItem{Sid: 1, Id: 1, Num: 1, Name: "db"}},
+		{"reload", true, 5, &testItem{Sid: 1, Id: 1, Num: 5, Name: "db"}},
+		{"reload on demand", false, 5, &testItem{Sid: 1, Id: 1, Num: 5, Name: "db"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakedb.New()
+			num := int64(1)
+			loadTestItemNum(db, &num)
+			c := newTestContainer(t, db, testItemType, WithPreload(tt.preload),
+				WithAudit(AuditConfig{Mode: AUDIT_FILE, Dir: t.TempDir()}))
+			obj := c.Lookup(1, 1).(*testItem)
+			if tt.reload != 0 {
+				num = tt.reload
+				if _, err := c.Reload(context.Background(), 1); err != nil {
+					t.Fatal(err)
+				}
+				obj = c.Lookup(1, 1).(*testItem)
+			}
+			obj.Num = 100
+			if err := c.Replace(obj); err != nil {
+				t.Fatal(err)
+			}
+			if got := lastBefore(t, c, 1); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Before = %+v, want %+v", got, tt.want)
+			}
+
+			// 再次原地修改，Before为上一次写入的值
+			obj.Num = 200
+			c.Replace(obj)
+			if got := lastBefore(t, c, 1); got.Num != 100 {
+				t.Fatalf("Before.Num = %d, want 100", got.Num)
+			}
+		})
+	}
+}
+
+// 有等待写入的变更记录时cell不会被回收，记录写入后才回收并清理快照
+func TestAuditPendingKeepsCell(t *testing.T) {
+	db := fakedb.New()
+	num := int64(1)
+	loadTestItemNum(db, &num)
+	c := newTestContainer(t, db, testItemType, WithPreload(false),
+		WithAudit(AuditConfig{Mode: AUDIT_FILE, Dir: t.TempDir()}))
+	obj := c.Lookup(1, 1).(*testItem)
+	obj.Num = 2
+	c.Replace(obj)
+	cell, _ := c.cellLoad(1)
+	cell.releaseTime = 1
+
+	c.updater.batchUpdate()
+	if _, exit := c.cellLoad(1); !exit {
+		t.Fatalf("cell evicted with pending audit records")
+	}
+	if _, exit := c.snaps.data[1]; !exit {
+		t.Fatalf("snapshots dropped with pending audit records")
+	}
+
+	c.auditor.flush()
+	c.updater.batchUpdate()
+	if _, exit := c.cellLoad(1); exit {
+		t.Fatalf("cell not evicted after audit flush")
+	}
+	if _, exit := c.snaps.data[1]; exit {
+		t.Fatalf("snapshots not dropped after eviction")
+	}
+}
@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/fengzhu0601/gotools/logger"
+)
+
+const (
+	defaultAggressiveGap = 100 * time.Millisecond
+	backlogCheckGap      = time.Second // 积压检查间隔
+)
+
+// 待写入数据的警戒线，超过后容器变成不健康状态
+type HighWater struct {
+	DirtyObjs     int           // 待写入的obj数量上限(同一obj多次写入只算一个，0表示不检查)
+	DirtyAge      time.Duration // 最早一次未写入变更的最大时长(0表示不检查)
+	Aggressive    bool          // 超过警戒线时加速刷新
+	AggressiveGap time.Duration // 加速刷新的间隔(默认100ms)
+}
+
+// 积压告警
+type Alert struct {
+	ObjType   reflect.Type  // 容器类型
+	DirtyObjs int           // 待写入obj数量
+	DirtyAge  time.Duration // 最早一次未写入变更的时长
+	Healthy   bool          // true表示已恢复正常
+}
+
+// 容器积压状态
+type ContainerHealth struct {
+	ObjType   reflect.Type
+	Healthy   bool
+	DirtyObjs int
+	DirtyAge  time.Duration
+}
+
+// 容器积压的统计
+type backlog struct {
+	dirtyObjs int64 // 待写入的obj数量(积压检查时统计)
+	dirtyAge  int64 // 最早一次未写入变更的时长(秒)
+	unhealthy int32 // 是否超过警戒线
+}
+
+// 设置积压告警回调(超过警戒线和恢复正常时调用)，不设置时只输出日志
+func (cache *Cache) OnAlert(fn func(*Alert)) {
+	cache.alertLock.Lock()
+	defer cache.alertLock.Unlock()
+	cache.alertFn = fn
+}
+
+// 所有容器都没有超过警戒线，网关可以据此停止接收新的登录
+func (cache *Cache) Healthy() bool {
+	for _, container := range cache.containerList {
+		if !container.Healthy() {
+			return false
+		}
+	}
+	return true
+}
+
+// 所有容器的积压状态
+func (cache *Cache) HealthStatus() []*ContainerHealth {
+	list := make([]*ContainerHealth, 0, len(cache.containerList))
+	for _, container := range cache.containerList {
+		list = append(list, container.healthStatus())
+	}
+	return list
+}
+
+// 容器是否没有超过警戒线
+func (c *Container) Healthy() bool {
+	return atomic.LoadInt32(&c.backlog.unhealthy) == 0
+}
+
+func (c *Container) healthStatus() *ContainerHealth {
+	return &ContainerHealth{
+		ObjType:   c.objType,
+		Healthy:   c.Healthy(),
+		DirtyObjs: int(atomic.LoadInt64(&c.backlog.dirtyObjs)),
+		DirtyAge:  time.Duration(atomic.LoadInt64(&c.backlog.dirtyAge)) * time.Second,
+	}
+}
+
+// 积压检查协程：和写库协程分开运行，写库阻塞(如数据库挂起)时也能告警
+func (c *Container) runBacklog(ctx context.Context) {
+	ticker := time.NewTicker(backlogCheckGap)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkBacklog()
+		}
+	}
+}
+
+// 统计积压数据，跨过警戒线时告警
+func (c *Container) checkBacklog() {
+	hw := c.opts.highWater
+	if hw == nil {
+		return
+	}
+	now := time.Now().Unix()
+	var oldest int64 = 0
+	dirtyObjs := 0
+	updateObjs := make([]interface{}, 0)
+	deleteKeys := make([]interface{}, 0)
+	c.cells.Range(func(k any, v any) bool {
+		cell := v.(*Cell)
+		if cell.changeTime == 0 {
+			return true
+		}
+		if oldest == 0 || cell.changeTime < oldest {
+			oldest = cell.changeTime
+		}
+		// 按载体中的变更标记统计，同一obj多次写入只算一个
+		dirtyObjs += int(cell.cargo.CollectChangedObjs(k.(uint64), &updateObjs, &deleteKeys, false))
+		updateObjs = updateObjs[:0]
+		deleteKeys = deleteKeys[:0]
+		return true
+	})
+	atomic.StoreInt64(&c.backlog.dirtyObjs, int64(dirtyObjs))
+	var dirtyAge int64 = 0
+	if oldest > 0 {
+		dirtyAge = now - oldest
+	}
+	atomic.StoreInt64(&c.backlog.dirtyAge, dirtyAge)
+
+	over := (hw.DirtyObjs > 0 && dirtyObjs > hw.DirtyObjs) ||
+		(hw.DirtyAge > 0 && time.Duration(dirtyAge)*time.Second > hw.DirtyAge)
+	var unhealthy int32 = 0
+	if over {
+		unhealthy = 1
+	}
+	if atomic.SwapInt32(&c.backlog.unhealthy, unhealthy) != unhealthy {
+		c.alert(!over)
+	}
+}
+
+// 发出告警
+func (c *Container) alert(healthy bool) {
+	status := c.healthStatus()
+	if healthy {
+		logger.Info("cache backlog recovered", c.objType, status.DirtyObjs, status.DirtyAge)
+	} else {
+		logger.Error("cache backlog over high water", c.objType, status.DirtyObjs, status.DirtyAge)
+	}
+	c.cache.alertLock.Lock()
+	alertFn := c.cache.alertFn
+	c.cache.alertLock.Unlock()
+	if alertFn != nil {
+		alertFn(&Alert{
+			ObjType:   c.objType,
+			DirtyObjs: status.DirtyObjs,
+			DirtyAge:  status.DirtyAge,
+			Healthy:   healthy,
+		})
+	}
+}
+
+// 下一次批量更新的间隔，超过警戒线时加速刷新
+func (c *Container) nextUpdateGap(updateGap time.Duration) time.Duration {
+	hw := c.opts.highWater
+	if hw == nil || !hw.Aggressive || c.Healthy() {
+		return updateGap
+	}
+	if hw.AggressiveGap > 0 {
+		return hw.AggressiveGap
+	}
+	return defaultAggressiveGap
+}
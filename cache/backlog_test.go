@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/fengzhu0601/gotools/cache/internal/fakedb"
+)
+
+func TestCheckBacklog(t *testing.T) {
+	tests := []struct {
+		name      string
+		writes    []uint32 // 依次写入的id(sid都为1)
+		deletes   []uint32
+		highWater int
+		dirtyObjs int
+		healthy   bool
+	}{
+		{"clean", nil, nil, 1, 0, true},
+		{"same obj", []uint32{1, 1, 1, 1}, nil, 1, 1, true},
+		{"distinct objs", []uint32{1, 2, 3}, nil, 2, 3, false},
+		{"write and delete", []uint32{1, 2}, []uint32{2, 2}, 2, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakedb.New()
+			c := newTestContainer(t, db, testItemType, WithPreload(true), WithHighWater(HighWater{DirtyObjs: tt.highWater}))
+			alerts := make([]*Alert, 0)
+			c.cache.OnAlert(func(alert *Alert) {
+				alerts = append(alerts, alert)
+			})
+			for i, id := range tt.writes {
+				c.Replace(&testItem{Sid: 1, Id: id, Num: i})
+			}
+			for _, id := range tt.deletes {
+				c.Delete(&testItem{Sid: 1, Id: id})
+			}
+			c.checkBacklog()
+			status := c.healthStatus()
+			if status.DirtyObjs != tt.dirtyObjs || status.Healthy != tt.healthy {
+				t.Fatalf("healthStatus() = %d %v, want %d %v", status.DirtyObjs, status.Healthy, tt.dirtyObjs, tt.healthy)
+			}
+			if tt.healthy {
+				if len(alerts) != 0 {
+					t.Fatalf("alerts = %+v, want none", alerts)
+				}
+				return
+			}
+			if len(alerts) != 1 || alerts[0].Healthy || alerts[0].DirtyObjs != tt.dirtyObjs {
+				t.Fatalf("alerts = %+v, want one unhealthy alert", alerts)
+			}
+
+			// 写库后恢复正常
+			c.updater.batchUpdate()
+			c.checkBacklog()
+			if status := c.healthStatus(); status.DirtyObjs != 0 || !status.Healthy {
+				t.Fatalf("healthStatus() after flush = %d %v, want 0 true", status.DirtyObjs, status.Healthy)
+			}
+			if len(alerts) != 2 || !alerts[1].Healthy {
+				t.Fatalf("alerts = %+v, want recovered alert", alerts)
+			}
+		})
+	}
+}
+
+// 积压检查时可以同时设置告警回调(go test -race)
+func TestOnAlertConcurrent(t *testing.T) {
+	db := fakedb.New()
+	c := newTestContainer(t, db, testItemType, WithPreload(true), WithHighWater(HighWater{DirtyObjs: 1}))
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			c.cache.OnAlert(func(*Alert) {})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			c.alert(i%2 == 0)
+		}
+	}()
+	wg.Wait()
+}
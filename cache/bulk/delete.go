@@ -15,8 +15,25 @@ func BulkDelete(db *gorm.DB, t reflect.Type, keys []interface{}) error {
 	if len(keys) == 0 {
 		return nil
 	}
-	keyNum := keyNum(keys[0])
-	tableName := getTableNameByType(t)
+	return BulkDeleteWithTableName(db, getTableNameByType(t), t, keys)
+}
+
+func BulkDeleteWithTableName(db *gorm.DB, tableName string, t reflect.Type, keys []interface{}) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return bulkDelete(db, tableName, t, keyNum(keys[0]), keys)
+}
+
+// 按obj的前keyNum个字段(主键)批量删除
+func BulkDeleteObjsWithTableName(db *gorm.DB, tableName string, keyNum int, objs []interface{}) error {
+	if len(objs) == 0 {
+		return nil
+	}
+	return bulkDelete(db, tableName, reflect.TypeOf(objs[0]).Elem(), keyNum, objs)
+}
+
+func bulkDelete(db *gorm.DB, tableName string, t reflect.Type, keyNum int, keys []interface{}) error {
 	tableName = escapeTabName(tableName)
 
 	_, aTags := getTags(t)
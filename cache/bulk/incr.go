@@ -0,0 +1,61 @@
+package bulk
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fengzhu0601/gotools/logger"
+	"gorm.io/gorm"
+)
+
+// 批量增量更新，行不存在时以增量作为初始值插入
+// "INSERT INTO `counters` (`sid`, `gold`) VALUES (1, 10),(2, -5) ON DUPLICATE KEY UPDATE `gold` = `gold` + VALUES(`gold`);"
+//
+// rows中每一行依次为主键列的值和增量列的增量
+func BulkIncrWithTableName(db *gorm.DB, tableName string, keyColumns []string, incrColumns []string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	tableName = escapeTabName(tableName)
+
+	columnNum := len(keyColumns) + len(incrColumns)
+	escapeTags := make([]string, 0, columnNum)
+	for _, column := range keyColumns {
+		escapeTags = append(escapeTags, escapeSqlName(column))
+	}
+	updates := make([]string, len(incrColumns))
+	for i, column := range incrColumns {
+		name := escapeSqlName(column)
+		escapeTags = append(escapeTags, name)
+		updates[i] = fmt.Sprintf("%s = %s + VALUES(%s)", name, name, name)
+	}
+	fields := strings.Join(escapeTags, ", ")
+	update := strings.Join(updates, ", ")
+
+	batchSize := MaximumPlaceholders / columnNum
+	placeholderStrs := "(?" + strings.Repeat(", ?", columnNum-1) + ")"
+	tx := db.Begin()
+	for i := 0; i < len(rows); i += batchSize {
+		maxBatchIndex := i + batchSize
+		if maxBatchIndex > len(rows) {
+			maxBatchIndex = len(rows)
+		}
+
+		valueArgs := make([]interface{}, 0, (maxBatchIndex-i)*columnNum)
+		phStrs := make([]string, maxBatchIndex-i)
+		for j, row := range rows[i:maxBatchIndex] {
+			valueArgs = append(valueArgs, row...)
+			phStrs[j] = placeholderStrs
+		}
+
+		smt := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s ON DUPLICATE KEY UPDATE %s", tableName, fields, strings.Join(phStrs, ","), update)
+		err := tx.Exec(smt, valueArgs...).Error
+		if err != nil {
+			logger.Error("db incr error ", tableName, len(rows), err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/fengzhu0601/gotools/cache/cargo"
+)
+
+const bundleVersion = 1
+
+// 玩家所有容器数据的导出包(可序列化，用于跨服迁移角色或制作测试账号)
+type PlayerBundle struct {
+	Version    int                          // 导出包版本
+	Sid        uint64                       // 导出时的sid
+	ExportTime time.Time                    // 导出时间
+	Tables     map[string][]json.RawMessage // 表名 -> obj的json列表
+}
+
+// 导入时重新分配的第二主键：obj类型 -> 旧key -> 新key(主键值，整数统一为uint64，见cargo.KeyOf)
+type KeyRemap map[reflect.Type]map[interface{}]interface{}
+
+// 导出某个玩家在所有容器中的数据
+func (cache *Cache) ExportSid(sid uint64) (*PlayerBundle, error) {
+	bundle := &PlayerBundle{
+		Version:    bundleVersion,
+		Sid:        sid,
+		ExportTime: time.Now(),
+		Tables:     make(map[string][]json.RawMessage),
+	}
+	for _, container := range cache.containerList {
+		if container.opts.global {
+			// 全局数据不属于玩家
+			continue
+		}
+		objs := container.LookupObjs(sid)
+		if len(objs) == 0 {
+			continue
+		}
+		rows := make([]json.RawMessage, 0, len(objs))
+		for _, obj := range objs {
+			data, err := json.Marshal(obj)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, data)
+		}
+		bundle.Tables[container.tableName] = rows
+	}
+	return bundle, nil
+}
+
+// 把导出包导入到newSid：改写sid字段后通过Replace写入缓存
+//
+// remapTypes中的容器(第二主键由GetNextUid或NextId分配)会重新分配第二主键，避免和newSid已有数据冲突，
+// 返回旧key到新key的映射，调用方可据此修正其他数据中的引用
+//
+// 导入不是原子的：obj逐个Replace，中途出错时已写入的obj会保留并由updater写库，
+// 调用方需要先用各容器的DeleteObjs清理newSid的数据再重新导入
+func (cache *Cache) ImportSid(bundle *PlayerBundle, newSid uint64, remapTypes ...reflect.Type) (KeyRemap, error) {
+	if bundle.Version != bundleVersion {
+		return nil, fmt.Errorf("bundle version error, version:%d", bundle.Version)
+	}
+	tables := make(map[string]*Container, len(cache.containerList))
+	for _, container := range cache.containerList {
+		tables[container.tableName] = container
+	}
+	for table := range bundle.Tables {
+		if _, exit := tables[table]; !exit {
+			return nil, fmt.Errorf("bundle table not exit, table:%s", table)
+		}
+	}
+	remap := make(KeyRemap)
+	for _, objType := range remapTypes {
+		container, exit := cache.containers[objType]
+		if !exit || container.keyNum != 2 || !isIntegerKind(container.keyTypes[1].Kind()) {
+			return nil, fmt.Errorf("remap objType error, objType:%s", objType)
+		}
+		remap[objType] = make(map[interface{}]interface{})
+	}
+
+	reason := fmt.Sprintf("import sid:%d", bundle.Sid)
+	for _, container := range cache.containerList {
+		if container.opts.global {
+			continue
+		}
+		rows := bundle.Tables[container.tableName]
+		keyMap := remap[container.objType]
+		for _, row := range rows {
+			obj := reflect.New(container.objType)
+			err := json.Unmarshal(row, obj.Interface())
+			if err != nil {
+				return remap, err
+			}
+			setKey(obj.Elem().Field(0), newSid)
+			if keyMap != nil {
+				oldKey := cargo.KeyOf(obj.Elem().Field(1))
+				newKey, err := container.nextUid(newSid)
+				if err != nil {
+					return remap, err
+				}
+				setKey(obj.Elem().Field(1), newKey)
+				keyMap[oldKey] = cargo.Key(newKey)
+			}
+			err = container.ReplaceReason(obj.Interface(), reason)
+			if err != nil {
+				return remap, err
+			}
+		}
+	}
+	return remap, nil
+}
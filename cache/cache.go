@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/fengzhu0601/gotools/logger"
@@ -17,86 +18,141 @@ import (
 
 type containerMap map[reflect.Type]*Container
 
+const updateLoopGap = 100 * time.Millisecond // 更新协程检查容器的间隔
+
 type DBConfig struct {
-	DBHost      string
-	DBPort      int
-	DBUser      string
-	DBPass      string
-	DBName      string
-	DBEncode    string
-	DBPool_size int
-	DBTimeout   int
-	UpdateGap   int   // 每次批量更新间隔(秒)
-	UpdateSize  int   // 每次批量更新的数据量
-	GCSeconds   int64 // 玩家下线n秒后进行内存回收
-	RWAnalyse   bool  // 是否启动读写分析(开启有性能损耗)
+	DBHost            string
+	DBPort            int
+	DBUser            string
+	DBPass            string
+	DBName            string
+	DBEncode          string            // 字符集(默认utf8mb4)
+	DBPool_size       int               // 连接池大小(未配置DBMaxOpenConns/DBMaxIdleConns时作为默认值)
+	DBTimeout         int               // 建立连接超时(秒)
+	DBReadTimeout     int               // 读超时(秒)
+	DBWriteTimeout    int               // 写超时(秒)
+	DBLoc             string            // 时区(默认Local)
+	DBMaxIdleConns    int               // 最大空闲连接数
+	DBMaxOpenConns    int               // 最大打开连接数
+	DBConnMaxLifetime int               // 连接最大存活时间(秒)
+	DBConnMaxIdleTime int               // 连接最大空闲时间(秒)
+	DBTLS             string            // TLS模式(true/false/skip-verify/preferred)，配置了证书时忽略
+	DBTLSCA           string            // CA证书路径
+	DBTLSCert         string            // 客户端证书路径
+	DBTLSKey          string            // 客户端私钥路径
+	DBTLSServerName   string            // 校验的服务器名称
+	DBParams          map[string]string // 额外的DSN参数
+	DBReplicas        []DBReplica       // 只读从库(selector加载和预加载使用，失败时回退主库)
+	ReplicaLagSeconds int64             // 本进程n秒内刷新过的sid，从主库读取(防止从库延迟读到旧数据，默认5秒，<0表示关闭)
+	UpdateGap         int               // 每次批量更新间隔(秒)
+	UpdateSize        int               // 每次批量更新的数据量
+	GCSeconds         int64             // 玩家下线n秒后进行内存回收
+	RWAnalyse         bool              // 是否启动读写分析(开启有性能损耗)
 }
 
+// 缓存：每种obj类型一个容器
+//
+// 不兼容的变更(升级时需要修改调用方)：
+//   - sid参数统一为uint64(原为uint32)：Lookup、GetCargo、Incr、NextId等接口和CargoInt.CollectChangedObjs都需要传uint64；obj的sid字段仍可以是任意非负整数类型，表结构不变
+//   - 除sid外的主键参数统一为...interface{}(原为...uint32)：整数之间可以互转，字符串主键用字符串查询；原LookupKey/LookupObjsKey合并到Lookup/LookupObjs
+//   - LookupRange的区间参数为interface{}，按主键值比较
 type Cache struct {
 	containers    containerMap // 容器集合
 	containerList []*Container
+	listLock      sync.RWMutex // containerList锁(更新协程和InitContainer并发)
 	dbConfig      *DBConfig
 	dbCon         *gorm.DB
+	readCons      []*gorm.DB // 只读从库连接
+	readIndex     uint32     // 从库轮询序号
+	alertFn       func(*Alert)
+	alertLock     sync.Mutex // alertFn锁(积压检查协程读取)
 	ctx           context.Context
 	cancel        context.CancelFunc
+	wg            sync.WaitGroup // 后台协程(批量更新、校验等)，Close时等待退出
 }
 
 func NewCache(dbConfig *DBConfig) (*Cache, error) {
+	ctx, cancel := context.WithCancel(context.Background())
 	cache := &Cache{
 		containers:    make(containerMap),
 		containerList: make([]*Container, 0),
-		dbConfig:      dbConfig}
+		dbConfig:      dbConfig,
+		ctx:           ctx,
+		cancel:        cancel}
 	err := cache.initDB()
 	if err != nil {
+		cancel()
 		return nil, err
 	}
-	go cache.loopUpdate()
+	cache.goRun(cache.loopUpdate)
 	return cache, nil
 }
 
-// cache更新策略：
-// 1.每UpdateGap秒更新一次;
-// 2.每次更新时按顺序选一个容器，读取容器里最多UpdateSize条变更记录，批量写入数据库
-// 3.如果更新数量是UpdateSize，说明容器还有剩余待更新内容，下次继续更新上次的容器;
-// 4.如果更新数量少于UpdateSize，下次更新会跳到下一个容器进行更新
-func (cache *Cache) loopUpdate() {
-	ctx, cancel := context.WithCancel(context.Background())
-	cache.ctx = ctx
-	cache.cancel = cancel
+// cache更新策略(所有容器共用一个更新协程，数据库写入并发不随容器数量增加)：
+// 1.每updateLoopGap检查一次，从上次的位置开始按顺序选出下一个需要写库的容器(由容器的FlushPolicy决定，默认距上一轮写完超过updateGap);
+// 2.每次读取容器里最多updateSize条变更记录，批量写入数据库
+// 3.如果更新数量是updateSize，说明容器还有剩余待更新内容，下次继续更新这个容器;
+// 4.如果更新数量少于updateSize，这个容器本轮写完，下次跳到后面的容器
+func (cache *Cache) loopUpdate(ctx context.Context) {
+	ticker := time.NewTicker(updateLoopGap)
+	defer ticker.Stop()
 	updateIndex := 0
-	var updateGap time.Duration = time.Duration(cache.dbConfig.UpdateGap) * time.Second
-	timer := time.NewTimer(updateGap)
+	var current *Container // 本轮还没写完的容器
 	for {
 		select {
-		case <-cache.ctx.Done():
+		case <-ctx.Done():
 			return
-		case <-timer.C:
-			if updateIndex >= len(cache.containerList) {
-				updateIndex = 0
-			} else {
-				container := cache.containerList[updateIndex]
-				// logger.Error("start update", container.objType)
-				if container.updater.batchUpdate() == true {
-					updateIndex++
+		case now := <-ticker.C:
+			if current == nil {
+				current, updateIndex = cache.nextFlush(updateIndex, now)
+				if current == nil {
+					continue
 				}
+				current.updater.beginFlush()
 			}
-			timer.Reset(updateGap)
+			if current.updater.batchUpdate() {
+				current.updater.endFlush(now)
+				current = nil
+			}
+		}
+	}
+}
+
+// 从updateIndex开始按顺序找到需要写库的容器，返回容器和下次开始查找的位置
+func (cache *Cache) nextFlush(updateIndex int, now time.Time) (*Container, int) {
+	cache.listLock.RLock()
+	defer cache.listLock.RUnlock()
+	num := len(cache.containerList)
+	for i := 0; i < num; i++ {
+		index := (updateIndex + i) % num
+		container := cache.containerList[index]
+		if container.updater.due(now) {
+			return container, (index + 1) % num
 		}
 	}
+	return nil, updateIndex
 }
 
 // 初始化一个指定类型的容器，对应数据库一个表格;
 // 当数据库断开链接而cache中没有数据，而容器又非preload预加载时，会抛出异常;
 // 使用该container的gorutine，应该做recover处理，或者把容器设置成preload;
-func (cache *Cache) InitContainer(objType reflect.Type, preload bool) {
+//
+// 主键字段支持各种整数和字符串类型(创建容器时检查)，sid字段需要是整数(uint64的账号id可以直接作为sid，有符号的sid不能为负数);
+//
+// 未通过opts设置的配置项使用DBConfig中的全局配置，如:
+//
+//	cache.InitContainer(mailType, WithUpdateSize(5000), WithMaxStaleness(time.Minute))
+func (cache *Cache) InitContainer(objType reflect.Type, opts ...ContainerOption) {
 	logger.Error("InitContainer", objType, len(cache.containerList))
-	container := NewContainer(cache, objType, preload)
+	container := NewContainer(cache, objType, opts...)
 	cache.containers[objType] = container
+	cache.listLock.Lock()
 	cache.containerList = append(cache.containerList, container)
+	cache.listLock.Unlock()
 }
 
 // 从指定类型容器中，获取某个玩家的所有数据的CargoInt (玩家模块初始化，加载数据并共享到玩家结构体中)
-func (cache *Cache) GetCargo(objType reflect.Type, sid uint32) CargoInt {
+func (cache *Cache) GetCargo(objType reflect.Type, sid uint64) CargoInt {
 	return cache.containers[objType].getCargo(sid, false)
 }
 
@@ -105,56 +161,177 @@ func (cache *Cache) GetAllObjs(objType reflect.Type) []interface{} {
 	return cache.containers[objType].getAllObjs()
 }
 
-// 获取某个玩家的单个数据(需要填满key)
-func (cache *Cache) Lookup(objType reflect.Type, sid uint32, keys ...uint32) interface{} {
+// 获取某个玩家的单个数据(需要填满key，整数、字符串等任意类型的主键值)
+func (cache *Cache) Lookup(objType reflect.Type, sid uint64, keys ...interface{}) interface{} {
 	return cache.containers[objType].Lookup(sid, keys...)
 }
 
 // 获取某个玩家的多个数据(自动根据key数量查找相应范围)
-func (cache *Cache) LookupObjs(objType reflect.Type, sid uint32, keys ...uint32) []interface{} {
+func (cache *Cache) LookupObjs(objType reflect.Type, sid uint64, keys ...interface{}) []interface{} {
 	return cache.containers[objType].LookupObjs(sid, keys...)
 }
 
-// 插入或更新某个数据
-func (cache *Cache) Replace(objType reflect.Type, obj interface{}) {
-	cache.containers[objType].Replace(obj)
+// 按主键获取全局容器中的单个数据
+func (cache *Cache) LookupGlobal(objType reflect.Type, keys ...interface{}) interface{} {
+	return cache.containers[objType].LookupGlobal(keys...)
+}
+
+// 按主键前缀获取全局容器中的数据(不传key时获取所有数据)
+func (cache *Cache) LookupGlobalObjs(objType reflect.Type, keys ...interface{}) []interface{} {
+	return cache.containers[objType].LookupGlobalObjs(keys...)
 }
 
-// 删除某个数据
-func (cache *Cache) Delete(objType reflect.Type, obj interface{}) {
-	cache.containers[objType].Delete(obj)
+// 向定长列表容器追加数据
+func (cache *Cache) Append(objType reflect.Type, obj interface{}) error {
+	return cache.containers[objType].Append(obj)
 }
 
-// 删除某玩家的所有数据(多key)
-func (cache *Cache) DeleteObjs(objType reflect.Type, sid uint32) {
-	cache.containers[objType].DeleteObjs(sid)
+// 获取定长列表容器中某个玩家最新的n条数据
+func (cache *Cache) Latest(objType reflect.Type, sid uint64, n int) []interface{} {
+	return cache.containers[objType].Latest(sid, n)
+}
+
+// 计数器容器的计数字段增加delta，返回增加后的值
+func (cache *Cache) Incr(objType reflect.Type, sid uint64, field string, delta int64, keys ...interface{}) (int64, error) {
+	return cache.containers[objType].Incr(sid, field, delta, keys...)
+}
+
+// 获取某个玩家第二主键在[fromKey, toKey]区间内的数据
+func (cache *Cache) LookupRange(objType reflect.Type, sid uint64, fromKey interface{}, toKey interface{}) []interface{} {
+	return cache.containers[objType].LookupRange(sid, fromKey, toKey)
+}
+
+// 插入或更新某个数据(obj实现了Validator时，校验失败返回错误且不写入)
+func (cache *Cache) Replace(objType reflect.Type, obj interface{}) error {
+	return cache.containers[objType].Replace(obj)
+}
+
+// 插入或更新某个数据，并在变更记录中写入原因
+func (cache *Cache) ReplaceReason(objType reflect.Type, obj interface{}, reason string) error {
+	return cache.containers[objType].ReplaceReason(obj, reason)
+}
+
+// 删除某个数据(写穿透容器写库失败或sid不合法时返回false)
+func (cache *Cache) Delete(objType reflect.Type, obj interface{}) bool {
+	return cache.containers[objType].Delete(obj)
+}
+
+// 删除某个数据，并在变更记录中写入原因
+func (cache *Cache) DeleteReason(objType reflect.Type, obj interface{}, reason string) bool {
+	return cache.containers[objType].DeleteReason(obj, reason)
+}
+
+// 查询某个玩家在[from, to]时间范围内的变更记录(容器需开启WithAudit)
+func (cache *Cache) History(ctx context.Context, objType reflect.Type, sid uint64, from time.Time, to time.Time) ([]*AuditRecord, error) {
+	return cache.containers[objType].History(ctx, sid, from, to)
+}
+
+// 遍历某个容器中的所有数据，fn返回false时停止遍历
+func (cache *Cache) Range(objType reflect.Type, fn func(sid uint64, obj interface{}) bool) {
+	cache.containers[objType].Range(fn)
+}
+
+// 新建某个容器的查询
+func (cache *Cache) Query(objType reflect.Type) *Query {
+	return cache.containers[objType].Query()
+}
+
+// 通过二级索引查询数据
+func (cache *Cache) FindBy(objType reflect.Type, name string, value interface{}) []interface{} {
+	return cache.containers[objType].FindBy(name, value)
+}
+
+// 获取某个容器的排行榜
+func (cache *Cache) Ranking(objType reflect.Type, name string) *Ranking {
+	return cache.containers[objType].Ranking(name)
+}
+
+// 获取某个容器的聚合
+func (cache *Cache) Aggregate(objType reflect.Type, name string) *Aggregate {
+	return cache.containers[objType].Aggregate(name)
+}
+
+// 从数据库重新加载某个容器的数据(不传sid时重新加载全部数据)
+func (cache *Cache) Reload(ctx context.Context, objType reflect.Type, sids ...uint64) (*ReloadResult, error) {
+	return cache.containers[objType].Reload(ctx, sids...)
+}
+
+// 校验一批sid的缓存和数据库是否一致
+func (cache *Cache) Verify(ctx context.Context, objType reflect.Type, sids []uint64) (*VerifyResult, error) {
+	return cache.containers[objType].Verify(ctx, sids)
+}
+
+// 同步插入或更新某个数据，写入数据库成功后才更新缓存(用于支付发货等不能延迟写入的数据)
+func (cache *Cache) ReplaceSync(ctx context.Context, objType reflect.Type, obj interface{}) error {
+	return cache.containers[objType].ReplaceSync(ctx, obj)
+}
+
+// 同步删除某个数据，从数据库删除成功后才更新缓存
+func (cache *Cache) DeleteSync(ctx context.Context, objType reflect.Type, obj interface{}) error {
+	return cache.containers[objType].DeleteSync(ctx, obj)
+}
+
+// 删除某玩家的所有数据(多key)，写穿透容器写库失败时返回false
+func (cache *Cache) DeleteObjs(objType reflect.Type, sid uint64) bool {
+	return cache.containers[objType].DeleteObjs(sid)
+}
+
+// 订阅某个容器的写入事件，返回取消订阅的函数
+func (cache *Cache) Subscribe(objType reflect.Type, fn func(ev ChangeEvent), opts ...SubscribeOption) func() {
+	return cache.containers[objType].Subscribe(fn, opts...)
 }
 
 // 不经过数据库，预先初始化数据载体(新玩家登陆时用，数据库一般没有新玩家的数据，调用这个方法，可以免去容器查询数据库的过程)
-func (cache *Cache) PreInitObjs(sid uint32) {
+func (cache *Cache) PreInitObjs(sid uint64) {
 	for _, container := range cache.containers {
 		container.preInitCargo(sid)
 	}
 }
 
-// 获取下一个Uid
-func (cache *Cache) GetNextUid(objType reflect.Type, sid uint32) uint32 {
+// 获取下一个Uid(内存中最大值+1，可能复用已删除的id，需要不重复时使用NextId)
+func (cache *Cache) GetNextUid(objType reflect.Type, sid uint64) uint32 {
 	return cache.containers[objType].GetNextUid(sid)
 }
 
+// 用持久化的id分配器分配下一个id(容器需开启WithSequence)
+func (cache *Cache) NextId(objType reflect.Type, sid uint64) (uint32, error) {
+	return cache.containers[objType].NextId(sid)
+}
+
+// 启动后台协程，cache关闭时ctx被取消，Close等待协程退出
+func (cache *Cache) goRun(fn func(ctx context.Context)) {
+	cache.wg.Add(1)
+	go func() {
+		defer cache.wg.Done()
+		fn(cache.ctx)
+	}()
+}
+
+// 关闭缓存(服务器关闭时用)：停止所有后台协程(更新、加载、校验等)，等待正在进行的批量更新结束后，把剩余数据全部写入数据库，
+// 最后取消所有订阅(订阅者处理完缓冲中的事件后退出)
+//
+// 关闭后不会再定时写库，也不能再从数据库加载，调用方需要先停止读写
+func (cache *Cache) Close() {
+	cache.cancel()
+	cache.wg.Wait()
+	cache.FlushAll()
+	for _, container := range cache.containerList {
+		container.unsubscribeAll()
+	}
+	logger.Info("cache closed")
+}
+
 // 马上把所有数据刷到数据库(服务器关闭时用)
+//
+// 和更新协程同时运行时，同一容器的批量更新由updater锁保证串行
 func (cache *Cache) FlushAll() {
 	for _, container := range cache.containers {
-		for {
-			if container.updater.batchUpdate() == true {
-				break
-			}
-		}
+		container.updater.flush()
 	}
 }
 
 // 设置玩家数据的内存回收标志
-func (cache *Cache) SetGC(sid uint32) {
+func (cache *Cache) SetGC(sid uint64) {
 	for _, container := range cache.containerList {
 		if !container.preload {
 			container.SetGC(sid)
@@ -163,7 +340,7 @@ func (cache *Cache) SetGC(sid uint32) {
 }
 
 // 去除玩家数据的内存回收标志
-func (cache *Cache) UnSetGC(sid uint32) {
+func (cache *Cache) UnSetGC(sid uint64) {
 	for _, container := range cache.containerList {
 		if !container.preload {
 			container.UnSetGC(sid)
@@ -173,12 +350,28 @@ func (cache *Cache) UnSetGC(sid uint32) {
 
 // 数据库连接初始化
 func (cache *Cache) initDB() error {
-	dbCfg := cache.dbConfig
-	dbArgs := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8", dbCfg.DBUser, dbCfg.DBPass, dbCfg.DBHost, dbCfg.DBPort, dbCfg.DBName)
+	d, err := openDB(cache.dbConfig)
+	if err != nil {
+		return err
+	}
+	cache.dbCon = d
+	return cache.initReplicas()
+}
+
+// 按配置打开一个数据库连接(与cache相同的命名策略、日志和连接池设置，供合服等工具使用)
+func OpenDB(dbCfg *DBConfig) (*gorm.DB, error) {
+	return openDB(dbCfg)
+}
+
+func openDB(dbCfg *DBConfig) (*gorm.DB, error) {
+	dsnCfg, err := buildDSNConfig(dbCfg)
+	if err != nil {
+		return nil, err
+	}
 	namingStrategy := schema.NamingStrategy{
 		SingularTable: true,
 	}
-	d, err := gorm.Open(mysql.Open(dbArgs), &gorm.Config{
+	d, err := gorm.Open(mysql.Open(dsnCfg.FormatDSN()), &gorm.Config{
 		NamingStrategy:         namingStrategy,
 		SkipDefaultTransaction: true,
 		Logger: gormlog.New(
@@ -192,10 +385,14 @@ func (cache *Cache) initDB() error {
 		),
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	cache.dbCon = d
-	return nil
+	err = setupPool(d, dbCfg)
+	if err != nil {
+		return nil, err
+	}
+	logDBConfig(dsnCfg, dbCfg)
+	return d, nil
 }
 
 // 自定义writer，输出gorm警报到日志
@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/fengzhu0601/gotools/cache/internal/fakedb"
+	"github.com/fengzhu0601/gotools/logger"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "cache_test")
+	if err != nil {
+		panic(err)
+	}
+	logger.InitLogger(filepath.Join(dir, "cache.log"), false)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+type testItem struct {
+	Sid  uint64 `gorm:"primaryKey"`
+	Id   uint32 `gorm:"primaryKey"`
+	Num  int
+	Name string
+}
+
+var testItemType = reflect.TypeOf(testItem{})
+
+// 使用假数据库的cache，不启动更新协程，测试结束时停止容器的后台协程
+func newTestCache(t *testing.T, db *fakedb.DB) *Cache {
+	ctx, cancel := context.WithCancel(context.Background())
+	cache := &Cache{
+		containers: make(containerMap),
+		dbConfig:   &DBConfig{UpdateGap: 1, UpdateSize: 100, GCSeconds: 60},
+		dbCon:      db.Gorm(),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	t.Cleanup(func() {
+		cancel()
+		cache.wg.Wait()
+	})
+	return cache
+}
+
+// 新建使用假数据库的容器
+func newTestContainer(t *testing.T, db *fakedb.DB, objType reflect.Type, opts ...ContainerOption) *Container {
+	cache := newTestCache(t, db)
+	cache.InitContainer(objType, opts...)
+	db.Reset()
+	return cache.containers[objType]
+}
@@ -11,14 +11,17 @@ type Cargo struct {
 
 // key集合(单主键)
 type cargoKey struct {
-	Sid uint32
+	Sid uint64
 }
 
-func (c *Cargo) CollectChangedObjs(sid uint32, updateMetas *[]interface{}, deleteKeys *[]interface{}, syncDb bool) (objNum uint32) {
+func (c *Cargo) CollectChangedObjs(sid uint64, updateMetas *[]interface{}, deleteKeys *[]interface{}, syncDb bool) (objNum uint32) {
 	if c.meta.dbFlag&FLAG_DELETE != 0 {
 		*deleteKeys = append(*deleteKeys, &cargoKey{Sid: sid})
 		objNum = 1
 	} else if c.meta.dbFlag&FLAG_UPDATE != 0 {
+		if syncDb {
+			beforeFlush(c.meta.obj)
+		}
 		*updateMetas = append(*updateMetas, c.meta.obj)
 		objNum = 1
 	}
@@ -48,10 +51,25 @@ func (c *Cargo) CollectAllObjs(objs *[]interface{}) {
 	}
 }
 
+func (c *Cargo) RangeObjs(fn func(obj interface{}) bool) bool {
+	if c.meta.obj != nil {
+		return fn(c.meta.obj)
+	}
+	return true
+}
+
 func (c *Cargo) GetSingleObj(_ ...uint32) interface{} {
 	return c.meta.obj
 }
 
+func (c *Cargo) GetObj(_ ...interface{}) interface{} {
+	return c.meta.obj
+}
+
+func (c *Cargo) GetPrefixObjs(_ ...interface{}) []interface{} {
+	return c.GetSomeObjs()
+}
+
 func (c *Cargo) GetSomeObjs(_ ...uint32) []interface{} {
 	list := make([]interface{}, 0)
 	if c.meta.obj != nil {
@@ -75,6 +93,22 @@ func (c *Cargo) Replace(obj interface{}) {
 	c.status = STATUS_CHANGE
 }
 
+func (c *Cargo) ReplaceSynced(obj interface{}) {
+	c.meta.Synced(obj)
+}
+
+func (c *Cargo) DeleteSynced(obj interface{}) {
+	c.meta.Synced(nil)
+}
+
+func (c *Cargo) ReplaceIfClean(obj interface{}) bool {
+	return c.meta.SyncedIfClean(obj)
+}
+
+func (c *Cargo) DeleteIfClean(obj interface{}) bool {
+	return c.meta.SyncedIfClean(nil)
+}
+
 func (c *Cargo) GetNextUid() uint32 {
 	return 0
 }
@@ -0,0 +1,325 @@
+package cargo
+
+import (
+	"reflect"
+	"sync"
+)
+
+// 全局载体(公会、拍卖等没有sid的全服数据)，按obj自身的主键存储
+//
+// 主键为obj的前keyNum个字段，可以是任意可比较的类型
+type CargoGlobal struct {
+	status  CargoStatus
+	metaM   map[interface{}]*meta
+	keyType reflect.Type // 主键结构(由主键字段组成，用于批量删除)
+	lock    sync.RWMutex
+}
+
+// 设置主键结构(新建载体后调用)
+func (c *CargoGlobal) SetKeyType(keyType reflect.Type) {
+	c.keyType = keyType
+}
+
+// obj的主键(单主键时为字段值，多主键时为主键结构的值)
+func (c *CargoGlobal) keyOf(v reflect.Value) interface{} {
+	if c.keyType.NumField() == 1 {
+		return v.Field(0).Interface()
+	}
+	key := reflect.New(c.keyType).Elem()
+	for i := 0; i < c.keyType.NumField(); i++ {
+		key.Field(i).Set(v.Field(i))
+	}
+	return key.Interface()
+}
+
+// 查询参数转换成主键，类型不匹配时返回false
+func (c *CargoGlobal) makeKey(keys []interface{}) (interface{}, bool) {
+	if len(keys) != c.keyType.NumField() {
+		return nil, false
+	}
+	key := reflect.New(c.keyType).Elem()
+	for i, k := range keys {
+		value, ok := convertKey(k, c.keyType.Field(i).Type)
+		if !ok {
+			return nil, false
+		}
+		key.Field(i).Set(value)
+	}
+	return c.keyOf(key), true
+}
+
+// 转换成主键字段的类型(整数之间可以互转，字符串只能用字符串查询)
+func convertKey(k interface{}, t reflect.Type) (reflect.Value, bool) {
+	v := reflect.ValueOf(k)
+	if !v.IsValid() {
+		return v, false
+	}
+	if v.Type() == t {
+		return v, true
+	}
+	if isInteger(v.Kind()) && isInteger(t.Kind()) {
+		return v.Convert(t), true
+	}
+	if v.Kind() == reflect.String && t.Kind() == reflect.String {
+		return v.Convert(t), true
+	}
+	return v, false
+}
+
+func isInteger(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// 删除用的主键结构
+func (c *CargoGlobal) deleteKey(key interface{}) interface{} {
+	ptr := reflect.New(c.keyType)
+	if c.keyType.NumField() == 1 {
+		ptr.Elem().Field(0).Set(reflect.ValueOf(key))
+	} else {
+		ptr.Elem().Set(reflect.ValueOf(key))
+	}
+	return ptr.Interface()
+}
+
+func (c *CargoGlobal) CollectChangedObjs(_ uint64, updateMetas *[]interface{}, deleteKeys *[]interface{}, syncDb bool) uint32 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	var objSize uint32 = 0
+	for key, meta := range c.metaM {
+		if meta.dbFlag&FLAG_DELETE != 0 {
+			*deleteKeys = append(*deleteKeys, c.deleteKey(key))
+			objSize++
+		} else if meta.dbFlag&FLAG_UPDATE != 0 {
+			if syncDb {
+				beforeFlush(meta.obj)
+			}
+			*updateMetas = append(*updateMetas, meta.obj)
+			objSize++
+		}
+	}
+	if syncDb {
+		c.status = STATUS_SYNC
+	}
+	return objSize
+}
+
+func (c *CargoGlobal) AfterSyncDB(isSuccess bool) {
+	if c.status == STATUS_SYNC {
+		if !isSuccess {
+			c.status = STATUS_CHANGE
+		} else {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			c.status = STATUS_NORMAL
+			for key, meta := range c.metaM {
+				// 全局数据不会整体回收，已删除的meta在同步后清理
+				if meta.obj == nil && meta.dbFlag&FLAG_DELETE != 0 {
+					delete(c.metaM, key)
+					continue
+				}
+				meta.dbFlag = FLAG_NONE
+			}
+		}
+	}
+}
+
+func (c *CargoGlobal) CollectAllObjs(objs *[]interface{}) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, meta := range c.metaM {
+		if meta.obj != nil {
+			*objs = append(*objs, meta.obj)
+		}
+	}
+}
+
+func (c *CargoGlobal) RangeObjs(fn func(obj interface{}) bool) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, meta := range c.metaM {
+		if meta.obj != nil && !fn(meta.obj) {
+			return false
+		}
+	}
+	return true
+}
+
+// 按任意类型的主键获取obj
+func (c *CargoGlobal) GetObj(keys ...interface{}) interface{} {
+	key, ok := c.makeKey(keys)
+	if !ok {
+		return nil
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	meta, exit := c.metaM[key]
+	if !exit {
+		return nil
+	}
+	return meta.obj
+}
+
+func (c *CargoGlobal) GetSingleObj(keys ...uint32) interface{} {
+	return c.GetObj(toInterfaces(keys)...)
+}
+
+// 按主键前缀获取obj(不传key时获取所有obj)
+func (c *CargoGlobal) GetSomeObjs(keys ...uint32) []interface{} {
+	return c.GetPrefixObjs(toInterfaces(keys)...)
+}
+
+// 按任意类型的主键前缀获取obj
+func (c *CargoGlobal) GetPrefixObjs(keys ...interface{}) []interface{} {
+	list := make([]interface{}, 0)
+	prefix := make([]reflect.Value, 0, len(keys))
+	for i, k := range keys {
+		if i >= c.keyType.NumField() {
+			return list
+		}
+		value, ok := convertKey(k, c.keyType.Field(i).Type)
+		if !ok {
+			return list
+		}
+		prefix = append(prefix, value)
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, meta := range c.metaM {
+		if meta.obj == nil {
+			continue
+		}
+		v := reflect.ValueOf(meta.obj).Elem()
+		match := true
+		for i, value := range prefix {
+			if v.Field(i).Interface() != value.Interface() {
+				match = false
+				break
+			}
+		}
+		if match {
+			list = append(list, meta.obj)
+		}
+	}
+	return list
+}
+
+func toInterfaces(keys []uint32) []interface{} {
+	list := make([]interface{}, len(keys))
+	for i, key := range keys {
+		list[i] = key
+	}
+	return list
+}
+
+func (c *CargoGlobal) Replace(obj interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := c.keyOf(reflect.ValueOf(obj).Elem())
+	r, exit := c.metaM[key]
+	if !exit {
+		r = &meta{}
+		c.metaM[key] = r
+	}
+	r.Update(obj)
+	c.status = STATUS_CHANGE
+}
+
+func (c *CargoGlobal) DeleteObj(obj interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	r, exit := c.metaM[c.keyOf(reflect.ValueOf(obj).Elem())]
+	if !exit {
+		return
+	}
+	r.DeleteObj()
+	c.status = STATUS_CHANGE
+}
+
+func (c *CargoGlobal) ReplaceSynced(obj interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := c.keyOf(reflect.ValueOf(obj).Elem())
+	r, exit := c.metaM[key]
+	if !exit {
+		r = &meta{}
+		c.metaM[key] = r
+	}
+	r.Synced(obj)
+}
+
+func (c *CargoGlobal) DeleteSynced(obj interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := c.keyOf(reflect.ValueOf(obj).Elem())
+	if _, exit := c.metaM[key]; exit {
+		delete(c.metaM, key)
+	}
+}
+
+func (c *CargoGlobal) ReplaceIfClean(obj interface{}) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := c.keyOf(reflect.ValueOf(obj).Elem())
+	r, exit := c.metaM[key]
+	if !exit {
+		r = &meta{}
+		c.metaM[key] = r
+	}
+	return r.SyncedIfClean(obj)
+}
+
+func (c *CargoGlobal) DeleteIfClean(obj interface{}) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := c.keyOf(reflect.ValueOf(obj).Elem())
+	r, exit := c.metaM[key]
+	if !exit {
+		return true
+	}
+	if r.dbFlag != FLAG_NONE {
+		return false
+	}
+	delete(c.metaM, key)
+	return true
+}
+
+func (c *CargoGlobal) DeleteObjs() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, r := range c.metaM {
+		r.DeleteObj()
+	}
+	c.status = STATUS_CHANGE
+}
+
+// 单个整数主键时返回最大主键+1，其他主键返回0
+func (c *CargoGlobal) GetNextUid() uint32 {
+	if c.keyType.NumField() != 1 || !isInteger(c.keyType.Field(0).Type.Kind()) {
+		return 0
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	var uid uint32 = 1
+	for k := range c.metaM {
+		key := uint32(reflect.ValueOf(k).Convert(reflect.TypeOf(uid)).Uint())
+		if key >= uid {
+			uid = key + 1
+		}
+	}
+	return uid
+}
+
+func (c *CargoGlobal) CargoInit() {
+	c.metaM = make(map[interface{}]*meta)
+}
+
+func (c *CargoGlobal) LoadDBData(element reflect.Value) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.metaM[c.keyOf(element.Elem())] = &meta{obj: element.Interface()}
+}
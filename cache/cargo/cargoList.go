@@ -0,0 +1,127 @@
+package cargo
+
+import (
+	"reflect"
+)
+
+// 定长列表载体(双主键，第二主键为自增序号)：邮件、战报、聊天记录等只追加的数据，只保留最新的cap条
+//
+// 超出上限的旧数据标记为删除，由updater批量从数据库删除
+type CargoList struct {
+	CargoOrdered
+	cap     int    // 保留的最大条数(<=0表示不限制)
+	nextSeq uint32 // 下一个序号
+}
+
+// 设置保留的最大条数
+func (c *CargoList) SetCap(cap int) {
+	c.cap = cap
+}
+
+func (c *CargoList) CargoInit() {
+	c.CargoOrdered.CargoInit()
+	c.nextSeq = 1
+}
+
+func (c *CargoList) LoadDBData(element reflect.Value) {
+	c.CargoOrdered.LoadDBData(element)
+	seq := uint32(element.Elem().Field(1).Uint())
+	if seq >= c.nextSeq {
+		c.nextSeq = seq + 1
+	}
+}
+
+func (c *CargoList) GetNextUid() uint32 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.nextSeq
+}
+
+func (c *CargoList) Replace(obj interface{}) {
+	c.CargoOrdered.Replace(obj)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	seq := uint32(reflect.ValueOf(obj).Elem().Field(1).Uint())
+	if seq >= c.nextSeq {
+		c.nextSeq = seq + 1
+	}
+}
+
+// 追加一条数据(自动设置序号)，返回超出上限被淘汰的旧数据
+func (c *CargoList) Append(obj interface{}) []interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	reflect.ValueOf(obj).Elem().Field(1).SetUint(uint64(c.nextSeq))
+	c.getOrAdd(c.nextSeq).Update(obj)
+	c.nextSeq++
+	c.status = STATUS_CHANGE
+	return c.trim()
+}
+
+// 淘汰超出上限的旧数据(调用时已加写锁)
+func (c *CargoList) trim() []interface{} {
+	evicted := make([]interface{}, 0)
+	if c.cap <= 0 {
+		return evicted
+	}
+	live := 0
+	for _, secondKey := range c.keys {
+		if c.metaM[secondKey].obj != nil {
+			live++
+		}
+	}
+	for _, secondKey := range c.keys {
+		if live <= c.cap {
+			break
+		}
+		meta := c.metaM[secondKey]
+		if meta.obj != nil {
+			evicted = append(evicted, meta.obj)
+			meta.DeleteObj()
+			live--
+		}
+	}
+	return evicted
+}
+
+// 最新的n条数据(按序号从旧到新排列，n超出[0, 数据条数]时取边界值)
+func (c *CargoList) Latest(n int) []interface{} {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if n < 0 {
+		n = 0
+	}
+	if n > len(c.keys) {
+		n = len(c.keys)
+	}
+	list := make([]interface{}, 0, n)
+	for i := len(c.keys) - 1; i >= 0 && len(list) < n; i-- {
+		if meta := c.metaM[c.keys[i]]; meta.obj != nil {
+			list = append(list, meta.obj)
+		}
+	}
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list
+}
+
+func (c *CargoList) AfterSyncDB(isSuccess bool) {
+	c.CargoOrdered.AfterSyncDB(isSuccess)
+	if !isSuccess || c.status != STATUS_NORMAL {
+		return
+	}
+	// 已从数据库删除的数据不再保留序号
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	keys := c.keys[:0]
+	for _, secondKey := range c.keys {
+		meta := c.metaM[secondKey]
+		if meta.obj == nil && meta.dbFlag == FLAG_NONE {
+			delete(c.metaM, secondKey)
+			continue
+		}
+		keys = append(keys, secondKey)
+	}
+	c.keys = keys
+}
@@ -0,0 +1,115 @@
+package cargo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestList(cap int, appendNum int) *CargoList {
+	c := &CargoList{}
+	c.CargoInit()
+	c.SetCap(cap)
+	for i := 0; i < appendNum; i++ {
+		c.Append(&orderedObj{Sid: 1})
+	}
+	return c
+}
+
+func TestCargoListAppend(t *testing.T) {
+	tests := []struct {
+		name    string
+		cap     int
+		num     int
+		want    []uint32 // 保留的序号
+		evicted []uint32 // 最后一次追加淘汰的序号
+	}{
+		{"under cap", 5, 3, []uint32{1, 2, 3}, []uint32{}},
+		{"at cap", 3, 3, []uint32{1, 2, 3}, []uint32{}},
+		{"over cap", 3, 5, []uint32{3, 4, 5}, []uint32{2}},
+		{"cap one", 1, 4, []uint32{4}, []uint32{3}},
+		{"no cap", 0, 4, []uint32{1, 2, 3, 4}, []uint32{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestList(tt.cap, tt.num-1)
+			obj := &orderedObj{Sid: 1}
+			evicted := c.Append(obj)
+			if obj.Id != uint32(tt.num) {
+				t.Fatalf("Append() seq = %d, want %d", obj.Id, tt.num)
+			}
+			if got := objIds(evicted); !reflect.DeepEqual(got, tt.evicted) {
+				t.Fatalf("Append() evicted = %v, want %v", got, tt.evicted)
+			}
+			if got := objIds(c.GetSomeObjs()); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("objs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCargoListLatest(t *testing.T) {
+	c := newTestList(4, 6)
+	tests := []struct {
+		name string
+		n    int
+		want []uint32
+	}{
+		{"zero", 0, []uint32{}},
+		{"negative", -1, []uint32{}},
+		{"some", 2, []uint32{5, 6}},
+		{"all", 4, []uint32{3, 4, 5, 6}},
+		{"over len", 100, []uint32{3, 4, 5, 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := objIds(c.Latest(tt.n)); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Latest(%d) = %v, want %v", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCargoListSeq(t *testing.T) {
+	tests := []struct {
+		name   string
+		loaded []uint32
+		want   uint32
+	}{
+		{"empty", nil, 1},
+		{"loaded", []uint32{3, 7, 5}, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestList(10, 0)
+			for _, id := range tt.loaded {
+				c.LoadDBData(reflect.ValueOf(&orderedObj{Sid: 1, Id: id}))
+			}
+			if got := c.GetNextUid(); got != tt.want {
+				t.Fatalf("GetNextUid() = %d, want %d", got, tt.want)
+			}
+			obj := &orderedObj{Sid: 1}
+			c.Append(obj)
+			if obj.Id != tt.want {
+				t.Fatalf("Append() seq = %d, want %d", obj.Id, tt.want)
+			}
+		})
+	}
+}
+
+// 写库成功后淘汰的序号不再保留，序号继续递增
+func TestCargoListAfterSync(t *testing.T) {
+	c := newTestList(2, 4)
+	updates := make([]interface{}, 0)
+	deletes := make([]interface{}, 0)
+	c.CollectChangedObjs(1, &updates, &deletes, true)
+	if !reflect.DeepEqual(objIds(updates), []uint32{3, 4}) || len(deletes) != 2 {
+		t.Fatalf("CollectChangedObjs() updates %v deletes %d", objIds(updates), len(deletes))
+	}
+	c.AfterSyncDB(true)
+	if !reflect.DeepEqual(c.keys, []uint32{3, 4}) {
+		t.Fatalf("keys after sync = %v, want [3 4]", c.keys)
+	}
+	if got := c.GetNextUid(); got != 5 {
+		t.Fatalf("GetNextUid() = %d, want 5", got)
+	}
+}
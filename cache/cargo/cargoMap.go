@@ -5,7 +5,8 @@ import (
 	"sync"
 )
 
-type metaM map[uint32]*meta
+// 主键值 -> meta
+type metaM map[interface{}]*meta
 
 type CargoMap struct {
 	status CargoStatus
@@ -15,11 +16,11 @@ type CargoMap struct {
 
 // key集合(双主键)
 type cargoMapKey struct {
-	Sid       uint32
-	SecondKey uint32
+	Sid       uint64
+	SecondKey interface{}
 }
 
-func (c *CargoMap) CollectChangedObjs(sid uint32, updateMetas *[]interface{}, deleteKeys *[]interface{}, syncDb bool) uint32 {
+func (c *CargoMap) CollectChangedObjs(sid uint64, updateMetas *[]interface{}, deleteKeys *[]interface{}, syncDb bool) uint32 {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 	var objSize uint32 = 0
@@ -28,6 +29,9 @@ func (c *CargoMap) CollectChangedObjs(sid uint32, updateMetas *[]interface{}, de
 			*deleteKeys = append(*deleteKeys, &cargoMapKey{Sid: sid, SecondKey: secondKey})
 			objSize++
 		} else if meta.dbFlag&FLAG_UPDATE != 0 {
+			if syncDb {
+				beforeFlush(meta.obj)
+			}
 			*updateMetas = append(*updateMetas, meta.obj)
 			objSize++
 		}
@@ -65,22 +69,47 @@ func (c *CargoMap) CollectAllObjs(objs *[]interface{}) {
 	}
 }
 
+func (c *CargoMap) RangeObjs(fn func(obj interface{}) bool) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, meta := range c.metaM {
+		if meta.obj != nil && !fn(meta.obj) {
+			return false
+		}
+	}
+	return true
+}
+
 func (c *CargoMap) GetSingleObj(keys ...uint32) interface{} {
-	return c.getObj(keys[0])
+	return c.getObj(uint64(keys[0]))
 }
 
 func (c *CargoMap) GetSomeObjs(keys ...uint32) []interface{} {
+	return c.GetPrefixObjs(Keys(keys)...)
+}
+
+func (c *CargoMap) GetObj(keys ...interface{}) interface{} {
+	return c.getObj(Key(keys[0]))
+}
+
+func (c *CargoMap) GetPrefixObjs(keys ...interface{}) []interface{} {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	list := make([]interface{}, 0)
 	keySize := len(keys)
+	var key interface{}
+	if keySize > 0 {
+		key = Key(keys[0])
+	}
 	for secondKey, meta := range c.metaM {
-		if (keySize == 0 || secondKey == keys[0]) && meta.obj != nil {
+		if (keySize == 0 || secondKey == key) && meta.obj != nil {
 			list = append(list, meta.obj)
 		}
 	}
 	return list
 }
 
-func (c *CargoMap) getObj(secondKey uint32) interface{} {
+func (c *CargoMap) getObj(secondKey interface{}) interface{} {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 	meta, exit := c.metaM[secondKey]
@@ -93,7 +122,7 @@ func (c *CargoMap) getObj(secondKey uint32) interface{} {
 func (c *CargoMap) Replace(obj interface{}) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	secondKey := uint32(reflect.ValueOf(obj).Elem().Field(1).Uint())
+	secondKey := KeyOf(reflect.ValueOf(obj).Elem().Field(1))
 	r, exit := c.metaM[secondKey]
 	if !exit {
 		newMeta := &meta{}
@@ -107,7 +136,7 @@ func (c *CargoMap) Replace(obj interface{}) {
 func (c *CargoMap) DeleteObj(obj interface{}) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	secondKey := uint32(reflect.ValueOf(obj).Elem().Field(1).Uint())
+	secondKey := KeyOf(reflect.ValueOf(obj).Elem().Field(1))
 	r, exit := c.metaM[secondKey]
 	if !exit {
 		return
@@ -116,6 +145,52 @@ func (c *CargoMap) DeleteObj(obj interface{}) {
 	c.status = STATUS_CHANGE
 }
 
+func (c *CargoMap) ReplaceSynced(obj interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	secondKey := KeyOf(reflect.ValueOf(obj).Elem().Field(1))
+	r, exit := c.metaM[secondKey]
+	if !exit {
+		r = &meta{}
+		c.metaM[secondKey] = r
+	}
+	r.Synced(obj)
+}
+
+func (c *CargoMap) DeleteSynced(obj interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	secondKey := KeyOf(reflect.ValueOf(obj).Elem().Field(1))
+	r, exit := c.metaM[secondKey]
+	if !exit {
+		return
+	}
+	r.Synced(nil)
+}
+
+func (c *CargoMap) ReplaceIfClean(obj interface{}) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	secondKey := KeyOf(reflect.ValueOf(obj).Elem().Field(1))
+	r, exit := c.metaM[secondKey]
+	if !exit {
+		r = &meta{}
+		c.metaM[secondKey] = r
+	}
+	return r.SyncedIfClean(obj)
+}
+
+func (c *CargoMap) DeleteIfClean(obj interface{}) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	secondKey := KeyOf(reflect.ValueOf(obj).Elem().Field(1))
+	r, exit := c.metaM[secondKey]
+	if !exit {
+		return true
+	}
+	return r.SyncedIfClean(nil)
+}
+
 func (c *CargoMap) DeleteObjs() {
 	c.lock.Lock()
 	defer c.lock.Unlock()
@@ -130,8 +205,9 @@ func (c *CargoMap) GetNextUid() uint32 {
 	defer c.lock.RUnlock()
 	var uid uint32 = 1
 	for k := range c.metaM {
-		if k >= uid {
-			uid = k + 1
+		// 非整数的主键不参与
+		if key, ok := Uint32Key(k); ok && key >= uid {
+			uid = key + 1
 		}
 	}
 	return uid
@@ -144,6 +220,6 @@ func (c *CargoMap) CargoInit() {
 func (c *CargoMap) LoadDBData(element reflect.Value) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	secondKey := uint32(element.Elem().Field(1).Uint())
+	secondKey := KeyOf(element.Elem().Field(1))
 	c.metaM[secondKey] = &meta{obj: element.Interface()}
 }
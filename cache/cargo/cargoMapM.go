@@ -5,7 +5,8 @@ import (
 	"sync"
 )
 
-type metaMM map[uint32]metaM
+// 第二主键值 -> 第三主键值 -> meta
+type metaMM map[interface{}]metaM
 
 type CargoMapM struct {
 	status CargoStatus
@@ -15,12 +16,12 @@ type CargoMapM struct {
 
 // key集合(三主键)
 type cargoMapMKey struct {
-	Sid       uint32
-	SecondKey uint32
-	ThirdKey  uint32
+	Sid       uint64
+	SecondKey interface{}
+	ThirdKey  interface{}
 }
 
-func (c *CargoMapM) CollectChangedObjs(sid uint32, updateMetas *[]interface{}, deleteKeys *[]interface{}, syncDb bool) uint32 {
+func (c *CargoMapM) CollectChangedObjs(sid uint64, updateMetas *[]interface{}, deleteKeys *[]interface{}, syncDb bool) uint32 {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 	var objSize uint32 = 0
@@ -30,6 +31,9 @@ func (c *CargoMapM) CollectChangedObjs(sid uint32, updateMetas *[]interface{}, d
 				*deleteKeys = append(*deleteKeys, &cargoMapMKey{Sid: sid, SecondKey: secondKey, ThirdKey: thirdKey})
 				objSize++
 			} else if meta.dbFlag&FLAG_UPDATE != 0 {
+				if syncDb {
+					beforeFlush(meta.obj)
+				}
 				*updateMetas = append(*updateMetas, meta.obj)
 				objSize++
 			}
@@ -71,17 +75,44 @@ func (c *CargoMapM) CollectAllObjs(objs *[]interface{}) {
 	}
 }
 
+func (c *CargoMapM) RangeObjs(fn func(obj interface{}) bool) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, metaM := range c.metaMM {
+		for _, meta := range metaM {
+			if meta.obj != nil && !fn(meta.obj) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func (c *CargoMapM) GetSingleObj(keys ...uint32) interface{} {
-	return c.getObj(keys[0], keys[1])
+	return c.getObj(uint64(keys[0]), uint64(keys[1]))
 }
 
 func (c *CargoMapM) GetSomeObjs(keys ...uint32) []interface{} {
+	return c.GetPrefixObjs(Keys(keys)...)
+}
+
+func (c *CargoMapM) GetObj(keys ...interface{}) interface{} {
+	return c.getObj(Key(keys[0]), Key(keys[1]))
+}
+
+func (c *CargoMapM) GetPrefixObjs(keys ...interface{}) []interface{} {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	list := make([]interface{}, 0)
 	keySize := len(keys)
+	prefix := make([]interface{}, keySize)
+	for i, key := range keys {
+		prefix[i] = Key(key)
+	}
 	for secondKey, metaM := range c.metaMM {
-		if keySize < 1 || secondKey == keys[0] {
+		if keySize < 1 || secondKey == prefix[0] {
 			for thirdKey, meta := range metaM {
-				if (keySize < 2 || thirdKey == keys[1]) && meta.obj != nil {
+				if (keySize < 2 || thirdKey == prefix[1]) && meta.obj != nil {
 					list = append(list, meta.obj)
 				}
 			}
@@ -90,7 +121,7 @@ func (c *CargoMapM) GetSomeObjs(keys ...uint32) []interface{} {
 	return list
 }
 
-func (c *CargoMapM) getObj(secondKey uint32, thirdKey uint32) interface{} {
+func (c *CargoMapM) getObj(secondKey interface{}, thirdKey interface{}) interface{} {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 	metaM, exit := c.metaMM[secondKey]
@@ -107,8 +138,8 @@ func (c *CargoMapM) getObj(secondKey uint32, thirdKey uint32) interface{} {
 func (c *CargoMapM) Replace(obj interface{}) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	secondKey := uint32(reflect.ValueOf(obj).Elem().Field(1).Uint())
-	thirdKey := uint32(reflect.ValueOf(obj).Elem().Field(2).Uint())
+	secondKey := KeyOf(reflect.ValueOf(obj).Elem().Field(1))
+	thirdKey := KeyOf(reflect.ValueOf(obj).Elem().Field(2))
 	metM, exit := c.metaMM[secondKey]
 	c.status = STATUS_CHANGE
 	if !exit {
@@ -130,8 +161,8 @@ func (c *CargoMapM) Replace(obj interface{}) {
 func (c *CargoMapM) DeleteObj(obj interface{}) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	secondKey := uint32(reflect.ValueOf(obj).Elem().Field(1).Uint())
-	thirdKey := uint32(reflect.ValueOf(obj).Elem().Field(2).Uint())
+	secondKey := KeyOf(reflect.ValueOf(obj).Elem().Field(1))
+	thirdKey := KeyOf(reflect.ValueOf(obj).Elem().Field(2))
 	metaM, exit := c.metaMM[secondKey]
 	if !exit {
 		return
@@ -144,6 +175,74 @@ func (c *CargoMapM) DeleteObj(obj interface{}) {
 	c.status = STATUS_CHANGE
 }
 
+func (c *CargoMapM) ReplaceSynced(obj interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	secondKey := KeyOf(reflect.ValueOf(obj).Elem().Field(1))
+	thirdKey := KeyOf(reflect.ValueOf(obj).Elem().Field(2))
+	metM, exit := c.metaMM[secondKey]
+	if !exit {
+		metM = metaM{}
+		c.metaMM[secondKey] = metM
+	}
+	met, exit := metM[thirdKey]
+	if !exit {
+		met = &meta{}
+		metM[thirdKey] = met
+	}
+	met.Synced(obj)
+}
+
+func (c *CargoMapM) DeleteSynced(obj interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	secondKey := KeyOf(reflect.ValueOf(obj).Elem().Field(1))
+	thirdKey := KeyOf(reflect.ValueOf(obj).Elem().Field(2))
+	metaM, exit := c.metaMM[secondKey]
+	if !exit {
+		return
+	}
+	meta, exit := metaM[thirdKey]
+	if !exit {
+		return
+	}
+	meta.Synced(nil)
+}
+
+func (c *CargoMapM) ReplaceIfClean(obj interface{}) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	secondKey := KeyOf(reflect.ValueOf(obj).Elem().Field(1))
+	thirdKey := KeyOf(reflect.ValueOf(obj).Elem().Field(2))
+	metM, exit := c.metaMM[secondKey]
+	if !exit {
+		metM = metaM{}
+		c.metaMM[secondKey] = metM
+	}
+	met, exit := metM[thirdKey]
+	if !exit {
+		met = &meta{}
+		metM[thirdKey] = met
+	}
+	return met.SyncedIfClean(obj)
+}
+
+func (c *CargoMapM) DeleteIfClean(obj interface{}) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	secondKey := KeyOf(reflect.ValueOf(obj).Elem().Field(1))
+	thirdKey := KeyOf(reflect.ValueOf(obj).Elem().Field(2))
+	metaM, exit := c.metaMM[secondKey]
+	if !exit {
+		return true
+	}
+	meta, exit := metaM[thirdKey]
+	if !exit {
+		return true
+	}
+	return meta.SyncedIfClean(nil)
+}
+
 func (c *CargoMapM) DeleteObjs() {
 	c.lock.Lock()
 	defer c.lock.Unlock()
@@ -160,8 +259,8 @@ func (c *CargoMapM) GetNextUid() uint32 {
 	defer c.lock.RUnlock()
 	var uid uint32 = 1
 	for k := range c.metaMM {
-		if k >= uid {
-			uid = k + 1
+		if key, ok := Uint32Key(k); ok && key >= uid {
+			uid = key + 1
 		}
 	}
 	return uid
@@ -174,9 +273,10 @@ func (c *CargoMapM) CargoInit() {
 func (c *CargoMapM) LoadDBData(element reflect.Value) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	secondKey := uint32(element.Elem().Field(1).Uint())
-	thirdKey := uint32(element.Elem().Field(2).Uint())
-	c.metaMM = metaMM{}
-	c.metaMM[secondKey] = metaM{}
+	secondKey := KeyOf(element.Elem().Field(1))
+	thirdKey := KeyOf(element.Elem().Field(2))
+	if _, exit := c.metaMM[secondKey]; !exit {
+		c.metaMM[secondKey] = metaM{}
+	}
 	c.metaMM[secondKey][thirdKey] = &meta{obj: element.Interface()}
 }
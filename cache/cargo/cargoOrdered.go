@@ -0,0 +1,230 @@
+package cargo
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// 有序载体(双主键)：按第二主键有序遍历，支持按第二主键区间查询
+//
+// 第二主键保存在有序数组中，插入新key是O(n)，适合单个玩家数据量不大的表(如背包)
+type CargoOrdered struct {
+	status CargoStatus
+	keys   []uint32 // 有序的第二主键
+	metaM  map[uint32]*meta
+	lock   sync.RWMutex
+}
+
+func (c *CargoOrdered) CollectChangedObjs(sid uint64, updateMetas *[]interface{}, deleteKeys *[]interface{}, syncDb bool) uint32 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	var objSize uint32 = 0
+	for _, secondKey := range c.keys {
+		meta := c.metaM[secondKey]
+		if meta.dbFlag&FLAG_DELETE != 0 {
+			*deleteKeys = append(*deleteKeys, &cargoMapKey{Sid: sid, SecondKey: secondKey})
+			objSize++
+		} else if meta.dbFlag&FLAG_UPDATE != 0 {
+			if syncDb {
+				beforeFlush(meta.obj)
+			}
+			*updateMetas = append(*updateMetas, meta.obj)
+			objSize++
+		}
+	}
+	if syncDb {
+		c.status = STATUS_SYNC
+	}
+	return objSize
+}
+
+func (c *CargoOrdered) AfterSyncDB(isSuccess bool) {
+	if c.status == STATUS_SYNC {
+		// 处于同步状态的数据，才处理数据库同步后的操作
+		if !isSuccess {
+			// 同步数据库失败，则变回变更状态，等待下次同步
+			c.status = STATUS_CHANGE
+		} else {
+			// 同步成功，同步状态变成普通状态
+			c.status = STATUS_NORMAL
+			for _, meta := range c.metaM {
+				meta.dbFlag = FLAG_NONE
+			}
+		}
+	}
+}
+
+func (c *CargoOrdered) CollectAllObjs(objs *[]interface{}) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, secondKey := range c.keys {
+		if meta := c.metaM[secondKey]; meta.obj != nil {
+			*objs = append(*objs, meta.obj)
+		}
+	}
+}
+
+func (c *CargoOrdered) RangeObjs(fn func(obj interface{}) bool) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, secondKey := range c.keys {
+		if meta := c.metaM[secondKey]; meta.obj != nil && !fn(meta.obj) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *CargoOrdered) GetSingleObj(keys ...uint32) interface{} {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	meta, exit := c.metaM[keys[0]]
+	if !exit {
+		return nil
+	}
+	return meta.obj
+}
+
+func (c *CargoOrdered) GetSomeObjs(keys ...uint32) []interface{} {
+	if len(keys) > 0 {
+		return c.GetRangeObjs(keys[0], keys[0])
+	}
+	list := make([]interface{}, 0, len(c.keys))
+	c.CollectAllObjs(&list)
+	return list
+}
+
+// 按主键值获取obj(有序载体的第二主键为uint32)
+func (c *CargoOrdered) GetObj(keys ...interface{}) interface{} {
+	key, ok := Uint32Key(Key(keys[0]))
+	if !ok {
+		return nil
+	}
+	return c.GetSingleObj(key)
+}
+
+func (c *CargoOrdered) GetPrefixObjs(keys ...interface{}) []interface{} {
+	if len(keys) == 0 {
+		return c.GetSomeObjs()
+	}
+	key, ok := Uint32Key(Key(keys[0]))
+	if !ok {
+		return make([]interface{}, 0)
+	}
+	return c.GetSomeObjs(key)
+}
+
+// 第二主键在[fromKey, toKey]区间内的obj，按第二主键排序
+func (c *CargoOrdered) GetRangeObjs(fromKey uint32, toKey uint32) []interface{} {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	list := make([]interface{}, 0)
+	i := sort.Search(len(c.keys), func(i int) bool { return c.keys[i] >= fromKey })
+	for ; i < len(c.keys) && c.keys[i] <= toKey; i++ {
+		if meta := c.metaM[c.keys[i]]; meta.obj != nil {
+			list = append(list, meta.obj)
+		}
+	}
+	return list
+}
+
+// 获取meta，不存在时按顺序插入(调用时已加写锁)
+func (c *CargoOrdered) getOrAdd(secondKey uint32) *meta {
+	r, exit := c.metaM[secondKey]
+	if exit {
+		return r
+	}
+	r = &meta{}
+	c.metaM[secondKey] = r
+	i := sort.Search(len(c.keys), func(i int) bool { return c.keys[i] >= secondKey })
+	c.keys = append(c.keys, 0)
+	copy(c.keys[i+1:], c.keys[i:])
+	c.keys[i] = secondKey
+	return r
+}
+
+func (c *CargoOrdered) Replace(obj interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	secondKey := uint32(reflect.ValueOf(obj).Elem().Field(1).Uint())
+	c.getOrAdd(secondKey).Update(obj)
+	c.status = STATUS_CHANGE
+}
+
+func (c *CargoOrdered) DeleteObj(obj interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	secondKey := uint32(reflect.ValueOf(obj).Elem().Field(1).Uint())
+	r, exit := c.metaM[secondKey]
+	if !exit {
+		return
+	}
+	r.DeleteObj()
+	c.status = STATUS_CHANGE
+}
+
+func (c *CargoOrdered) ReplaceSynced(obj interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	secondKey := uint32(reflect.ValueOf(obj).Elem().Field(1).Uint())
+	c.getOrAdd(secondKey).Synced(obj)
+}
+
+func (c *CargoOrdered) DeleteSynced(obj interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	secondKey := uint32(reflect.ValueOf(obj).Elem().Field(1).Uint())
+	r, exit := c.metaM[secondKey]
+	if !exit {
+		return
+	}
+	r.Synced(nil)
+}
+
+func (c *CargoOrdered) ReplaceIfClean(obj interface{}) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	secondKey := uint32(reflect.ValueOf(obj).Elem().Field(1).Uint())
+	return c.getOrAdd(secondKey).SyncedIfClean(obj)
+}
+
+func (c *CargoOrdered) DeleteIfClean(obj interface{}) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	secondKey := uint32(reflect.ValueOf(obj).Elem().Field(1).Uint())
+	r, exit := c.metaM[secondKey]
+	if !exit {
+		return true
+	}
+	return r.SyncedIfClean(nil)
+}
+
+func (c *CargoOrdered) DeleteObjs() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, r := range c.metaM {
+		r.DeleteObj()
+	}
+	c.status = STATUS_CHANGE
+}
+
+func (c *CargoOrdered) GetNextUid() uint32 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if len(c.keys) == 0 {
+		return 1
+	}
+	return c.keys[len(c.keys)-1] + 1
+}
+
+func (c *CargoOrdered) CargoInit() {
+	c.metaM = make(map[uint32]*meta)
+}
+
+func (c *CargoOrdered) LoadDBData(element reflect.Value) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	secondKey := uint32(element.Elem().Field(1).Uint())
+	*c.getOrAdd(secondKey) = meta{obj: element.Interface()}
+}
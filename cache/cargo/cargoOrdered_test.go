@@ -0,0 +1,132 @@
+package cargo
+
+import (
+	"reflect"
+	"testing"
+)
+
+type orderedObj struct {
+	Sid uint64
+	Id  uint32
+	Num int
+}
+
+func newTestOrdered(ids ...uint32) *CargoOrdered {
+	c := &CargoOrdered{}
+	c.CargoInit()
+	for _, id := range ids {
+		c.Replace(&orderedObj{Sid: 1, Id: id})
+	}
+	return c
+}
+
+func objIds(objs []interface{}) []uint32 {
+	ids := make([]uint32, 0, len(objs))
+	for _, obj := range objs {
+		ids = append(ids, obj.(*orderedObj).Id)
+	}
+	return ids
+}
+
+func TestCargoOrderedRange(t *testing.T) {
+	c := newTestOrdered(5, 1, 9, 3, 7)
+	c.DeleteObj(&orderedObj{Sid: 1, Id: 7})
+	tests := []struct {
+		name string
+		from uint32
+		to   uint32
+		want []uint32
+	}{
+		{"all", 0, 100, []uint32{1, 3, 5, 9}},
+		{"inner", 2, 6, []uint32{3, 5}},
+		{"bounds inclusive", 3, 5, []uint32{3, 5}},
+		{"single", 9, 9, []uint32{9}},
+		{"skip deleted", 6, 8, []uint32{}},
+		{"below", 0, 0, []uint32{}},
+		{"above", 10, 20, []uint32{}},
+		{"reversed", 9, 1, []uint32{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := objIds(c.GetRangeObjs(tt.from, tt.to))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("GetRangeObjs(%d, %d) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCargoOrderedLookup(t *testing.T) {
+	c := newTestOrdered(5, 1, 9, 3)
+	tests := []struct {
+		name string
+		keys []interface{}
+		want []uint32
+	}{
+		{"all ordered", nil, []uint32{1, 3, 5, 9}},
+		{"uint32 key", []interface{}{uint32(3)}, []uint32{3}},
+		{"int key", []interface{}{5}, []uint32{5}},
+		{"missing key", []interface{}{4}, []uint32{}},
+		{"negative key", []interface{}{-1}, []uint32{}},
+		{"out of uint32", []interface{}{uint64(1) << 32}, []uint32{}},
+		{"string key", []interface{}{"1"}, []uint32{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := objIds(c.GetPrefixObjs(tt.keys...))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("GetPrefixObjs(%v) = %v, want %v", tt.keys, got, tt.want)
+			}
+			if len(tt.keys) == 0 {
+				return
+			}
+			obj := c.GetObj(tt.keys...)
+			if (obj != nil) != (len(tt.want) == 1) {
+				t.Fatalf("GetObj(%v) = %v, want %v", tt.keys, obj, tt.want)
+			}
+		})
+	}
+}
+
+func TestCargoOrderedNextUid(t *testing.T) {
+	tests := []struct {
+		name string
+		ids  []uint32
+		want uint32
+	}{
+		{"empty", nil, 1},
+		{"ordered", []uint32{1, 2, 3}, 4},
+		{"unordered", []uint32{8, 2, 5}, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newTestOrdered(tt.ids...).GetNextUid(); got != tt.want {
+				t.Fatalf("GetNextUid() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCargoOrderedChanged(t *testing.T) {
+	c := newTestOrdered()
+	c.LoadDBData(reflect.ValueOf(&orderedObj{Sid: 1, Id: 2}))
+	c.LoadDBData(reflect.ValueOf(&orderedObj{Sid: 1, Id: 4}))
+	c.Replace(&orderedObj{Sid: 1, Id: 3})
+	c.DeleteObj(&orderedObj{Sid: 1, Id: 4})
+
+	updates := make([]interface{}, 0)
+	deletes := make([]interface{}, 0)
+	num := c.CollectChangedObjs(1, &updates, &deletes, true)
+	if num != 2 || !reflect.DeepEqual(objIds(updates), []uint32{3}) || len(deletes) != 1 {
+		t.Fatalf("CollectChangedObjs() = %d updates %v deletes %v", num, objIds(updates), deletes)
+	}
+	if key := deletes[0].(*cargoMapKey); key.Sid != 1 || key.SecondKey != uint32(4) {
+		t.Fatalf("delete key = %+v, want sid 1 key 4", key)
+	}
+	c.AfterSyncDB(true)
+	updates = updates[:0]
+	deletes = deletes[:0]
+	if num := c.CollectChangedObjs(1, &updates, &deletes, false); num != 0 {
+		t.Fatalf("CollectChangedObjs() after sync = %d, want 0", num)
+	}
+}
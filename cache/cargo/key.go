@@ -0,0 +1,45 @@
+package cargo
+
+import "reflect"
+
+// 主键值：整数统一转换成uint64(负数为int64)，字符串保持不变
+//
+// 不同整数类型的同一个值得到相同的主键值，载体map的key、批量删除参数和查询参数都经过这个转换
+func KeyOf(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Int() < 0 {
+			return v.Int()
+		}
+		return uint64(v.Int())
+	case reflect.String:
+		return v.String()
+	default:
+		return v.Interface()
+	}
+}
+
+// 查询参数转换成主键值
+func Key(k interface{}) interface{} {
+	return KeyOf(reflect.ValueOf(k))
+}
+
+// uint32查询参数转换成主键值
+func Keys(keys []uint32) []interface{} {
+	list := make([]interface{}, len(keys))
+	for i, key := range keys {
+		list[i] = uint64(key)
+	}
+	return list
+}
+
+// 主键值转换成uint32(兼容uint32的接口，超出范围或非整数时返回false)
+func Uint32Key(k interface{}) (uint32, bool) {
+	u, ok := k.(uint64)
+	if !ok || u > 1<<32-1 {
+		return 0, false
+	}
+	return uint32(u), true
+}
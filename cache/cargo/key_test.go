@@ -0,0 +1,69 @@
+package cargo
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestKeyOf(t *testing.T) {
+	type myString string
+	tests := []struct {
+		name string
+		key  interface{}
+		want interface{}
+	}{
+		{"uint8", uint8(1), uint64(1)},
+		{"uint32", uint32(1), uint64(1)},
+		{"uint64 max", uint64(math.MaxUint64), uint64(math.MaxUint64)},
+		{"int", 1, uint64(1)},
+		{"int zero", int16(0), uint64(0)},
+		{"int negative", -1, int64(-1)},
+		{"int64 min", int64(math.MinInt64), int64(math.MinInt64)},
+		{"string", "a", "a"},
+		{"named string", myString("a"), "a"},
+		{"other", 1.5, 1.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := KeyOf(reflect.ValueOf(tt.key)); got != tt.want {
+				t.Fatalf("KeyOf(%v) = %#v, want %#v", tt.key, got, tt.want)
+			}
+			if got := Key(tt.key); got != tt.want {
+				t.Fatalf("Key(%v) = %#v, want %#v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUint32Key(t *testing.T) {
+	tests := []struct {
+		name string
+		key  interface{}
+		want uint32
+		ok   bool
+	}{
+		{"zero", uint64(0), 0, true},
+		{"max", uint64(math.MaxUint32), math.MaxUint32, true},
+		{"over max", uint64(math.MaxUint32) + 1, 0, false},
+		{"negative", int64(-1), 0, false},
+		{"string", "1", 0, false},
+		{"not normalized", uint32(1), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Uint32Key(tt.key)
+			if got != tt.want || ok != tt.ok {
+				t.Fatalf("Uint32Key(%#v) = %d %v, want %d %v", tt.key, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestKeys(t *testing.T) {
+	got := Keys([]uint32{1, math.MaxUint32})
+	want := []interface{}{uint64(1), uint64(math.MaxUint32)}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Keys() = %#v, want %#v", got, want)
+	}
+}
@@ -12,6 +12,28 @@ func (r *meta) Update(i interface{}) {
 	r.dbFlag = FLAG_UPDATE
 }
 
+// 已同步到数据库的meta对象(obj为nil表示已删除)
+func (r *meta) Synced(i interface{}) {
+	r.obj = i
+	r.dbFlag = FLAG_NONE
+}
+
+// 没有未写入数据库的变更时更新为已同步的obj，返回是否更新
+func (r *meta) SyncedIfClean(i interface{}) bool {
+	if r.dbFlag != FLAG_NONE {
+		return false
+	}
+	r.Synced(i)
+	return true
+}
+
+// 准备写入数据库前调用obj的BeforeFlush(即cache.BeforeFlusher)，在收集变更的锁内调用，不会和Replace交错
+func beforeFlush(obj interface{}) {
+	if flusher, ok := obj.(interface{ BeforeFlush() }); ok {
+		flusher.BeforeFlush()
+	}
+}
+
 // 删除meta对象
 func (r *meta) DeleteObj() {
 	if r.obj != nil {
@@ -9,21 +9,55 @@ type CargoInt interface {
 	// 载体载入数据库数据
 	LoadDBData(reflect.Value)
 	// 收集变更的obj数据
-	CollectChangedObjs(uint32, *[]interface{}, *[]interface{}, bool) uint32
+	CollectChangedObjs(uint64, *[]interface{}, *[]interface{}, bool) uint32
 	// 同步数据库后调用
 	AfterSyncDB(isSuccess bool)
 	// 获取所有obj
 	CollectAllObjs(*[]interface{})
+	// 在读锁内遍历所有obj，fn返回false时停止遍历并返回false
+	RangeObjs(fn func(obj interface{}) bool) bool
 	// 更新或插入某个obj
 	Replace(interface{})
 	// 获取单个obj
 	GetSingleObj(keys ...uint32) interface{}
 	// 获取单个obj
 	GetSomeObjs(keys ...uint32) []interface{}
+	// 按任意类型的主键获取单个obj(整数之间可以互转)
+	GetObj(keys ...interface{}) interface{}
+	// 按任意类型的主键前缀获取obj
+	GetPrefixObjs(keys ...interface{}) []interface{}
 	// 删除某个obj
 	DeleteObj(interface{})
 	// 删除说有obj
 	DeleteObjs()
+	// 更新或插入已写入数据库的obj(不标记变更)
+	ReplaceSynced(interface{})
+	// 删除已从数据库删除的obj(不标记变更)
+	DeleteSynced(interface{})
+	// 以数据库为准更新obj(对应主键有未写入的变更时不更新)，返回是否更新
+	ReplaceIfClean(interface{}) bool
+	// 以数据库为准删除obj(对应主键有未写入的变更时不删除)，返回是否删除
+	DeleteIfClean(interface{}) bool
 	// 获取下个Uid
 	GetNextUid() uint32
 }
+
+// 支持按第二主键区间查询的载体
+type RangeCargoInt interface {
+	// 获取第二主键在[fromKey, toKey]区间内的obj(按第二主键排序)
+	GetRangeObjs(fromKey uint32, toKey uint32) []interface{}
+}
+
+// 定长列表载体
+type ListCargoInt interface {
+	// 追加obj(自动设置序号)，返回超出上限被淘汰的旧obj
+	Append(obj interface{}) []interface{}
+	// 最新的n个obj(按序号从旧到新排列)
+	Latest(n int) []interface{}
+}
+
+// 全局载体(按obj自身任意类型的主键存储)
+type GlobalCargoInt interface {
+	// 设置主键结构
+	SetKeyType(keyType reflect.Type)
+}
@@ -1,9 +1,13 @@
 package cache
 
+import "time"
+
 // 单个玩家数据集的单元
 type Cell struct {
 	status      CellStatus // 数据状态
 	releaseTime int64      // 释放时间戳(玩家下线时设置，到期后updater会把数据从内存中移除)
+	accessTime  int64      // 最近访问时间戳(EVICT_IDLE策略使用)
+	changeTime  int64      // 最早一次未写入数据库的变更时间戳
 	cargo       CargoInt   // 数据载体接口
 }
 
@@ -19,3 +23,11 @@ const (
 func (c *Cell) isChange() bool {
 	return c.status != STATUS_NORMAL
 }
+
+// 标记为变更状态，记录最早的变更时间
+func (c *Cell) markChange() {
+	if c.changeTime == 0 {
+		c.changeTime = time.Now().Unix()
+	}
+	c.status = STATUS_CHANGE
+}
@@ -1,25 +1,51 @@
 package cache
 
 import (
+	"context"
+	"fmt"
 	"github.com/fengzhu0601/gotools/cache/cargo"
 	"github.com/fengzhu0601/gotools/logger"
+	"gorm.io/gorm"
 	"reflect"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-type cellMap map[uint32]*Cell
+type cellMap map[uint64]*Cell
 
 type Container struct {
-	cache     *Cache       // 所属的cache主体
-	objType   reflect.Type // 数据类型
-	cargoType reflect.Type // 载体类型
-	preload   bool         // 是否预加载
-	cells     sync.Map     // 载体集合
-	cellLock  sync.Mutex   // cell锁
-	selector  *selector    // db select 协程
-	updater   *updater     // db update 协程
+	cache       *Cache                 // 所属的cache主体
+	objType     reflect.Type           // 数据类型
+	cargoType   reflect.Type           // 载体类型
+	keyNum      int                    // 主键数量
+	preload     bool                   // 是否预加载
+	tableName   string                 // 表名(分表时为分表名前缀)
+	shard       *ShardConfig           // 分表配置(nil表示不分表)
+	db          *gorm.DB               // 数据库连接
+	opts        *containerOptions      // 容器配置
+	cells       sync.Map               // 载体集合
+	cellLock    sync.Mutex             // cell锁
+	flushTimes  sync.Map               // sid最近刷新到主库的时间(从库延迟保护)
+	selector    *selector              // db select 协程
+	updater     *updater               // db update 协程
+	backlog     backlog                // 积压统计
+	deadLetters deadLetters            // 被隔离的问题数据
+	subscribers subscribers            // 写入事件的订阅者
+	snaps       snapshots              // obj最近一次的值
+	sidLocks    [sidLockNum]sync.Mutex // 按sid分段的写入锁
+	auditor     *auditor               // 变更记录器
+	verifyStat  verifyStat             // 校验统计
+	counter     *counter               // 计数器(nil表示不是计数器容器)
+	sequence    *sequence              // 持久化的id分配器(nil表示使用GetNextUid扫描)
+	keyType     reflect.Type           // 全局容器的主键结构
+	keyTypes    []reflect.Type         // 各主键字段的类型
+
+	indexes    map[string]*index     // 二级索引
+	rankings   map[string]*Ranking   // 排行榜
+	aggregates map[string]*Aggregate // 聚合
+	observers  []observer            // 内存数据变化的观察者
 
 	dbLoadNum   uint64 // db加载的Obj总数
 	dbUpdateNum uint64 // db更新的Obj总数
@@ -31,38 +57,114 @@ type Container struct {
 }
 
 // 新建容器
-func NewContainer(cache *Cache, objType reflect.Type, preload bool) *Container {
+func NewContainer(cache *Cache, objType reflect.Type, opts ...ContainerOption) *Container {
+	options := defaultOptions(cache)
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.db == nil {
+		options.db = cache.dbCon
+	}
+	if options.tableName == "" {
+		options.tableName = parseTableName(options.db, objType)
+	}
+	var cargoType reflect.Type
+	if options.global {
+		// 全局容器支持任意数量的主键
+		checkGlobal(objType, options)
+		cargoType = reflect.TypeOf((*cargo.CargoGlobal)(nil)).Elem()
+	} else {
+		cargoType = getCargoType(objType)
+	}
+	if options.ordered {
+		if primaryKeyNum(objType) != 2 {
+			panic(fmt.Sprintf("cache ordered cargo needs two primary keys, objType:%s", objType))
+		}
+		cargoType = reflect.TypeOf((*cargo.CargoOrdered)(nil)).Elem()
+	}
+	if len(options.counterFields) > 0 && options.writeThrough {
+		panic(fmt.Sprintf("cache counter does not support write through, objType:%s", objType))
+	}
+	if options.sequenceBlock > 0 && primaryKeyNum(objType) < 2 {
+		panic(fmt.Sprintf("cache sequence needs second primary key, objType:%s", objType))
+	}
+	if options.list {
+		if primaryKeyNum(objType) != 2 || options.writeThrough {
+			panic(fmt.Sprintf("cache list cargo needs two primary keys and no write through, objType:%s", objType))
+		}
+		cargoType = reflect.TypeOf((*cargo.CargoList)(nil)).Elem()
+	}
 	container := &Container{
 		cache:     cache,
 		objType:   objType,
-		cargoType: getCargoType(objType),
-		preload:   preload,
+		cargoType: cargoType,
+		keyNum:    primaryKeyNum(objType),
+		preload:   options.preload,
+		tableName: options.tableName,
+		shard:     options.shard,
+		db:        options.db,
+		opts:      options,
 		// cells:     make(cellMap),
 	}
+	container.keyTypes = parseKeyTypes(objType, container.keyNum, options)
+	if options.global {
+		container.keyType = globalKeyType(objType, container.keyNum)
+	}
 	selector := newSelector(container)
 	updater := newUpdater(container)
 	container.selector = selector
 	container.updater = updater
 	obj := reflect.New(objType).Interface()
-	err := cache.dbCon.Migrator().AutoMigrate(obj)
-	if err != nil {
-		panic(err)
+	for _, table := range container.tables() {
+		err := container.db.Table(table).Migrator().AutoMigrate(obj)
+		if err != nil {
+			panic(err)
+		}
+	}
+	container.indexes = make(map[string]*index)
+	for _, def := range options.indexes {
+		idx := newIndex(objType, def)
+		container.indexes[def.name] = idx
+		container.observers = append(container.observers, idx)
+	}
+	container.rankings = make(map[string]*Ranking)
+	for _, cfg := range options.rankings {
+		ranking := newRanking(cfg)
+		container.rankings[cfg.Name] = ranking
+		container.observers = append(container.observers, ranking)
+	}
+	if len(options.counterFields) > 0 {
+		container.counter = newCounter(container.db, objType, container.keyNum, options.counterFields)
+	}
+	if options.sequenceBlock > 0 {
+		container.sequence = newSequence(container, options.sequenceBlock)
+	}
+	container.aggregates = make(map[string]*Aggregate)
+	for _, cfg := range options.aggregates {
+		aggregate := newAggregate(objType, cfg)
+		container.aggregates[cfg.Name] = aggregate
+		container.observers = append(container.observers, aggregate)
+	}
+	if options.audit != nil {
+		container.auditor = newAuditor(container, *options.audit)
 	}
 	container.doPreload()
 	selector.startRun()
+	if options.highWater != nil {
+		cache.goRun(container.runBacklog)
+	}
+	if options.verify != nil && options.verify.Interval > 0 {
+		cache.goRun(container.runVerify)
+	}
+	if options.pollInterval > 0 {
+		cache.goRun(container.runPolling)
+	}
 	return container
 }
 
 // 通过obj的primaryKey数量获取对应的cargo类型
 func getCargoType(objType reflect.Type) reflect.Type {
-	keyNum := 0
-	for j := 0; j < objType.NumField(); j++ {
-		field := objType.Field(j)
-		tag := field.Tag.Get("gorm")
-		if tag == "primaryKey" {
-			keyNum += 1
-		}
-	}
+	keyNum := primaryKeyNum(objType)
 	switch keyNum {
 	case 1:
 		// 单主键
@@ -79,12 +181,45 @@ func getCargoType(objType reflect.Type) reflect.Type {
 	}
 }
 
+// obj的primaryKey数量
+func primaryKeyNum(objType reflect.Type) int {
+	keyNum := 0
+	for j := 0; j < objType.NumField(); j++ {
+		field := objType.Field(j)
+		tag := field.Tag.Get("gorm")
+		if tag == "primaryKey" {
+			keyNum += 1
+		}
+	}
+	return keyNum
+}
+
+// obj所属玩家的sid(第一个字段，全局容器为globalSid)
+func (c *Container) sidOf(obj interface{}) (uint64, bool) {
+	if c.opts.global {
+		return globalSid, true
+	}
+	return sidValue(reflect.ValueOf(obj).Elem().Field(0))
+}
+
+// 新建并初始化数据载体
+func (c *Container) newCargo() CargoInt {
+	newCargo := reflect.New(c.cargoType).Interface().(CargoInt)
+	newCargo.CargoInit()
+	if list, ok := newCargo.(*cargo.CargoList); ok {
+		list.SetCap(c.opts.listCap)
+	}
+	if global, ok := newCargo.(GlobalCargoInt); ok {
+		global.SetKeyType(c.keyType)
+	}
+	return newCargo
+}
+
 // 不经过数据库，直接初始化容器(新玩家登陆时用，数据库一般没有新玩家的数据，调用这个方法，可以免去容器查数据库的过程)
-func (c *Container) preInitCargo(sid uint32) {
+func (c *Container) preInitCargo(sid uint64) {
 	_, exit := c.cellLoad(sid)
 	if !exit && !c.preload {
-		newCargo := reflect.New(c.cargoType).Interface().(CargoInt)
-		newCargo.CargoInit()
+		newCargo := c.newCargo()
 		c.cellStore(sid, &Cell{cargo: newCargo})
 	}
 }
@@ -92,14 +227,13 @@ func (c *Container) preInitCargo(sid uint32) {
 // 从容器中获取某个玩家的所有数据集合
 //
 // willChange表示获取数据后，是否将要改变数据。true的时候，设置一下cell的status为变更状态
-func (c *Container) getCargo(sid uint32, willChange bool) CargoInt {
+func (c *Container) getCargo(sid uint64, willChange bool) CargoInt {
 	cell, exit := c.cellLoad(sid)
 	if !exit {
 		if c.preload {
 			// 预加载的表格，不需要搜索数据库。直接初始化载体即可
 			// 预加载数据或者已加载数据，在数据库断开连接的情况下，也能先在缓存上增删改查。数据库连接上后，再同步数据到数据库即可
-			newCargo := reflect.New(c.cargoType).Interface().(CargoInt)
-			newCargo.CargoInit()
+			newCargo := c.newCargo()
 			// 锁上后开始添加新的数据载体
 			c.cellLock.Lock()
 			defer c.cellLock.Unlock()
@@ -108,13 +242,13 @@ func (c *Container) getCargo(sid uint32, willChange bool) CargoInt {
 			if !exit1 {
 				newCell := &Cell{cargo: newCargo}
 				if willChange {
-					newCell.status = STATUS_CHANGE
+					newCell.markChange()
 				}
 				c.cellStore(sid, newCell)
 				return newCargo
 			}
 			if willChange {
-				cell2.status = STATUS_CHANGE
+				cell2.markChange()
 			}
 			return cell2.cargo
 		} else {
@@ -127,17 +261,30 @@ func (c *Container) getCargo(sid uint32, willChange bool) CargoInt {
 				return nil
 			}
 			if willChange {
-				cell1.status = STATUS_CHANGE
+				cell1.markChange()
 			}
 			return cell1.cargo
 		}
 	}
 	if willChange {
-		cell.status = STATUS_CHANGE
+		cell.markChange()
 	}
 	return cell.cargo
 }
 
+// 获取载体并加sid的写入锁
+func (c *Container) lockCargo(sid uint64) (CargoInt, *sync.Mutex) {
+	cargo := c.getCargo(sid, false)
+	return cargo, c.lockSid(sid)
+}
+
+// 标记cell为变更状态(持有sid的写入锁时调用，同一sid并发写入时不会同时修改cell)
+func (c *Container) markChange(sid uint64) {
+	if cell, exit := c.cellLoad(sid); exit {
+		cell.markChange()
+	}
+}
+
 // 获取所有obj
 func (c *Container) getAllObjs() []interface{} {
 	objs := make([]interface{}, 0)
@@ -157,18 +304,18 @@ func (c *Container) getProfInfo() *ContainerProf {
 	c.cells.Range(
 		func(k any, v any) bool {
 			cell := v.(*Cell)
-			sid := k.(uint32)
+			sid := k.(uint64)
 			cell.cargo.CollectChangedObjs(sid, &updateObjs, &deleteKeys, true)
 			prof.CellNum++
 			if cell.isChange() {
 				prof.ChangeCellNum++
 			}
-			if c.preload == false && cell.status == STATUS_NORMAL && cell.releaseTime > 0 {
+			if c.preload == false && cell.status == STATUS_NORMAL && c.gcMarked(cell) {
 				prof.GCellNum++
 			}
 			return true
 		})
-	prof.ObjNum = uint32(len(c.getAllObjs()))
+	prof.ObjNum = uint32(c.countObjs())
 	prof.UpdateObjNum = uint32(len(updateObjs))
 	prof.DeleteObjNum = uint32(len(deleteKeys))
 	prof.DBLoadNum = c.dbLoadNum
@@ -177,79 +324,223 @@ func (c *Container) getProfInfo() *ContainerProf {
 	prof.GcCellNum = c.gcCellNum
 	prof.CellReads = c.cellReads
 	prof.CellWrites = c.cellWrites
+	prof.DeadLetterNum = c.deadLetters.num
+	prof.EventDropNum = c.eventDropNum()
+	prof.Healthy = c.Healthy()
+	prof.DirtyAge = atomic.LoadInt64(&c.backlog.dirtyAge)
+	prof.VerifyNum = atomic.LoadUint64(&c.verifyStat.checkNum)
+	prof.MismatchNum = atomic.LoadUint64(&c.verifyStat.mismatchNum)
+	prof.RepairNum = atomic.LoadUint64(&c.verifyStat.repairNum)
+	for _, aggregate := range c.aggregates {
+		prof.Aggregates = append(prof.Aggregates, aggregate.prof())
+	}
 	prof.ObjMemory = prof.ObjNum * uint32(c.objType.Size()) / 1024
 	return prof
 }
 
 // 获取某个玩家的一批objs
-func (c *Container) LookupObjs(sid uint32, keys ...uint32) []interface{} {
+func (c *Container) LookupObjs(sid uint64, keys ...interface{}) []interface{} {
 	cargo := c.getCargo(sid, false)
-	return cargo.GetSomeObjs(keys...)
+	return cargo.GetPrefixObjs(keys...)
 }
 
-// 获取某个玩家的单个obj
-func (c *Container) Lookup(sid uint32, keys ...uint32) interface{} {
+// 获取某个玩家第二主键在[fromKey, toKey]区间内的obj，按第二主键排序
+func (c *Container) LookupRange(sid uint64, fromKey interface{}, toKey interface{}) []interface{} {
 	cargo := c.getCargo(sid, false)
-	return cargo.GetSingleObj(keys...)
+	if c.keyNum < 2 {
+		return cargo.GetSomeObjs()
+	}
+	bounds := anyKeys([]interface{}{fromKey, toKey})
+	fromKey, toKey = bounds[0], bounds[1]
+	if ranged, ok := cargo.(RangeCargoInt); ok {
+		from, okFrom := uint32Key(fromKey)
+		to, okTo := uint32Key(toKey)
+		if okFrom && okTo {
+			return ranged.GetRangeObjs(from, to)
+		}
+	}
+	// 无序载体(或区间超出uint32)先过滤再排序
+	objs := make([]interface{}, 0)
+	cargo.RangeObjs(func(obj interface{}) bool {
+		key := keyOfField(obj, 1)
+		if compareKey(key, fromKey) >= 0 && compareKey(key, toKey) <= 0 {
+			objs = append(objs, obj)
+		}
+		return true
+	})
+	sort.Slice(objs, func(i, j int) bool {
+		return compareKey(keyOfField(objs[i], 1), keyOfField(objs[j], 1)) < 0
+	})
+	return objs
+}
+
+// 获取某个玩家的单个obj(需要填满除sid外的主键，整数之间可以互转)
+func (c *Container) Lookup(sid uint64, keys ...interface{}) interface{} {
+	cargo := c.getCargo(sid, false)
+	return cargo.GetObj(keys...)
+}
+
+// 更新或插入某个obj(obj实现了Validator时先校验，写穿透容器会同步写入数据库)
+func (c *Container) Replace(obj interface{}) error {
+	return c.replace(obj, "")
+}
+
+// 更新或插入某个obj，并在变更记录中写入原因
+func (c *Container) ReplaceReason(obj interface{}, reason string) error {
+	return c.replace(obj, reason)
 }
 
-// 更新或插入某个obj
-func (c *Container) Replace(obj interface{}) bool {
-	sid := uint32(reflect.ValueOf(obj).Elem().Field(0).Uint())
-	cargo := c.getCargo(sid, true)
+func (c *Container) replace(obj interface{}, reason string) error {
+	if c.opts.writeThrough {
+		err := c.replaceSync(context.Background(), obj, reason)
+		c.logSyncErr(err)
+		return err
+	}
+	err := validate(obj)
+	if err != nil {
+		return err
+	}
+	sid, err := c.checkSid(obj)
+	if err != nil {
+		return err
+	}
+	keys := c.keysOf(obj)
+	cargo, lock := c.lockCargo(sid)
+	defer lock.Unlock()
+	old := cargo.GetObj(keys...)
+	err = c.reserveUnique(sid, keys, old, obj)
+	if err != nil {
+		return err
+	}
+	c.markChange(sid)
+	c.dropDeltas(sid, keys)
 	cargo.Replace(obj)
-	return true
+	c.notifyReplace(sid, keys, old, obj, reason)
+	return nil
 }
 
-// 删除某个obj
+// 删除某个obj(写穿透容器会同步写入数据库，失败时返回false)
 func (c *Container) Delete(obj interface{}) bool {
-	sid := uint32(reflect.ValueOf(obj).Elem().Field(0).Uint())
-	cargo := c.getCargo(sid, true)
+	return c.delete(obj, "")
+}
+
+// 删除某个obj，并在变更记录中写入原因
+func (c *Container) DeleteReason(obj interface{}, reason string) bool {
+	return c.delete(obj, reason)
+}
+
+func (c *Container) delete(obj interface{}, reason string) bool {
+	if c.opts.writeThrough {
+		return c.logSyncErr(c.deleteSync(context.Background(), obj, reason))
+	}
+	sid, err := c.checkSid(obj)
+	if err != nil {
+		logger.Error("cache delete error:", err)
+		return false
+	}
+	keys := c.keysOf(obj)
+	cargo, lock := c.lockCargo(sid)
+	defer lock.Unlock()
+	c.markChange(sid)
+	old := cargo.GetObj(keys...)
+	c.dropDeltas(sid, keys)
 	cargo.DeleteObj(obj)
+	if old != nil {
+		c.notify(OP_DELETE, sid, keys, old, nil, reason)
+	}
 	return true
 }
 
-// 删除某个obj
-func (c *Container) DeleteObjs(sid uint32) bool {
-	cargo := c.getCargo(sid, true)
+// 删除某个玩家的所有obj(写穿透容器会同步写入数据库，失败时返回false)
+func (c *Container) DeleteObjs(sid uint64) bool {
+	return c.deleteObjs(sid, "")
+}
+
+// 删除某个玩家的所有obj，并在变更记录中写入原因
+func (c *Container) DeleteObjsReason(sid uint64, reason string) bool {
+	return c.deleteObjs(sid, reason)
+}
+
+func (c *Container) deleteObjs(sid uint64, reason string) bool {
+	if c.opts.writeThrough {
+		return c.logSyncErr(c.deleteObjsSync(context.Background(), sid, reason))
+	}
+	cargo, lock := c.lockCargo(sid)
+	defer lock.Unlock()
+	c.markChange(sid)
+	olds := cargo.GetSomeObjs()
 	cargo.DeleteObjs()
+	for _, old := range olds {
+		c.dropDeltas(sid, c.keysOf(old))
+		c.notify(OP_DELETE, sid, c.keysOf(old), old, nil, reason)
+	}
 	return true
 }
 
-// 双主键获取下一个Uid
-func (c *Container) GetNextUid(sid uint32) uint32 {
+// 双主键获取下一个Uid(内存中最大的第二主键+1，删除最大的obj后id会被复用)
+//
+// 需要不重复的id时开启WithSequence并使用NextId
+func (c *Container) GetNextUid(sid uint64) uint32 {
 	cargo := c.getCargo(sid, false)
 	return cargo.GetNextUid()
 }
 
 // 设置玩家数据的内存回收标志
-func (c *Container) SetGC(sid uint32) {
+func (c *Container) SetGC(sid uint64) {
 	cell, exit := c.cellLoad(sid)
 	if exit {
-		cell.releaseTime = time.Now().Unix() + c.cache.dbConfig.GCSeconds
+		cell.releaseTime = time.Now().Unix() + c.opts.gcSeconds
 	}
 }
 
 // 去除玩家数据的内存回收标志
-func (c *Container) UnSetGC(sid uint32) {
+func (c *Container) UnSetGC(sid uint64) {
 	cell, exit := c.cellLoad(sid)
 	if exit {
 		cell.releaseTime = 0
 	}
 }
 
-func (c *Container) cellLoad(sid uint32) (*Cell, bool) {
+func (c *Container) cellLoad(sid uint64) (*Cell, bool) {
 	if c.cache.dbConfig.RWAnalyse {
 		atomic.AddInt64(&c.cellReads, 1)
 	}
 	cell, exit := c.cells.Load(sid)
 	if exit {
+		if c.opts.eviction == EVICT_IDLE {
+			atomic.StoreInt64(&cell.(*Cell).accessTime, time.Now().Unix())
+		}
 		return cell.(*Cell), exit
 	}
 	return nil, exit
 }
 
-func (c *Container) cellStore(sid uint32, cell *Cell) {
+// cell是否处于待回收状态
+func (c *Container) gcMarked(cell *Cell) bool {
+	switch c.opts.eviction {
+	case EVICT_NEVER:
+		return false
+	case EVICT_IDLE:
+		return atomic.LoadInt64(&cell.accessTime) > 0
+	default:
+		return cell.releaseTime > 0
+	}
+}
+
+// cell是否到了回收时间
+func (c *Container) canEvict(cell *Cell, now int64) bool {
+	switch c.opts.eviction {
+	case EVICT_NEVER:
+		return false
+	case EVICT_IDLE:
+		accessTime := atomic.LoadInt64(&cell.accessTime)
+		return accessTime > 0 && accessTime+c.opts.gcSeconds < now
+	default:
+		return cell.releaseTime > 0 && cell.releaseTime < now
+	}
+}
+
+func (c *Container) cellStore(sid uint64, cell *Cell) {
 	if c.cache.dbConfig.RWAnalyse {
 		atomic.AddInt64(&c.cellWrites, 1)
 	}
@@ -264,7 +555,7 @@ func (c *Container) scanChangeObjs(num uint32) ([]interface{}, []interface{}) {
 	var scanNum uint32 = 0
 	c.cells.Range(func(k any, v any) bool {
 		cell := v.(*Cell)
-		sid := k.(uint32)
+		sid := k.(uint64)
 		if scanNum >= num {
 			return false
 		}
@@ -290,31 +581,42 @@ func (c *Container) afterSyncDb(success bool) {
 			cell.cargo.AfterSyncDB(success)
 			if success {
 				cell.status = STATUS_NORMAL
+				cell.changeTime = 0
+				c.markFlushed(k.(uint64), now)
 			} else {
 				cell.status = STATUS_CHANGE
 			}
 		}
-		if c.preload == false && cell.status == STATUS_NORMAL && cell.releaseTime > 0 && cell.releaseTime < now {
+		if c.preload == false && cell.status == STATUS_NORMAL && c.canEvict(cell, now) && !c.hasDeltas(k.(uint64)) && !c.hasHeld(k.(uint64), cell.cargo) && !c.hasAuditPending(k.(uint64)) {
 			// 非预加载的数据，到期后从内存释放
 			c.gcCellNum++
 			if c.cache.dbConfig.RWAnalyse {
 				atomic.AddInt64(&c.cellWrites, 1)
 			}
+			if len(c.observers) > 0 {
+				objs := make([]interface{}, 0)
+				cell.cargo.CollectAllObjs(&objs)
+				c.observeObjs(objs, true)
+			}
 			c.cells.Delete(k)
+			c.dropSnaps(k.(uint64))
+			if c.sequence != nil {
+				c.sequence.drop(k.(uint64))
+			}
 		}
 		return true
 	})
+	c.pruneFlushed(now)
 }
 
 // 批量加载数据库数据到cells中
-func (c *Container) loadDBData(sidList []uint32, datas reflect.Value) {
+func (c *Container) loadDBData(sidList []uint64, datas reflect.Value) {
 
 	for _, sid := range sidList {
 		_, exit := c.cellLoad(sid)
 		if !exit {
 			// 无论数据库中有没有数据，只要搜索都需要初始化载体。防止缓存穿透
-			newCargo := reflect.New(c.cargoType).Interface().(CargoInt)
-			newCargo.CargoInit()
+			newCargo := c.newCargo()
 			c.cellStore(sid, &Cell{cargo: newCargo})
 		}
 	}
@@ -322,12 +624,19 @@ func (c *Container) loadDBData(sidList []uint32, datas reflect.Value) {
 	len := datas.Len()
 	for i := 0; i < len; i++ {
 		element := datas.Index(i)
-		sid := uint32(element.Elem().Field(0).Uint())
+		afterCacheLoad(element.Interface())
+		sid, ok := c.sidOf(element.Interface())
+		if !ok {
+			logger.Error("cache load invalid sid", c.objType, element.Interface())
+			continue
+		}
 		cell, exit := c.cellLoad(sid)
 		if !exit {
 			logger.Error("cell not exit", sid)
 		} else {
 			cell.cargo.LoadDBData(element)
+			c.seedSnap(sid, element.Interface(), true)
+			c.observeObjs([]interface{}{element.Interface()}, false)
 		}
 	}
 	c.dbLoadNum += uint64(len)
@@ -339,32 +648,70 @@ func (c *Container) doPreload() {
 		return
 	}
 
-	loadStartTime := time.Now()
-	sliceT := reflect.SliceOf(reflect.PtrTo(c.objType))
-	slice := reflect.New(sliceT)
-	sliceInt := slice.Interface()
-	c.cache.dbCon.Model(sliceInt).Find(sliceInt)
-	datas := slice.Elem()
-	len := datas.Len()
-	loadTime := time.Since(loadStartTime)
+	var loadTime, insertTime time.Duration
+	len := 0
+	for _, table := range c.tables() {
+		loadStartTime := time.Now()
+		datas, err := c.find(c.readDBs(), table, nil)
+		if err != nil {
+			// 预加载的数据不完整时不能启动，缺失的数据会被当成不存在而覆盖
+			logger.Error("cache doPreload error", c.objType, table, err)
+			panic(err)
+		}
+		loadTime += time.Since(loadStartTime)
+
+		insertStartTime := time.Now()
+		c.preloadDatas(datas)
+		insertTime += time.Since(insertStartTime)
+		len += datas.Len()
+	}
+
+	logger.Info("cache doPreload insert cells:", c.objType, "size:", len, "loadTIme:", loadTime, "insertTime:", insertTime)
+
+}
 
-	insertStartTime := time.Now()
+// 依次尝试各个连接查询表数据(sidList为nil时查询全表)，出错时换下一个连接
+func (c *Container) find(dbs []*gorm.DB, table string, sidList []uint64) (reflect.Value, error) {
+	var err error
+	for _, db := range dbs {
+		sliceT := reflect.SliceOf(reflect.PtrTo(c.objType))
+		slice := reflect.New(sliceT)
+		sliceInt := slice.Interface()
+		tx := db.Table(table)
+		if sidList != nil && !c.opts.global {
+			// 全局容器没有sid列，总是查询全表
+			tx = tx.Where("sid in (?)", sidList)
+		}
+		err = tx.Find(sliceInt).Error
+		if err == nil {
+			return slice.Elem(), nil
+		}
+		logger.Error("cache find error", c.objType, table, err)
+	}
+	return reflect.Value{}, err
+}
+
+// 预加载的数据插入cells中
+func (c *Container) preloadDatas(datas reflect.Value) {
+	len := datas.Len()
 	for i := 0; i < len; i++ {
 		element := datas.Index(i)
-		sid := uint32(element.Elem().Field(0).Uint())
+		afterCacheLoad(element.Interface())
+		sid, ok := c.sidOf(element.Interface())
+		if !ok {
+			logger.Error("cache load invalid sid", c.objType, element.Interface())
+			continue
+		}
 		cell, exit := c.cellLoad(sid)
 		if !exit {
-			newCargo := reflect.New(c.cargoType).Interface().(CargoInt)
-			newCargo.CargoInit()
+			newCargo := c.newCargo()
 			newCell := &Cell{cargo: newCargo}
 			newCell.cargo.LoadDBData(element)
 			c.cellStore(sid, newCell)
 		} else {
 			cell.cargo.LoadDBData(element)
 		}
+		c.seedSnap(sid, element.Interface(), true)
+		c.observeObjs([]interface{}{element.Interface()}, false)
 	}
-	insertTime := time.Since(insertStartTime)
-
-	logger.Info("cache doPreload insert cells:", c.objType, "size:", len, "loadTIme:", loadTime, "insertTime:", insertTime)
-
 }
@@ -0,0 +1,335 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fengzhu0601/gotools/cache/bulk"
+	"github.com/fengzhu0601/gotools/logger"
+	"gorm.io/gorm"
+)
+
+// 计数器：Incr的变化按列累计增量，由updater用 col = col + ? 批量写入，不会覆盖数据库中的外部修改
+//
+// 缓存中的obj是数据库的值加上未写入的增量；Replace/Delete会丢弃该obj未写入的增量(整行写入或删除)
+//
+// Incr马上更新索引、排行榜等观察者，变更记录和订阅事件按obj合并，每轮写库时发出一次
+type counter struct {
+	fields      []reflect.StructField      // 计数字段
+	index       map[string]int             // 字段名 -> 计数字段序号
+	keyColumns  []string                   // 主键列名
+	incrColumns []string                   // 计数列名
+	lock        sync.Mutex                 // 增量锁
+	pending     map[string]*counterPending // 主键 -> 未写入的增量
+	sids        map[uint64]int             // sid -> 有未写入增量的obj数量
+	events      map[string]*counterEvent   // 主键 -> 还未发出的增量事件
+}
+
+// 合并后还未发出的增量事件
+type counterEvent struct {
+	sid  uint64
+	keys []interface{}
+	op   Op // 合并期间新建的obj为插入，否则为更新
+}
+
+// 一个obj未写入的增量
+type counterPending struct {
+	sid    uint64
+	keys   []interface{}
+	deltas []int64 // 各计数字段的增量
+}
+
+// 计数器容器：fields为按增量写入的数值字段(不支持写穿透)
+func WithCounter(fields ...string) ContainerOption {
+	return func(o *containerOptions) {
+		o.counterFields = fields
+	}
+}
+
+func newCounter(db *gorm.DB, objType reflect.Type, keyNum int, names []string) *counter {
+	stmt := &gorm.Statement{DB: db}
+	err := stmt.Parse(reflect.New(objType).Interface())
+	if err != nil {
+		panic(err)
+	}
+	ct := &counter{
+		index:   make(map[string]int),
+		pending: make(map[string]*counterPending),
+		sids:    make(map[uint64]int),
+		events:  make(map[string]*counterEvent),
+	}
+	for i := 0; i < keyNum; i++ {
+		ct.keyColumns = append(ct.keyColumns, stmt.Schema.LookUpField(objType.Field(i).Name).DBName)
+	}
+	for i, name := range names {
+		field, exit := objType.FieldByName(name)
+		if !exit {
+			panic(fmt.Sprintf("cache counter field not found, objType:%s field:%s", objType, name))
+		}
+		switch field.Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			panic(fmt.Sprintf("cache counter field must be integer, objType:%s field:%s", objType, name))
+		}
+		ct.fields = append(ct.fields, field)
+		ct.index[name] = i
+		ct.incrColumns = append(ct.incrColumns, stmt.Schema.LookUpField(name).DBName)
+	}
+	return ct
+}
+
+// 计数字段增加delta(可为负数)，obj不存在时新建，返回增加后的值；增加后超出字段范围或Validate失败时返回错误
+//
+// keys为除sid外的主键(整数，类型不需要和字段一致)
+func (c *Container) Incr(sid uint64, field string, delta int64, keys ...interface{}) (int64, error) {
+	ct := c.counter
+	if ct == nil {
+		return 0, fmt.Errorf("cache container is not a counter, objType:%s", c.objType)
+	}
+	i, exit := ct.index[field]
+	if !exit {
+		return 0, fmt.Errorf("cache counter field not found, objType:%s field:%s", c.objType, field)
+	}
+	if len(keys) != c.keyNum-1 {
+		return 0, fmt.Errorf("cache counter keys error, objType:%s keys:%v", c.objType, keys)
+	}
+	keyValues := anyKeys(keys)
+	for _, key := range keyValues {
+		if _, ok := key.(string); ok {
+			return 0, fmt.Errorf("cache counter keys error, objType:%s keys:%v", c.objType, keys)
+		}
+	}
+	// 标记变更，有未写入增量的cell不会被回收
+	cargo, lock := c.lockCargo(sid)
+	defer lock.Unlock()
+	c.markChange(sid)
+
+	ct.lock.Lock()
+	obj := cargo.GetObj(keyValues...)
+	op := OP_UPDATE
+	if obj == nil {
+		v := reflect.New(c.objType)
+		setKey(v.Elem().Field(0), sid)
+		for k, key := range keyValues {
+			setKey(v.Elem().Field(k+1), key)
+		}
+		obj = v.Interface()
+		op = OP_INSERT
+		// 超出主键字段范围(如负数)时设置后的值不同
+		if joinKeys(c.keysOf(obj)) != joinKeys(keyValues) {
+			ct.lock.Unlock()
+			return 0, fmt.Errorf("cache counter keys out of range, objType:%s keys:%v", c.objType, keys)
+		}
+	}
+	result, err := c.applyDelta(obj, ct.fields[i], delta)
+	if err != nil {
+		ct.lock.Unlock()
+		return 0, err
+	}
+	if op == OP_INSERT {
+		cargo.ReplaceSynced(obj)
+	}
+	key := indexKey(sid, keyValues)
+	pending, exit := ct.pending[key]
+	if !exit {
+		pending = &counterPending{sid: sid, keys: keyValues, deltas: make([]int64, len(ct.fields))}
+		ct.pending[key] = pending
+		ct.sids[sid]++
+	}
+	pending.deltas[i] += delta
+	if c.snapEnabled() {
+		if _, exit := ct.events[key]; !exit {
+			ct.events[key] = &counterEvent{sid: sid, keys: keyValues, op: op}
+		}
+	}
+	ct.lock.Unlock()
+
+	if op == OP_INSERT {
+		c.observe(sid, keyValues, nil, obj)
+	} else {
+		c.observe(sid, keyValues, obj, obj)
+	}
+	return result, nil
+}
+
+// 发出合并后的增量事件(每轮写库前调用)，Old为上次发出事件时的值
+func (c *Container) emitCounter() {
+	ct := c.counter
+	if ct == nil {
+		return
+	}
+	ct.lock.Lock()
+	events := make(map[string]*counterEvent, len(ct.events))
+	for key, ev := range ct.events {
+		events[key] = ev
+	}
+	ct.lock.Unlock()
+	for key, ev := range events {
+		lock := c.lockSid(ev.sid)
+		c.emitCounterEvent(key)
+		lock.Unlock()
+	}
+}
+
+// 发出某个obj还未发出的增量事件(调用时持有sid的写入锁)
+func (c *Container) emitCounterEvent(key string) {
+	ct := c.counter
+	ct.lock.Lock()
+	ev, exit := ct.events[key]
+	delete(ct.events, key)
+	ct.lock.Unlock()
+	if !exit {
+		return
+	}
+	cell, exit := c.cellLoad(ev.sid)
+	if !exit {
+		return
+	}
+	obj := cell.cargo.GetObj(ev.keys...)
+	if obj == nil {
+		return
+	}
+	if ev.op == OP_INSERT {
+		c.emit(ev.op, ev.sid, ev.keys, nil, obj, "incr")
+	} else {
+		c.emit(ev.op, ev.sid, ev.keys, obj, obj, "incr")
+	}
+}
+
+// 计算增加后的值并写入obj：超出字段范围(无符号字段减到负数)或校验失败时返回错误，不修改obj
+func (c *Container) applyDelta(obj interface{}, field reflect.StructField, delta int64) (int64, error) {
+	v := reflect.ValueOf(obj).Elem()
+	value := v.FieldByIndex(field.Index)
+	var result int64
+	var overflow bool
+	if value.CanInt() {
+		result = value.Int() + delta
+		overflow = value.OverflowInt(result)
+	} else {
+		result = int64(value.Uint()) + delta
+		overflow = result < 0 || value.OverflowUint(uint64(result))
+	}
+	if overflow {
+		return 0, fmt.Errorf("cache counter value out of range, objType:%s field:%s delta:%d result:%d", c.objType, field.Name, delta, result)
+	}
+	// 在副本上校验增加后的obj
+	check := reflect.New(v.Type())
+	check.Elem().Set(v)
+	setCounter(check.Elem().FieldByIndex(field.Index), result)
+	err := validate(check.Interface())
+	if err != nil {
+		return 0, err
+	}
+	setCounter(value, result)
+	return result, nil
+}
+
+func setCounter(value reflect.Value, result int64) {
+	if value.CanInt() {
+		value.SetInt(result)
+	} else {
+		value.SetUint(uint64(result))
+	}
+}
+
+// 丢弃obj未写入的增量(Replace/Delete整行写入或删除时调用，持有sid的写入锁)，先发出该obj还未发出的增量事件
+func (c *Container) dropDeltas(sid uint64, keys []interface{}) {
+	if c.counter == nil {
+		return
+	}
+	c.emitCounterEvent(indexKey(sid, keys))
+	c.counter.lock.Lock()
+	defer c.counter.lock.Unlock()
+	key := indexKey(sid, keys)
+	if _, exit := c.counter.pending[key]; exit {
+		delete(c.counter.pending, key)
+		c.counter.sids[sid]--
+		if c.counter.sids[sid] <= 0 {
+			delete(c.counter.sids, sid)
+		}
+	}
+}
+
+// 写入累计的增量，失败时增量放回等待下次写入(updater在批量更新前调用，之后Replace写入的整行以缓存为准)
+func (u *updater) flushCounter() {
+	c := u.container
+	ct := c.counter
+	if ct == nil {
+		return
+	}
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	ct.lock.Lock()
+	pending := ct.pending
+	ct.pending = make(map[string]*counterPending)
+	ct.sids = make(map[uint64]int)
+	ct.lock.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	groups := make(map[int][][]interface{})
+	for _, p := range pending {
+		row := make([]interface{}, 0, len(ct.keyColumns)+len(ct.incrColumns))
+		row = append(row, p.sid)
+		for _, key := range p.keys {
+			row = append(row, key)
+		}
+		for _, delta := range p.deltas {
+			row = append(row, delta)
+		}
+		index := c.shardIndex(p.sid)
+		groups[index] = append(groups[index], row)
+	}
+	done := make(map[int]bool)
+	var err error
+	for index, rows := range groups {
+		err = bulk.BulkIncrWithTableName(c.db, c.shardTable(index), ct.keyColumns, ct.incrColumns, rows)
+		if err != nil {
+			break
+		}
+		done[index] = true
+		c.dbUpdateNum += uint64(len(rows))
+	}
+	now := time.Now().Unix()
+	for _, p := range pending {
+		if done[c.shardIndex(p.sid)] {
+			c.markFlushed(p.sid, now)
+		}
+	}
+	if err == nil {
+		return
+	}
+
+	// 未写入的分表的增量放回，和之后新的增量合并
+	logger.Error("cache counter flush error:", c.objType, len(pending), err)
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	for key, p := range pending {
+		if done[c.shardIndex(p.sid)] {
+			continue
+		}
+		now, exit := ct.pending[key]
+		if !exit {
+			ct.pending[key] = p
+			ct.sids[p.sid]++
+			continue
+		}
+		for i, delta := range p.deltas {
+			now.deltas[i] += delta
+		}
+	}
+}
+
+// sid是否有未写入的增量(有增量的cell不回收)
+func (c *Container) hasDeltas(sid uint64) bool {
+	if c.counter == nil {
+		return false
+	}
+	c.counter.lock.Lock()
+	defer c.counter.lock.Unlock()
+	return c.counter.sids[sid] > 0
+}
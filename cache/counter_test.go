@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/fengzhu0601/gotools/cache/internal/fakedb"
+)
+
+const testIncrSQL = "INSERT INTO `test_item` (`sid`, `id`, `num`) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE `num` = `num` + VALUES(`num`)"
+
+// 写库的增量(按语句)
+func incrArgs(db *fakedb.DB) [][]interface{} {
+	list := make([][]interface{}, 0)
+	for _, stmt := range db.ExecsOf("ON DUPLICATE KEY UPDATE") {
+		if stmt.Query != testIncrSQL {
+			panic("incr sql: " + stmt.Query)
+		}
+		list = append(list, stmt.Args)
+	}
+	return list
+}
+
+func TestIncrFlush(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(c *Container, db *fakedb.DB)
+		want  [][]interface{}
+		num   int // 缓存中的值
+	}{
+		{"merge deltas", func(c *Container, db *fakedb.DB) {
+			c.Incr(1, "Num", 3, 1)
+			c.Incr(1, "Num", 4, uint32(1))
+		}, [][]interface{}{{uint64(1), uint64(1), int64(7)}}, 7},
+		{"negative delta", func(c *Container, db *fakedb.DB) {
+			c.Incr(1, "Num", 3, 1)
+			c.Incr(1, "Num", -5, 1)
+		}, [][]interface{}{{uint64(1), uint64(1), int64(-2)}}, -2},
+		{"replace drops deltas", func(c *Container, db *fakedb.DB) {
+			c.Incr(1, "Num", 3, 1)
+			c.Replace(&testItem{Sid: 1, Id: 1, Num: 9})
+		}, [][]interface{}{}, 9},
+		{"incr after replace", func(c *Container, db *fakedb.DB) {
+			c.Incr(1, "Num", 3, 1)
+			c.Replace(&testItem{Sid: 1, Id: 1, Num: 9})
+			c.Incr(1, "Num", 1, 1)
+		}, [][]interface{}{{uint64(1), uint64(1), int64(1)}}, 10},
+		{"failed deltas merged", func(c *Container, db *fakedb.DB) {
+			db.ExecFn = func(query string, args []interface{}) (int64, error) {
+				return 0, errors.New("db error")
+			}
+			c.Incr(1, "Num", 3, 1)
+			c.updater.flushCounter()
+			db.ExecFn = nil
+			db.Reset()
+			c.Incr(1, "Num", 4, 1)
+		}, [][]interface{}{{uint64(1), uint64(1), int64(7)}}, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakedb.New()
+			c := newTestContainer(t, db, testItemType, WithPreload(true), WithCounter("Num"))
+			tt.write(c, db)
+			c.updater.flushCounter()
+			if got := incrArgs(db); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("incr args = %v, want %v", got, tt.want)
+			}
+			if obj := c.Lookup(1, 1).(*testItem); obj.Num != tt.num {
+				t.Fatalf("Num = %d, want %d", obj.Num, tt.num)
+			}
+			c.updater.flushCounter()
+			if got := incrArgs(db); len(got) != len(tt.want) {
+				t.Fatalf("deltas flushed twice: %v", got)
+			}
+		})
+	}
+}
+
+func TestIncrKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []interface{}
+		err  bool
+	}{
+		{"int", []interface{}{1}, false},
+		{"uint8", []interface{}{uint8(1)}, false},
+		{"uint64", []interface{}{uint64(1)}, false},
+		{"string", []interface{}{"1"}, true},
+		{"negative", []interface{}{-1}, true},
+		{"out of range", []interface{}{uint64(1) << 40}, true},
+		{"missing", []interface{}{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContainer(t, fakedb.New(), testItemType, WithPreload(true), WithCounter("Num"))
+			_, err := c.Incr(1, "Num", 1, tt.keys...)
+			if (err != nil) != tt.err {
+				t.Fatalf("Incr() error = %v, want error %v", err, tt.err)
+			}
+			if got := len(c.LookupObjs(1)); got != 0 && tt.err {
+				t.Fatalf("obj created with wrong keys: %v", c.LookupObjs(1))
+			}
+		})
+	}
+}
+
+// 增量事件合并后每轮写库发出一次，Old为上次发出的值，整行写入前先发出未发出的增量事件
+func TestIncrEvents(t *testing.T) {
+	c := newTestContainer(t, fakedb.New(), testItemType, WithPreload(true), WithCounter("Num"))
+	ch, cancel := c.SubscribeChan(10, DELIVER_BLOCK)
+	defer cancel()
+	type event struct {
+		op     Op
+		old    int // -1表示nil
+		num    int
+		reason string
+	}
+	recv := func() []event {
+		list := make([]event, 0)
+		for len(ch) > 0 {
+			ev := <-ch
+			e := event{op: ev.Op, old: -1, num: ev.New.(*testItem).Num, reason: ev.Reason}
+			if ev.Old != nil {
+				e.old = ev.Old.(*testItem).Num
+			}
+			list = append(list, e)
+		}
+		return list
+	}
+	steps := []struct {
+		name  string
+		write func()
+		want  []event
+	}{
+		{"not emitted before flush", func() {
+			c.Incr(1, "Num", 1, 1)
+			c.Incr(1, "Num", 2, 1)
+		}, []event{}},
+		{"insert", func() {
+			c.updater.flushCounter()
+			c.emitCounter()
+		}, []event{{OP_INSERT, -1, 3, "incr"}}},
+		{"nothing pending", func() {
+			c.emitCounter()
+		}, []event{}},
+		{"update", func() {
+			c.Incr(1, "Num", 2, 1)
+			c.Incr(1, "Num", 4, 1)
+			c.emitCounter()
+		}, []event{{OP_UPDATE, 3, 9, "incr"}}},
+		{"replace emits incr first", func() {
+			c.Incr(1, "Num", 1, 1)
+			c.ReplaceReason(&testItem{Sid: 1, Id: 1, Num: 20}, "gm")
+			c.emitCounter()
+		}, []event{{OP_UPDATE, 9, 10, "incr"}, {OP_UPDATE, 10, 20, "gm"}}},
+	}
+	for _, step := range steps {
+		step.write()
+		if got := recv(); !reflect.DeepEqual(got, step.want) {
+			t.Fatalf("%s: events = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fengzhu0601/gotools/logger"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultDBEncode = "utf8mb4"
+	defaultDBLoc    = "Local"
+)
+
+// 根据配置生成mysql驱动的DSN配置
+func buildDSNConfig(dbCfg *DBConfig) (*mysqldriver.Config, error) {
+	dsnCfg := mysqldriver.NewConfig()
+	dsnCfg.User = dbCfg.DBUser
+	dsnCfg.Passwd = dbCfg.DBPass
+	dsnCfg.Net = "tcp"
+	dsnCfg.Addr = fmt.Sprintf("%s:%d", dbCfg.DBHost, dbCfg.DBPort)
+	dsnCfg.DBName = dbCfg.DBName
+	dsnCfg.ParseTime = true
+
+	encode := dbCfg.DBEncode
+	if encode == "" {
+		encode = defaultDBEncode
+	}
+	dsnCfg.Params = map[string]string{"charset": encode}
+	for k, v := range dbCfg.DBParams {
+		dsnCfg.Params[k] = v
+	}
+
+	locName := dbCfg.DBLoc
+	if locName == "" {
+		locName = defaultDBLoc
+	}
+	loc, err := time.LoadLocation(locName)
+	if err != nil {
+		return nil, err
+	}
+	dsnCfg.Loc = loc
+
+	dsnCfg.Timeout = time.Duration(dbCfg.DBTimeout) * time.Second
+	dsnCfg.ReadTimeout = time.Duration(dbCfg.DBReadTimeout) * time.Second
+	dsnCfg.WriteTimeout = time.Duration(dbCfg.DBWriteTimeout) * time.Second
+
+	tlsName, err := registerTLS(dbCfg)
+	if err != nil {
+		return nil, err
+	}
+	dsnCfg.TLSConfig = tlsName
+	return dsnCfg, nil
+}
+
+// 配置了证书时注册自定义TLS配置，返回DSN中使用的tls参数
+func registerTLS(dbCfg *DBConfig) (string, error) {
+	if dbCfg.DBTLSCA == "" && dbCfg.DBTLSCert == "" {
+		return dbCfg.DBTLS, nil
+	}
+	tlsCfg := &tls.Config{ServerName: dbCfg.DBTLSServerName}
+	if tlsCfg.ServerName == "" {
+		tlsCfg.ServerName = dbCfg.DBHost
+	}
+	if dbCfg.DBTLSCA != "" {
+		pem, err := os.ReadFile(dbCfg.DBTLSCA)
+		if err != nil {
+			return "", err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return "", errors.New("append db tls ca failed")
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if dbCfg.DBTLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(dbCfg.DBTLSCert, dbCfg.DBTLSKey)
+		if err != nil {
+			return "", err
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	tlsName := fmt.Sprintf("cache_%s_%d", dbCfg.DBHost, dbCfg.DBPort)
+	err := mysqldriver.RegisterTLSConfig(tlsName, tlsCfg)
+	if err != nil {
+		return "", err
+	}
+	return tlsName, nil
+}
+
+// 设置连接池参数
+func setupPool(d *gorm.DB, dbCfg *DBConfig) error {
+	sqlDB, err := d.DB()
+	if err != nil {
+		return err
+	}
+	maxOpen, maxIdle := poolSize(dbCfg)
+	if maxOpen > 0 {
+		sqlDB.SetMaxOpenConns(maxOpen)
+	}
+	if maxIdle > 0 {
+		sqlDB.SetMaxIdleConns(maxIdle)
+	}
+	if dbCfg.DBConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(time.Duration(dbCfg.DBConnMaxLifetime) * time.Second)
+	}
+	if dbCfg.DBConnMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(time.Duration(dbCfg.DBConnMaxIdleTime) * time.Second)
+	}
+	return nil
+}
+
+// 连接池的最大打开连接数和最大空闲连接数，未配置时使用DBPool_size
+func poolSize(dbCfg *DBConfig) (int, int) {
+	maxOpen := dbCfg.DBMaxOpenConns
+	if maxOpen == 0 {
+		maxOpen = dbCfg.DBPool_size
+	}
+	maxIdle := dbCfg.DBMaxIdleConns
+	if maxIdle == 0 {
+		maxIdle = dbCfg.DBPool_size
+	}
+	return maxOpen, maxIdle
+}
+
+// 输出最终生效的数据库配置(隐藏密码)
+func logDBConfig(dsnCfg *mysqldriver.Config, dbCfg *DBConfig) {
+	masked := dsnCfg.Clone()
+	if masked.Passwd != "" {
+		masked.Passwd = "******"
+	}
+	maxOpen, maxIdle := poolSize(dbCfg)
+	stats := fmt.Sprintf("maxOpen:%d maxIdle:%d maxLifetime:%ds maxIdleTime:%ds",
+		maxOpen, maxIdle, dbCfg.DBConnMaxLifetime, dbCfg.DBConnMaxIdleTime)
+	logger.Info("cache db config", masked.FormatDSN(), stats)
+}
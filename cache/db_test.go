@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildDSNConfig(t *testing.T) {
+	badCA := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(badCA, []byte("not a pem"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip("no tz database:", err)
+	}
+	base := DBConfig{DBHost: "127.0.0.1", DBPort: 3306, DBUser: "root", DBPass: "pass", DBName: "game"}
+	tests := []struct {
+		name    string
+		modify  func(cfg *DBConfig)
+		params  map[string]string
+		loc     *time.Location
+		timeout [3]time.Duration // 连接、读、写超时
+		tls     string
+		err     bool
+	}{
+		{"default", nil, map[string]string{"charset": "utf8mb4"}, time.Local, [3]time.Duration{}, "", false},
+		{"charset", func(cfg *DBConfig) { cfg.DBEncode = "utf8" }, map[string]string{"charset": "utf8"}, time.Local, [3]time.Duration{}, "", false},
+		{"params", func(cfg *DBConfig) {
+			cfg.DBParams = map[string]string{"sql_mode": "'STRICT_ALL_TABLES'", "charset": "latin1"}
+		},
+			map[string]string{"sql_mode": "'STRICT_ALL_TABLES'", "charset": "latin1"}, time.Local, [3]time.Duration{}, "", false},
+		{"loc", func(cfg *DBConfig) { cfg.DBLoc = "Asia/Shanghai" }, map[string]string{"charset": "utf8mb4"}, shanghai, [3]time.Duration{}, "", false},
+		{"bad loc", func(cfg *DBConfig) { cfg.DBLoc = "Nowhere/City" }, nil, nil, [3]time.Duration{}, "", true},
+		{"timeout", func(cfg *DBConfig) { cfg.DBTimeout, cfg.DBReadTimeout, cfg.DBWriteTimeout = 3, 10, 20 },
+			map[string]string{"charset": "utf8mb4"}, time.Local, [3]time.Duration{3 * time.Second, 10 * time.Second, 20 * time.Second}, "", false},
+		{"tls mode", func(cfg *DBConfig) { cfg.DBTLS = "skip-verify" }, map[string]string{"charset": "utf8mb4"}, time.Local, [3]time.Duration{}, "skip-verify", false},
+		{"missing ca", func(cfg *DBConfig) { cfg.DBTLSCA = filepath.Join(t.TempDir(), "none.pem") }, nil, nil, [3]time.Duration{}, "", true},
+		{"bad ca", func(cfg *DBConfig) { cfg.DBTLSCA = badCA }, nil, nil, [3]time.Duration{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			if tt.modify != nil {
+				tt.modify(&cfg)
+			}
+			dsnCfg, err := buildDSNConfig(&cfg)
+			if (err != nil) != tt.err {
+				t.Fatalf("buildDSNConfig() error = %v, want error %v", err, tt.err)
+			}
+			if tt.err {
+				return
+			}
+			if dsnCfg.Addr != "127.0.0.1:3306" || dsnCfg.User != "root" || dsnCfg.Passwd != "pass" || dsnCfg.DBName != "game" || !dsnCfg.ParseTime {
+				t.Fatalf("buildDSNConfig() = %+v", dsnCfg)
+			}
+			if !reflect.DeepEqual(dsnCfg.Params, tt.params) {
+				t.Errorf("Params = %v, want %v", dsnCfg.Params, tt.params)
+			}
+			if dsnCfg.Loc.String() != tt.loc.String() {
+				t.Errorf("Loc = %s, want %s", dsnCfg.Loc, tt.loc)
+			}
+			timeout := [3]time.Duration{dsnCfg.Timeout, dsnCfg.ReadTimeout, dsnCfg.WriteTimeout}
+			if timeout != tt.timeout {
+				t.Errorf("timeout = %v, want %v", timeout, tt.timeout)
+			}
+			if dsnCfg.TLSConfig != tt.tls {
+				t.Errorf("TLSConfig = %s, want %s", dsnCfg.TLSConfig, tt.tls)
+			}
+		})
+	}
+}
+
+func TestPoolSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     DBConfig
+		maxOpen int
+		maxIdle int
+	}{
+		{"none", DBConfig{}, 0, 0},
+		{"pool size", DBConfig{DBPool_size: 10}, 10, 10},
+		{"override", DBConfig{DBPool_size: 10, DBMaxOpenConns: 50, DBMaxIdleConns: 5}, 50, 5},
+		{"override open", DBConfig{DBPool_size: 10, DBMaxOpenConns: 50}, 50, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxOpen, maxIdle := poolSize(&tt.cfg)
+			if maxOpen != tt.maxOpen || maxIdle != tt.maxIdle {
+				t.Fatalf("poolSize() = %d %d, want %d %d", maxOpen, maxIdle, tt.maxOpen, tt.maxIdle)
+			}
+		})
+	}
+}
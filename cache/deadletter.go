@@ -0,0 +1,275 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fengzhu0601/gotools/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPoisonThreshold = 3           // 连续失败n次后开始二分查找问题数据
+	deadLetterKeepNum      = 100         // 每个容器在内存中保留的最近死信数量
+	poisonRetryGap         = time.Minute // 没有保存到死信存储的问题数据，内容不变时多久后重新尝试写入
+)
+
+// 死信(无法写入数据库、已被隔离的obj)
+type DeadLetter struct {
+	Id      uint64    `gorm:"primaryKey;autoIncrement"`
+	Time    time.Time // 隔离时间
+	ObjType string    `gorm:"size:64"` // obj类型
+	Tab     string    `gorm:"size:64"` // 写入的表名
+	Sid     uint64    `gorm:"index"`
+	Obj     string    `gorm:"type:text"` // obj的json
+	Err     string    `gorm:"type:text"` // 写入数据库的错误
+}
+
+// 死信存储
+type DeadLetterStore interface {
+	Save(letters []*DeadLetter) error
+}
+
+// 死信写入本地文件(每行一条json)
+type fileDeadLetter struct {
+	path string
+	lock sync.Mutex
+}
+
+func NewFileDeadLetter(path string) DeadLetterStore {
+	return &fileDeadLetter{path: path}
+}
+
+func (f *fileDeadLetter) Save(letters []*DeadLetter) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	for _, letter := range letters {
+		err = encoder.Encode(letter)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 死信写入数据库表
+type tableDeadLetter struct {
+	db    *gorm.DB
+	table string
+}
+
+func NewTableDeadLetter(db *gorm.DB, table string) (DeadLetterStore, error) {
+	err := db.Table(table).AutoMigrate(&DeadLetter{})
+	if err != nil {
+		return nil, err
+	}
+	return &tableDeadLetter{db: db, table: table}, nil
+}
+
+func (t *tableDeadLetter) Save(letters []*DeadLetter) error {
+	return t.db.Table(t.table).Create(letters).Error
+}
+
+// 容器的死信记录
+type deadLetters struct {
+	lock   sync.Mutex
+	num    uint64                         // 死信总数
+	recent []*DeadLetter                  // 最近的死信
+	held   map[uint64]map[string]*heldRow // 没有保存到死信存储、保持变更状态的问题数据(sid -> 主键 -> 记录)
+}
+
+// 保持变更状态的问题数据
+type heldRow struct {
+	keys    []interface{}
+	obj     string    // 记录死信时obj的json，内容变化后马上重新写入
+	retryAt time.Time // 内容不变时，到这个时间再重新写入
+}
+
+// 隔离无法写入的obj：保存到死信存储，并保留在内存中供管理页面查看，返回是否已保存到死信存储
+//
+// 没有保存到死信存储的obj保持变更状态，退避poisonRetryGap后重新写入，再次失败时不重复记录死信
+func (c *Container) quarantine(objs []interface{}, errs []error) bool {
+	letters := make([]*DeadLetter, 0, len(objs))
+	now := time.Now()
+	for i, obj := range objs {
+		// 内存中的obj写入时已检查过sid
+		sid, _ := c.sidOf(obj)
+		data, _ := json.Marshal(obj)
+		letter := &DeadLetter{
+			Time:    now,
+			ObjType: c.objType.Name(),
+			Tab:     c.tableOf(sid),
+			Sid:     sid,
+			Obj:     string(data),
+			Err:     errs[i].Error(),
+		}
+		letters = append(letters, letter)
+	}
+
+	c.deadLetters.lock.Lock()
+	for i, letter := range letters {
+		row := c.deadLetters.row(letter.Sid, c.keysOf(objs[i]))
+		if row != nil && row.obj == letter.Obj {
+			continue
+		}
+		logger.Error("cache quarantine obj:", letter.ObjType, letter.Sid, letter.Obj, letter.Err)
+		c.deadLetters.num++
+		c.deadLetters.recent = append(c.deadLetters.recent, letter)
+	}
+	if over := len(c.deadLetters.recent) - deadLetterKeepNum; over > 0 {
+		c.deadLetters.recent = c.deadLetters.recent[over:]
+	}
+	c.deadLetters.lock.Unlock()
+
+	saved := false
+	if c.opts.deadLetter != nil {
+		err := c.opts.deadLetter.Save(letters)
+		if err != nil {
+			logger.Error("cache save dead letter error:", c.objType, len(letters), err)
+		} else {
+			saved = true
+		}
+	}
+
+	c.deadLetters.lock.Lock()
+	defer c.deadLetters.lock.Unlock()
+	for i, letter := range letters {
+		keys := c.keysOf(objs[i])
+		if saved {
+			c.deadLetters.release(letter.Sid, keys)
+		} else {
+			c.deadLetters.hold(letter.Sid, keys, letter.Obj, now.Add(poisonRetryGap))
+		}
+	}
+	return saved
+}
+
+// 保持变更状态的问题数据
+func (d *deadLetters) row(sid uint64, keys []interface{}) *heldRow {
+	return d.held[sid][joinKeys(keys)]
+}
+
+func (d *deadLetters) hold(sid uint64, keys []interface{}, obj string, retryAt time.Time) {
+	if d.held == nil {
+		d.held = make(map[uint64]map[string]*heldRow)
+	}
+	rows, exit := d.held[sid]
+	if !exit {
+		rows = make(map[string]*heldRow)
+		d.held[sid] = rows
+	}
+	rows[joinKeys(keys)] = &heldRow{keys: keys, obj: obj, retryAt: retryAt}
+}
+
+func (d *deadLetters) release(sid uint64, keys []interface{}) {
+	rows, exit := d.held[sid]
+	if !exit {
+		return
+	}
+	delete(rows, joinKeys(keys))
+	if len(rows) == 0 {
+		delete(d.held, sid)
+	}
+}
+
+// 从本次写入中去掉退避中、内容没有变化的问题数据，返回要写入的和暂缓写入的obj
+func (c *Container) holdPoison(updateObjs []interface{}) ([]interface{}, []interface{}) {
+	c.deadLetters.lock.Lock()
+	defer c.deadLetters.lock.Unlock()
+	if len(c.deadLetters.held) == 0 {
+		return updateObjs, nil
+	}
+	now := time.Now()
+	objs := make([]interface{}, 0, len(updateObjs))
+	var heldObjs []interface{}
+	for _, obj := range updateObjs {
+		sid, _ := c.sidOf(obj)
+		row := c.deadLetters.row(sid, c.keysOf(obj))
+		if row != nil && now.Before(row.retryAt) {
+			data, _ := json.Marshal(obj)
+			if string(data) == row.obj {
+				heldObjs = append(heldObjs, obj)
+				continue
+			}
+		}
+		objs = append(objs, obj)
+	}
+	return objs, heldObjs
+}
+
+// 写入成功的obj不再是问题数据(keepObjs为仍需保持变更状态的obj)
+func (c *Container) releaseHeld(objs []interface{}, keepObjs []interface{}) {
+	c.deadLetters.lock.Lock()
+	defer c.deadLetters.lock.Unlock()
+	if len(c.deadLetters.held) == 0 {
+		return
+	}
+	keep := make(map[interface{}]bool, len(keepObjs))
+	for _, obj := range keepObjs {
+		keep[obj] = true
+	}
+	for _, obj := range objs {
+		if !keep[obj] {
+			sid, _ := c.sidOf(obj)
+			c.deadLetters.release(sid, c.keysOf(obj))
+		}
+	}
+}
+
+// sid是否有保持变更状态的问题数据(有的时候cell不能回收)，已被删除的问题数据不再保留
+func (c *Container) hasHeld(sid uint64, cargo CargoInt) bool {
+	c.deadLetters.lock.Lock()
+	defer c.deadLetters.lock.Unlock()
+	for _, row := range c.deadLetters.held[sid] {
+		if cargo.GetObj(row.keys...) == nil {
+			c.deadLetters.release(sid, row.keys)
+		}
+	}
+	return len(c.deadLetters.held[sid]) > 0
+}
+
+// 问题数据重新标记为变更(在afterSyncDb之后调用，只有问题数据保持变更状态，有问题数据的cell不会被回收)
+func (c *Container) keepDirty(objs []interface{}) {
+	for _, obj := range objs {
+		sid, _ := c.sidOf(obj)
+		cargo := c.getCargo(sid, true)
+		// 同步期间可能已被新的写入替换或删除，以内存中当前的obj为准
+		if cur := cargo.GetObj(c.keysOf(obj)...); cur != nil {
+			cargo.Replace(cur)
+		}
+	}
+}
+
+// 最近的死信
+func (c *Container) DeadLetters() []*DeadLetter {
+	c.deadLetters.lock.Lock()
+	defer c.deadLetters.lock.Unlock()
+	list := make([]*DeadLetter, len(c.deadLetters.recent))
+	copy(list, c.deadLetters.recent)
+	return list
+}
+
+// 输出所有容器最近的死信
+func (cache *Cache) PrintDeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters := make(map[string][]*DeadLetter)
+	for _, container := range cache.containerList {
+		list := container.DeadLetters()
+		if len(list) > 0 {
+			letters[container.objType.Name()] = list
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(letters)
+	if err != nil {
+		logger.Error("PrintDeadLetters error", err)
+	}
+}
@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/fengzhu0601/gotools/cache/internal/fakedb"
+)
+
+// 没有死信存储时问题数据只记录一次，退避期间不再写入，内容修改后重新写入
+func TestQuarantineNoStore(t *testing.T) {
+	db := fakedb.New()
+	c := newTestContainer(t, db, testItemType, WithPreload(true), WithPoisonThreshold(1))
+	for i, name := range []string{"a", "bad", "c"} {
+		c.Replace(&testItem{Sid: 1, Id: uint32(i + 1), Name: name})
+	}
+	written := failOnName(db, map[string]bool{"bad": true}, 1406)
+
+	tests := []struct {
+		name    string
+		prepare func()
+		written []uint32 // 本次写入成功的id
+		letters int
+		dirty   bool
+	}{
+		{"isolate", nil, []uint32{1, 3}, 1, true},
+		{"hold during backoff", nil, []uint32{}, 1, true},
+		{"other write", func() { c.Replace(&testItem{Sid: 1, Id: 1, Name: "a2"}) }, []uint32{1}, 1, true},
+		{"retry after backoff", func() { c.deadLetters.held[1]["2"].retryAt = time.Now() }, []uint32{}, 1, true},
+		{"fixed", func() { c.Replace(&testItem{Sid: 1, Id: 2, Name: "b"}) }, []uint32{2}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.prepare != nil {
+				tt.prepare()
+			}
+			*written = (*written)[:0]
+			c.updater.batchUpdate()
+			sort.Slice(*written, func(i, j int) bool { return (*written)[i] < (*written)[j] })
+			if !reflect.DeepEqual(*written, tt.written) {
+				t.Errorf("written = %v, want %v", *written, tt.written)
+			}
+			if letters := c.DeadLetters(); len(letters) != tt.letters || c.deadLetters.num != uint64(tt.letters) {
+				t.Errorf("dead letters = %d num %d, want %d", len(letters), c.deadLetters.num, tt.letters)
+			}
+			cell, _ := c.cellLoad(1)
+			if cell.isChange() != tt.dirty {
+				t.Errorf("cell changed = %v, want %v", cell.isChange(), tt.dirty)
+			}
+		})
+	}
+	if len(c.deadLetters.held) != 0 {
+		t.Fatalf("held rows after fix = %v", c.deadLetters.held)
+	}
+}
@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// 全局容器的所有数据存放在这个sid下
+const globalSid uint64 = 0
+
+// 全局容器(公会、拍卖、世界状态等全服数据)：按obj自身的主键存储，没有sid语义
+//
+// 全局容器整表预加载，不参与玩家数据的回收、预初始化和导入导出；主键为前n个primaryKey字段，可以是任意可比较的类型
+func WithGlobal() ContainerOption {
+	return func(o *containerOptions) {
+		o.global = true
+	}
+}
+
+// 检查全局容器的配置
+func checkGlobal(objType reflect.Type, options *containerOptions) {
+	if options.shard != nil || options.ordered || options.list || options.sequenceBlock > 0 || len(options.counterFields) > 0 {
+		panic(fmt.Sprintf("cache global container does not support shard/ordered/list/sequence/counter, objType:%s", objType))
+	}
+	// 全局数据常驻内存
+	options.preload = true
+}
+
+// 由主键字段组成的结构(全局载体的多主键和批量删除使用)
+func globalKeyType(objType reflect.Type, keyNum int) reflect.Type {
+	fields := make([]reflect.StructField, 0, keyNum)
+	for i := 0; i < keyNum; i++ {
+		field := objType.Field(i)
+		fields = append(fields, reflect.StructField{Name: field.Name, Type: field.Type})
+	}
+	return reflect.StructOf(fields)
+}
+
+// 按主键获取全局容器中的obj(key类型需和主键字段一致，整数之间可以互转)
+func (c *Container) LookupGlobal(keys ...interface{}) interface{} {
+	return c.getCargo(globalSid, false).GetObj(keys...)
+}
+
+// 按主键前缀获取全局容器中的obj(不传key时获取所有obj)
+func (c *Container) LookupGlobalObjs(keys ...interface{}) []interface{} {
+	return c.getCargo(globalSid, false).GetPrefixObjs(keys...)
+}
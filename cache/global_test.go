@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fengzhu0601/gotools/cache/internal/fakedb"
+)
+
+// 全局容器的obj：主键为公会名和成员id，没有sid
+type guildMember struct {
+	Guild string `gorm:"primaryKey"`
+	Id    uint32 `gorm:"primaryKey"`
+	Num   int
+}
+
+var guildMemberType = reflect.TypeOf(guildMember{})
+
+func loadGuildMembers(db *fakedb.DB) {
+	db.QueryFn = func(query string, args []interface{}) (*fakedb.Rows, error) {
+		if !strings.Contains(query, "FROM `guild_member`") {
+			return nil, nil
+		}
+		return &fakedb.Rows{Columns: []string{"guild", "id", "num"}, Values: [][]driver.Value{
+			{"a", int64(1), int64(10)},
+			{"a", int64(2), int64(20)},
+			{"b", int64(1), int64(30)},
+		}}, nil
+	}
+}
+
+// 全局容器强制预加载，不支持分表、有序、列表、序列和计数器
+func TestCheckGlobal(t *testing.T) {
+	tests := []struct {
+		name  string
+		opt   ContainerOption
+		panic bool
+	}{
+		{"preload false", WithPreload(false), false},
+		{"shard", WithShard(&ShardConfig{Num: 2}), true},
+		{"ordered", WithOrdered(true), true},
+		{"list", WithList(10), true},
+		{"sequence", WithSequence(10), true},
+		{"counter", WithCounter("Num"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); (r != nil) != tt.panic {
+					t.Fatalf("panic = %v, want panic %v", r, tt.panic)
+				}
+			}()
+			options := defaultOptions(newTestCache(t, fakedb.New()))
+			tt.opt(options)
+			checkGlobal(guildMemberType, options)
+			if !options.preload {
+				t.Fatalf("global container not preloaded")
+			}
+		})
+	}
+}
+
+// 全局容器的obj都存放在globalSid下，按所有主键查找
+func TestGlobalRouting(t *testing.T) {
+	db := fakedb.New()
+	loadGuildMembers(db)
+	cache := newTestCache(t, db)
+	cache.InitContainer(guildMemberType, WithGlobal(), WithPreload(false))
+	c := cache.containers[guildMemberType]
+	if !c.preload {
+		t.Fatalf("global container not preloaded")
+	}
+	for _, stmt := range db.QueriesOf("FROM `guild_member`") {
+		if strings.Contains(stmt.Query, "sid") {
+			t.Fatalf("global query with sid: %s", stmt.Query)
+		}
+	}
+
+	if got := c.LookupGlobal("a", 2); !reflect.DeepEqual(got, &guildMember{Guild: "a", Id: 2, Num: 20}) {
+		t.Fatalf("LookupGlobal(a, 2) = %+v", got)
+	}
+	if got := c.LookupGlobal("a", uint8(1)); got == nil || got.(*guildMember).Num != 10 {
+		t.Fatalf("LookupGlobal(a, uint8(1)) = %+v", got)
+	}
+	if got := c.LookupGlobal("c", 1); got != nil {
+		t.Fatalf("LookupGlobal(c, 1) = %+v, want nil", got)
+	}
+	if got := len(c.LookupGlobalObjs("a")); got != 2 {
+		t.Fatalf("LookupGlobalObjs(a) = %d, want 2", got)
+	}
+
+	obj := &guildMember{Guild: "c", Id: 1, Num: 40}
+	if sid, ok := c.sidOf(obj); !ok || sid != globalSid {
+		t.Fatalf("sidOf() = %d %v, want globalSid", sid, ok)
+	}
+	if !reflect.DeepEqual(c.keysOf(obj), []interface{}{"c", uint64(1)}) {
+		t.Fatalf("keysOf() = %v", c.keysOf(obj))
+	}
+	if err := c.Replace(obj); err != nil {
+		t.Fatal(err)
+	}
+	if c.LookupGlobal("c", 1) != obj || len(c.LookupGlobalObjs()) != 4 {
+		t.Fatalf("replaced obj not in global cargo: %v", c.LookupGlobalObjs())
+	}
+	if !c.Delete(&guildMember{Guild: "a", Id: 1}) {
+		t.Fatalf("Delete() = false")
+	}
+	if c.LookupGlobal("a", 1) != nil {
+		t.Fatalf("deleted obj still cached")
+	}
+	if _, exit := c.cellLoad(globalSid); !exit {
+		t.Fatalf("global cell not loaded")
+	}
+	if _, exit := c.cellLoad(1); exit {
+		t.Fatalf("global obj routed by first field")
+	}
+}
@@ -4,15 +4,15 @@ go 1.20
 
 require (
 	github.com/fengzhu0601/gotools/logger v0.0.0-20231215121725-ea991bd4ef16
+	github.com/go-sql-driver/mysql v1.7.0
+	github.com/natefinch/lumberjack v2.0.0+incompatible
 	gorm.io/driver/mysql v1.5.2
 	gorm.io/gorm v1.25.5
 )
 
 require (
-	github.com/go-sql-driver/mysql v1.7.0 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
-	github.com/natefinch/lumberjack v2.0.0+incompatible // indirect
 	go.uber.org/multierr v1.10.0 // indirect
 	go.uber.org/zap v1.26.0 // indirect
 )
@@ -0,0 +1,41 @@
+package cache
+
+// obj类型可选实现的生命周期接口
+
+// 从数据库加载到缓存后调用(可重新计算派生字段)
+type AfterCacheLoader interface {
+	AfterCacheLoad()
+}
+
+// Replace前调用，返回错误时不写入缓存，错误返回给调用者
+type Validator interface {
+	Validate() error
+}
+
+// 被updater收集、准备写入数据库前调用(可设置updated_at等字段)
+//
+// 在载体收集变更的锁内调用，不会和同一载体的Replace/Delete交错(调用方在锁外原地修改obj时仍需自己同步)
+type BeforeFlusher interface {
+	BeforeFlush()
+}
+
+func afterCacheLoad(obj interface{}) {
+	if loader, ok := obj.(AfterCacheLoader); ok {
+		loader.AfterCacheLoad()
+	}
+}
+
+func validate(obj interface{}) error {
+	if validator, ok := obj.(Validator); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+func beforeFlush(objs []interface{}) {
+	for _, obj := range objs {
+		if flusher, ok := obj.(BeforeFlusher); ok {
+			flusher.BeforeFlush()
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fengzhu0601/gotools/cache/internal/fakedb"
+)
+
+type hookItem struct {
+	Sid     uint64 `gorm:"primaryKey"`
+	Id      uint32 `gorm:"primaryKey"`
+	Num     int
+	Flushed int
+	Double  int `gorm:"-"` // 加载后计算的派生字段
+}
+
+var hookItemType = reflect.TypeOf(hookItem{})
+
+func (h *hookItem) Validate() error {
+	if h.Num < 0 {
+		return errors.New("negative num")
+	}
+	return nil
+}
+
+func (h *hookItem) AfterCacheLoad() {
+	h.Double = h.Num * 2
+}
+
+func (h *hookItem) BeforeFlush() {
+	h.Flushed++
+}
+
+func TestValidator(t *testing.T) {
+	tests := []struct {
+		name         string
+		writeThrough bool
+		num          int
+		err          bool
+	}{
+		{"valid", false, 1, false},
+		{"invalid", false, -1, true},
+		{"write through valid", true, 1, false},
+		{"write through invalid", true, -1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakedb.New()
+			c := newTestContainer(t, db, hookItemType, WithPreload(true), WithWriteThrough(tt.writeThrough))
+			err := c.Replace(&hookItem{Sid: 1, Id: 1, Num: tt.num})
+			if (err != nil) != tt.err {
+				t.Fatalf("Replace() error = %v, want error %v", err, tt.err)
+			}
+			stored := c.LookupObjs(1)
+			if (len(stored) == 0) != tt.err {
+				t.Fatalf("LookupObjs() = %v after Replace() error %v", stored, err)
+			}
+			if cell, exit := c.cellLoad(1); tt.err && exit && cell.isChange() {
+				t.Fatalf("rejected write marked cell changed")
+			}
+			if writes := db.ExecsOf("REPLACE INTO"); tt.err && len(writes) != 0 {
+				t.Fatalf("rejected write executed %v", writes)
+			}
+		})
+	}
+}
+
+// 加载后先调用AfterCacheLoad再更新索引，索引可以使用派生字段
+func TestAfterCacheLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		preload bool
+	}{
+		{"preload", true},
+		{"load on demand", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakedb.New()
+			db.QueryFn = func(query string, args []interface{}) (*fakedb.Rows, error) {
+				if !strings.Contains(query, "FROM `hook_item`") {
+					return nil, nil
+				}
+				return &fakedb.Rows{
+					Columns: []string{"sid", "id", "num", "flushed"},
+					Values:  [][]driver.Value{{int64(1), int64(1), int64(3), int64(0)}},
+				}, nil
+			}
+			c := newTestContainer(t, db, hookItemType, WithPreload(tt.preload), WithIndex("double", "Double"))
+			obj, ok := c.Lookup(1, 1).(*hookItem)
+			if !ok || obj.Double != 6 {
+				t.Fatalf("Lookup() = %+v, want Double 6", obj)
+			}
+			if found := c.FindBy("double", 6); len(found) != 1 || found[0] != obj {
+				t.Fatalf("FindBy(double) = %v, want loaded obj", found)
+			}
+		})
+	}
+}
+
+// BeforeFlush只在updater收集变更准备写库时调用，积压统计不会调用
+func TestBeforeFlush(t *testing.T) {
+	db := fakedb.New()
+	c := newTestContainer(t, db, hookItemType, WithPreload(true), WithHighWater(HighWater{DirtyObjs: 10}))
+	a := &hookItem{Sid: 1, Id: 1, Num: 1}
+	b := &hookItem{Sid: 2, Id: 1, Num: 2}
+	c.Replace(a)
+	c.Replace(b)
+	c.checkBacklog()
+	if a.Flushed != 0 || b.Flushed != 0 {
+		t.Fatalf("BeforeFlush called by backlog check: %d %d", a.Flushed, b.Flushed)
+	}
+	c.updater.batchUpdate()
+	if a.Flushed != 1 || b.Flushed != 1 {
+		t.Fatalf("Flushed = %d %d, want 1 1", a.Flushed, b.Flushed)
+	}
+	for _, stmt := range db.ExecsOf("REPLACE INTO") {
+		// sid, id, num, flushed
+		if stmt.Args[3] != 1 {
+			t.Fatalf("REPLACE args = %v, want flushed 1", stmt.Args)
+		}
+	}
+	c.updater.batchUpdate()
+	if a.Flushed != 1 {
+		t.Fatalf("BeforeFlush called for clean obj")
+	}
+
+	// 写穿透同样在写库前调用
+	wt := newTestContainer(t, fakedb.New(), hookItemType, WithPreload(true), WithWriteThrough(true))
+	obj := &hookItem{Sid: 1, Id: 1}
+	if err := wt.ReplaceSync(context.Background(), obj); err != nil || obj.Flushed != 1 {
+		t.Fatalf("ReplaceSync() = %v Flushed %d, want nil 1", err, obj.Flushed)
+	}
+}
@@ -0,0 +1,219 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/fengzhu0601/gotools/logger"
+)
+
+// 内存数据变化的观察者(二级索引等)
+//
+// 除了Replace/Delete，缓存加载(old为nil)和内存回收(obj为nil)也会通知，不会通知订阅者
+type observer interface {
+	observe(sid uint64, keys []interface{}, old interface{}, obj interface{})
+}
+
+// 二级索引定义
+type indexDef struct {
+	name   string
+	field  string
+	unique bool
+}
+
+// 内存中的二级索引：字段值 -> 主键 -> obj
+//
+// 非预加载容器的索引只包含已加载到内存中的cell，查询不到不代表数据库中没有
+type index struct {
+	name   string
+	field  reflect.StructField
+	unique bool
+	lock   sync.RWMutex
+	values map[interface{}]map[string]interface{} // 字段值 -> 主键 -> obj
+	keys   map[string]interface{}                 // 主键 -> 索引中的字段值
+}
+
+// 在非主键字段上建立多值索引，通过FindBy查询
+func WithIndex(name string, field string) ContainerOption {
+	return func(o *containerOptions) {
+		o.indexes = append(o.indexes, indexDef{name: name, field: field})
+	}
+}
+
+// 在非主键字段上建立唯一索引，Replace/Append时字段值和其他obj重复会返回错误，不修改缓存
+//
+// 只检查内存中已加载的obj：非预加载容器未加载的cell不参与检查，需要数据库的唯一索引兜底
+func WithUniqueIndex(name string, field string) ContainerOption {
+	return func(o *containerOptions) {
+		o.indexes = append(o.indexes, indexDef{name: name, field: field, unique: true})
+	}
+}
+
+func newIndex(objType reflect.Type, def indexDef) *index {
+	field, exit := objType.FieldByName(def.field)
+	if !exit {
+		panic(fmt.Sprintf("cache index field not found, objType:%s field:%s", objType, def.field))
+	}
+	return &index{
+		name:   def.name,
+		field:  field,
+		unique: def.unique,
+		values: make(map[interface{}]map[string]interface{}),
+		keys:   make(map[string]interface{}),
+	}
+}
+
+// obj的主键字符串
+func indexKey(sid uint64, keys []interface{}) string {
+	return fmt.Sprint(sid, ",", joinKeys(keys))
+}
+
+func (idx *index) valueOf(obj interface{}) interface{} {
+	return reflect.ValueOf(obj).Elem().FieldByIndex(idx.field.Index).Interface()
+}
+
+// 查询值转换成字段类型(如int转换成uint32)
+func (idx *index) convert(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return value
+	}
+	if v.Type() != idx.field.Type && v.Type().ConvertibleTo(idx.field.Type) {
+		return v.Convert(idx.field.Type).Interface()
+	}
+	return value
+}
+
+func (idx *index) observe(sid uint64, keys []interface{}, old interface{}, obj interface{}) {
+	key := indexKey(sid, keys)
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	idx.update(key, obj)
+}
+
+// 更新主键对应的索引项，obj为nil时删除(调用时已持有锁)
+func (idx *index) update(key string, obj interface{}) {
+	// 调用方可能原地修改obj后再Replace，通过主键找到之前的字段值
+	if value, exit := idx.keys[key]; exit {
+		objs := idx.values[value]
+		delete(objs, key)
+		if len(objs) == 0 {
+			delete(idx.values, value)
+		}
+		delete(idx.keys, key)
+	}
+	if obj == nil {
+		return
+	}
+	value := idx.valueOf(obj)
+	objs, exit := idx.values[value]
+	if !exit {
+		objs = make(map[string]interface{})
+		idx.values[value] = objs
+	}
+	objs[key] = obj
+	idx.keys[key] = value
+}
+
+func (idx *index) find(value interface{}) []interface{} {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+	objs := idx.values[idx.convert(value)]
+	list := make([]interface{}, 0, len(objs))
+	for _, obj := range objs {
+		list = append(list, obj)
+	}
+	return list
+}
+
+// 在唯一索引中占用obj的字段值，已被其他obj占用时返回false
+//
+// 检查和占用在同一次加锁内完成，并发写入相同的值时只有一个成功
+func (idx *index) reserve(sid uint64, keys []interface{}, obj interface{}) bool {
+	key := indexKey(sid, keys)
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	for other := range idx.values[idx.valueOf(obj)] {
+		if other != key {
+			return false
+		}
+	}
+	idx.update(key, obj)
+	return true
+}
+
+// 通过二级索引查询obj(无序)，非预加载容器只能查到已加载到内存中的数据
+func (c *Container) FindBy(name string, value interface{}) []interface{} {
+	idx, exit := c.indexes[name]
+	if !exit {
+		logger.Error("cache index not found", c.objType, name)
+		return nil
+	}
+	return idx.find(value)
+}
+
+// 通过唯一索引查询单个obj，不存在时返回nil
+func (c *Container) FindOneBy(name string, value interface{}) interface{} {
+	objs := c.FindBy(name, value)
+	if len(objs) == 0 {
+		return nil
+	}
+	return objs[0]
+}
+
+// 写入前占用唯一索引中的字段值，old为写入前的obj(冲突时恢复已占用的索引)
+func (c *Container) reserveUnique(sid uint64, keys []interface{}, old interface{}, obj interface{}) error {
+	reserved := make([]*index, 0)
+	for name, idx := range c.indexes {
+		if !idx.unique {
+			continue
+		}
+		if !idx.reserve(sid, keys, obj) {
+			c.releaseUnique(reserved, sid, keys, old)
+			return fmt.Errorf("cache unique index conflict, objType:%s index:%s value:%v", c.objType, name, idx.valueOf(obj))
+		}
+		reserved = append(reserved, idx)
+	}
+	return nil
+}
+
+// 写入失败时把唯一索引恢复成写入前的obj
+func (c *Container) releaseUnique(list []*index, sid uint64, keys []interface{}, old interface{}) {
+	for _, idx := range list {
+		idx.observe(sid, keys, nil, old)
+	}
+}
+
+// 所有唯一索引
+func (c *Container) uniqueIndexes() []*index {
+	list := make([]*index, 0)
+	for _, idx := range c.indexes {
+		if idx.unique {
+			list = append(list, idx)
+		}
+	}
+	return list
+}
+
+// 通知观察者
+func (c *Container) observe(sid uint64, keys []interface{}, old interface{}, obj interface{}) {
+	for _, o := range c.observers {
+		o.observe(sid, keys, old, obj)
+	}
+}
+
+// 通知观察者一批obj被加载(removed为false)或从内存回收(removed为true)
+func (c *Container) observeObjs(objs []interface{}, removed bool) {
+	if len(c.observers) == 0 {
+		return
+	}
+	for _, obj := range objs {
+		sid, _ := c.sidOf(obj)
+		if removed {
+			c.observe(sid, c.keysOf(obj), obj, nil)
+		} else {
+			c.observe(sid, c.keysOf(obj), nil, obj)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/fengzhu0601/gotools/cache/internal/fakedb"
+)
+
+// 索引中某个值对应的obj的Id(排序后)
+func foundIds(c *Container, name string, value interface{}) []uint32 {
+	ids := make([]uint32, 0)
+	for _, obj := range c.FindBy(name, value) {
+		ids = append(ids, obj.(*testItem).Id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func TestFindBy(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(c *Container)
+		value interface{}
+		want  []uint32
+	}{
+		{"loaded", func(c *Container) {}, 1, []uint32{1}},
+		{"insert", func(c *Container) {
+			c.Replace(&testItem{Sid: 2, Id: 2, Num: 1})
+		}, 1, []uint32{1, 2}},
+		{"query value converted", func(c *Container) {}, uint8(1), []uint32{1}},
+		{"in place old value", func(c *Container) {
+			obj := c.Lookup(1, 1).(*testItem)
+			obj.Num = 2
+			c.Replace(obj)
+		}, 1, []uint32{}},
+		{"in place new value", func(c *Container) {
+			obj := c.Lookup(1, 1).(*testItem)
+			obj.Num = 2
+			c.Replace(obj)
+		}, 2, []uint32{1}},
+		{"new obj", func(c *Container) {
+			c.Replace(&testItem{Sid: 1, Id: 1, Num: 3})
+		}, 3, []uint32{1}},
+		{"delete", func(c *Container) {
+			c.Delete(&testItem{Sid: 1, Id: 1})
+		}, 1, []uint32{}},
+		{"delete objs", func(c *Container) {
+			c.Replace(&testItem{Sid: 1, Id: 2, Num: 1})
+			c.DeleteObjs(1)
+		}, 1, []uint32{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakedb.New()
+			num := int64(1)
+			loadTestItemNum(db, &num)
+			c := newTestContainer(t, db, testItemType, WithPreload(true), WithIndex("num", "Num"))
+			tt.write(c)
+			if got := foundIds(c, "num", tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("FindBy(%v) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// 内存回收的cell从索引中移除
+func TestIndexEvict(t *testing.T) {
+	db := fakedb.New()
+	num := int64(1)
+	loadTestItemNum(db, &num)
+	c := newTestContainer(t, db, testItemType, WithPreload(false), WithIndex("num", "Num"))
+	if c.Lookup(1, 1) == nil || len(c.FindBy("num", 1)) != 1 {
+		t.Fatalf("loaded obj not indexed")
+	}
+	cell, _ := c.cellLoad(1)
+	cell.releaseTime = 1
+	c.updater.batchUpdate()
+	if _, exit := c.cellLoad(1); exit || len(c.FindBy("num", 1)) != 0 {
+		t.Fatalf("evicted obj still indexed")
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	tests := []struct {
+		name    string
+		write   func(c *Container) error
+		err     bool
+		want    map[string][]uint32 // 名字 -> Id
+		changed bool                // 写入后cell 1是否有未写入的变更
+	}{
+		{"conflict", func(c *Container) error {
+			return c.Replace(&testItem{Sid: 1, Id: 2, Name: "a"})
+		}, true, map[string][]uint32{"a": {1}}, false},
+		{"conflict other sid", func(c *Container) error {
+			return c.Replace(&testItem{Sid: 2, Id: 2, Name: "a"})
+		}, true, map[string][]uint32{"a": {1}}, false},
+		{"same obj", func(c *Container) error {
+			return c.Replace(&testItem{Sid: 1, Id: 1, Num: 2, Name: "a"})
+		}, false, map[string][]uint32{"a": {1}}, true},
+		{"rename", func(c *Container) error {
+			return c.Replace(&testItem{Sid: 1, Id: 1, Name: "b"})
+		}, false, map[string][]uint32{"a": {}, "b": {1}}, true},
+		{"reuse after rename", func(c *Container) error {
+			c.Replace(&testItem{Sid: 1, Id: 1, Name: "b"})
+			return c.Replace(&testItem{Sid: 1, Id: 2, Name: "a"})
+		}, false, map[string][]uint32{"a": {2}, "b": {1}}, true},
+		{"reuse after delete", func(c *Container) error {
+			c.Delete(&testItem{Sid: 1, Id: 1})
+			return c.Replace(&testItem{Sid: 1, Id: 2, Name: "a"})
+		}, false, map[string][]uint32{"a": {2}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContainer(t, fakedb.New(), testItemType, WithPreload(true), WithUniqueIndex("name", "Name"))
+			c.Replace(&testItem{Sid: 1, Id: 1, Name: "a"})
+			c.updater.batchUpdate()
+			err := tt.write(c)
+			if (err != nil) != tt.err {
+				t.Fatalf("Replace() error = %v, want error %v", err, tt.err)
+			}
+			for name, want := range tt.want {
+				if got := foundIds(c, "name", name); !reflect.DeepEqual(got, want) {
+					t.Fatalf("FindBy(%s) = %v, want %v", name, got, want)
+				}
+			}
+			if cell, _ := c.cellLoad(1); cell.isChange() != tt.changed {
+				t.Fatalf("cell changed = %v, want %v", cell.isChange(), tt.changed)
+			}
+		})
+	}
+}
+
+// 并发写入相同的值只有一个成功
+func TestUniqueIndexConcurrent(t *testing.T) {
+	c := newTestContainer(t, fakedb.New(), testItemType, WithPreload(true), WithUniqueIndex("name", "Name"))
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	success := 0
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if c.Replace(&testItem{Sid: uint64(i), Id: 1, Name: "a"}) == nil {
+				lock.Lock()
+				success++
+				lock.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if success != 1 || len(c.FindBy("name", "a")) != 1 {
+		t.Fatalf("success = %d FindBy = %v, want 1", success, c.FindBy("name", "a"))
+	}
+}
+
+// 定长列表追加时同样检查唯一索引，冲突时不追加，淘汰的obj释放索引
+func TestUniqueIndexAppend(t *testing.T) {
+	c := newTestContainer(t, fakedb.New(), testItemType, WithPreload(true), WithList(2), WithUniqueIndex("name", "Name"))
+	for _, name := range []string{"a", "b"} {
+		if err := c.Append(&testItem{Sid: 1, Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Append(&testItem{Sid: 2, Name: "a"}); err == nil {
+		t.Fatalf("Append() conflict without error")
+	}
+	if _, exit := c.cellLoad(2); exit && len(c.LookupObjs(2)) != 0 {
+		t.Fatalf("conflicting obj appended: %v", c.LookupObjs(2))
+	}
+	if err := c.Append(&testItem{Sid: 1, Name: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := foundIds(c, "name", "a"); len(got) != 0 {
+		t.Fatalf("evicted obj still indexed: %v", got)
+	}
+	if err := c.Append(&testItem{Sid: 2, Name: "a"}); err != nil {
+		t.Fatalf("Append() after evict error = %v", err)
+	}
+	if got := foundIds(c, "name", "c"); !reflect.DeepEqual(got, []uint32{3}) {
+		t.Fatalf("FindBy(c) = %v, want [3]", got)
+	}
+}
@@ -0,0 +1,220 @@
+// 测试用的假数据库：记录执行的sql，按需返回查询结果或错误，不需要真实的mysql
+package fakedb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	gormlog "gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// 一条执行过的sql(事务的BEGIN/COMMIT/ROLLBACK也会记录在Execs中)
+type Stmt struct {
+	Query string
+	Args  []interface{}
+}
+
+// 查询返回的结果集
+type Rows struct {
+	Columns []string
+	Values  [][]driver.Value
+}
+
+type DB struct {
+	lock    sync.Mutex
+	Execs   []Stmt
+	Queries []Stmt
+	// 自定义执行结果，返回影响行数和错误(为空时成功，影响行数为1)
+	ExecFn func(query string, args []interface{}) (int64, error)
+	// 自定义查询结果(为空或返回nil时为空结果集)
+	QueryFn func(query string, args []interface{}) (*Rows, error)
+}
+
+func New() *DB {
+	return &DB{}
+}
+
+// 打开一个使用假数据库的gorm连接(命名策略与cache相同)
+func (db *DB) Gorm() *gorm.DB {
+	d, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      sql.OpenDB(connector{db}),
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{
+		NamingStrategy:         schema.NamingStrategy{SingularTable: true},
+		SkipDefaultTransaction: true,
+		Logger:                 gormlog.Discard,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// 执行过的包含sub的sql
+func (db *DB) ExecsOf(sub string) []Stmt {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	list := make([]Stmt, 0)
+	for _, stmt := range db.Execs {
+		if strings.Contains(stmt.Query, sub) {
+			list = append(list, stmt)
+		}
+	}
+	return list
+}
+
+// 查询过的包含sub的sql
+func (db *DB) QueriesOf(sub string) []Stmt {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	list := make([]Stmt, 0)
+	for _, stmt := range db.Queries {
+		if strings.Contains(stmt.Query, sub) {
+			list = append(list, stmt)
+		}
+	}
+	return list
+}
+
+// 清空记录
+func (db *DB) Reset() {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.Execs = nil
+	db.Queries = nil
+}
+
+func (db *DB) exec(query string, args []interface{}) (int64, error) {
+	db.lock.Lock()
+	db.Execs = append(db.Execs, Stmt{Query: query, Args: args})
+	fn := db.ExecFn
+	db.lock.Unlock()
+	if fn == nil {
+		return 1, nil
+	}
+	return fn(query, args)
+}
+
+func (db *DB) query(query string, args []interface{}) (*Rows, error) {
+	db.lock.Lock()
+	db.Queries = append(db.Queries, Stmt{Query: query, Args: args})
+	fn := db.QueryFn
+	db.lock.Unlock()
+	if fn == nil {
+		return &Rows{}, nil
+	}
+	rows, err := fn(query, args)
+	if rows == nil {
+		rows = &Rows{}
+	}
+	return rows, err
+}
+
+type connector struct {
+	db *DB
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{db: c.db}, nil
+}
+
+func (c connector) Driver() driver.Driver {
+	return fakeDriver{c.db}
+}
+
+type fakeDriver struct {
+	db *DB
+}
+
+func (d fakeDriver) Open(string) (driver.Conn, error) {
+	return &conn{db: d.db}, nil
+}
+
+type conn struct {
+	db *DB
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	c.db.exec("BEGIN", nil)
+	return tx{c.db}, nil
+}
+
+// 参数原样传给ExecFn/QueryFn
+func (c *conn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	n, err := c.db.exec(query, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(n), nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.db.query(query, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return &rowsIter{rows: rows}, nil
+}
+
+type tx struct {
+	db *DB
+}
+
+func (t tx) Commit() error {
+	_, err := t.db.exec("COMMIT", nil)
+	return err
+}
+
+func (t tx) Rollback() error {
+	_, err := t.db.exec("ROLLBACK", nil)
+	return err
+}
+
+type rowsIter struct {
+	rows  *Rows
+	index int
+}
+
+func (r *rowsIter) Columns() []string {
+	return r.rows.Columns
+}
+
+func (r *rowsIter) Close() error {
+	return nil
+}
+
+func (r *rowsIter) Next(dest []driver.Value) error {
+	if r.index >= len(r.rows.Values) {
+		return io.EOF
+	}
+	copy(dest, r.rows.Values[r.index])
+	r.index++
+	return nil
+}
+
+func values(args []driver.NamedValue) []interface{} {
+	list := make([]interface{}, len(args))
+	for i, arg := range args {
+		list[i] = arg.Value
+	}
+	return list
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/fengzhu0601/gotools/cache/cargo"
+)
+
+// 检查并记录主键字段的类型(支持各种整数和字符串)
+//
+// sid字段需要是整数(按uint64存储，有符号的sid不能为负数)；有序、定长列表和id分配器的第二主键需要是整数
+func parseKeyTypes(objType reflect.Type, keyNum int, options *containerOptions) []reflect.Type {
+	keyTypes := make([]reflect.Type, 0, keyNum)
+	for i := 0; i < keyNum; i++ {
+		t := objType.Field(i).Type
+		if !isIntegerKind(t.Kind()) && t.Kind() != reflect.String {
+			panic(fmt.Sprintf("cache key type not supported, objType:%s field:%s type:%s", objType, objType.Field(i).Name, t))
+		}
+		keyTypes = append(keyTypes, t)
+	}
+	if options.global {
+		return keyTypes
+	}
+	if !isIntegerKind(keyTypes[0].Kind()) {
+		panic(fmt.Sprintf("cache sid must be integer, objType:%s type:%s", objType, keyTypes[0]))
+	}
+	if options.ordered || options.list {
+		switch keyTypes[1].Kind() {
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		default:
+			panic(fmt.Sprintf("cache ordered/list cargo needs uint32 second key, objType:%s type:%s", objType, keyTypes[1]))
+		}
+	}
+	if options.sequenceBlock > 0 || len(options.counterFields) > 0 {
+		for _, t := range keyTypes[1:] {
+			if !isIntegerKind(t.Kind()) {
+				panic(fmt.Sprintf("cache sequence/counter needs integer keys, objType:%s type:%s", objType, t))
+			}
+		}
+	}
+	return keyTypes
+}
+
+func isIntegerKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// 整数字段的sid(有符号的sid为负数时返回false)
+func sidValue(v reflect.Value) (uint64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Int() < 0 {
+			return 0, false
+		}
+		return uint64(v.Int()), true
+	default:
+		return v.Uint(), true
+	}
+}
+
+// 检查并返回obj的sid(所有传入obj的写入、删除路径都需要先检查)
+func (c *Container) checkSid(obj interface{}) (uint64, error) {
+	sid, ok := c.sidOf(obj)
+	if !ok {
+		return 0, fmt.Errorf("cache sid out of range, objType:%s sid:%v", c.objType, reflect.ValueOf(obj).Elem().Field(0).Interface())
+	}
+	return sid, nil
+}
+
+// 主键值设置到字段(整数之间转换，字符串直接设置)
+func setKey(field reflect.Value, key interface{}) {
+	switch k := key.(type) {
+	case uint32:
+		setKey(field, uint64(k))
+	case uint64:
+		if field.CanInt() {
+			field.SetInt(int64(k))
+		} else {
+			field.SetUint(k)
+		}
+	case int64:
+		if field.CanInt() {
+			field.SetInt(k)
+		} else {
+			field.SetUint(uint64(k))
+		}
+	case string:
+		field.SetString(k)
+	}
+}
+
+// 任意类型的查询参数转换成主键值(uint32(1)和int(1)得到相同的主键值)
+func anyKeys(keys []interface{}) []interface{} {
+	list := make([]interface{}, len(keys))
+	for i, key := range keys {
+		list[i] = cargo.Key(key)
+	}
+	return list
+}
+
+// 比较两个主键值(同一个主键字段的值，整数或字符串)
+func compareKey(a interface{}, b interface{}) int {
+	switch x := a.(type) {
+	case uint64:
+		if y, ok := b.(uint64); ok {
+			return compareOrdered(x < y, x > y)
+		}
+		// 负数(int64)排在前面
+		return 1
+	case int64:
+		if y, ok := b.(int64); ok {
+			return compareOrdered(x < y, x > y)
+		}
+		return -1
+	case string:
+		y, _ := b.(string)
+		return compareOrdered(x < y, x > y)
+	}
+	return 0
+}
+
+// 主键值转换成uint32，见cargo.Uint32Key
+func uint32Key(key interface{}) (uint32, bool) {
+	return cargo.Uint32Key(key)
+}
+
+// obj第i个主键的值，见cargo.KeyOf
+func keyOfField(obj interface{}, i int) interface{} {
+	return cargo.KeyOf(reflect.ValueOf(obj).Elem().Field(i))
+}
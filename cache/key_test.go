@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/fengzhu0601/gotools/cache/internal/fakedb"
+)
+
+func TestCompareKey(t *testing.T) {
+	tests := []struct {
+		name string
+		a    interface{}
+		b    interface{}
+		want int
+	}{
+		{"uint less", uint64(1), uint64(2), -1},
+		{"uint equal", uint64(2), uint64(2), 0},
+		{"uint greater", uint64(math.MaxUint64), uint64(1), 1},
+		{"negative less", int64(-2), int64(-1), -1},
+		{"negative before uint", int64(-1), uint64(0), -1},
+		{"uint after negative", uint64(0), int64(-1), 1},
+		{"string less", "a", "b", -1},
+		{"string equal", "ab", "ab", 0},
+		{"string greater", "b", "ab", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareKey(tt.a, tt.b); got != tt.want {
+				t.Fatalf("compareKey(%v, %v) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSidValue(t *testing.T) {
+	tests := []struct {
+		name string
+		sid  interface{}
+		want uint64
+		ok   bool
+	}{
+		{"uint32", uint32(7), 7, true},
+		{"uint64 max", uint64(math.MaxUint64), math.MaxUint64, true},
+		{"int64", int64(1) << 40, 1 << 40, true},
+		{"int zero", 0, 0, true},
+		{"int negative", -1, 0, false},
+		{"int8 negative", int8(-5), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := sidValue(reflect.ValueOf(tt.sid))
+			if got != tt.want || ok != tt.ok {
+				t.Fatalf("sidValue(%v) = %d %v, want %d %v", tt.sid, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSetKey(t *testing.T) {
+	type keyObj struct {
+		I8  int8
+		I64 int64
+		U32 uint32
+		U64 uint64
+		S   string
+	}
+	tests := []struct {
+		name  string
+		field int
+		key   interface{}
+		want  interface{}
+	}{
+		{"uint32 to int8", 0, uint32(3), int8(3)},
+		{"negative to int64", 1, int64(-3), int64(-3)},
+		{"uint64 to uint32", 2, uint64(9), uint32(9)},
+		{"uint64 max", 3, uint64(math.MaxUint64), uint64(math.MaxUint64)},
+		{"string", 4, "k", "k"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &keyObj{}
+			field := reflect.ValueOf(obj).Elem().Field(tt.field)
+			setKey(field, tt.key)
+			if got := field.Interface(); got != tt.want {
+				t.Fatalf("setKey(%v) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnyKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []interface{}
+		want []interface{}
+	}{
+		{"empty", []interface{}{}, []interface{}{}},
+		{"integers", []interface{}{uint32(1), 2, int64(3)}, []interface{}{uint64(1), uint64(2), uint64(3)}},
+		{"negative", []interface{}{-1}, []interface{}{int64(-1)}},
+		{"string", []interface{}{"a"}, []interface{}{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := anyKeys(tt.keys); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("anyKeys(%v) = %v, want %v", tt.keys, got, tt.want)
+			}
+		})
+	}
+}
+
+// 字符串第二主键
+type keyItem struct {
+	Sid  uint64 `gorm:"primaryKey"`
+	Name string `gorm:"primaryKey"`
+	Num  int
+}
+
+func TestLookupKeys(t *testing.T) {
+	cache := newTestCache(t, fakedb.New())
+	cache.InitContainer(testItemType, WithPreload(true))
+	cache.InitContainer(reflect.TypeOf(keyItem{}), WithPreload(true))
+	cache.Replace(testItemType, &testItem{Sid: 1, Id: 2, Num: 2})
+	cache.Replace(reflect.TypeOf(keyItem{}), &keyItem{Sid: 1, Name: "a", Num: 3})
+	tests := []struct {
+		name    string
+		objType reflect.Type
+		keys    []interface{}
+		want    int // Num，0表示找不到
+	}{
+		{"uint32", testItemType, []interface{}{uint32(2)}, 2},
+		{"int", testItemType, []interface{}{2}, 2},
+		{"uint64", testItemType, []interface{}{uint64(2)}, 2},
+		{"missing", testItemType, []interface{}{3}, 0},
+		{"negative", testItemType, []interface{}{-2}, 0},
+		{"string key", reflect.TypeOf(keyItem{}), []interface{}{"a"}, 3},
+		{"string key missing", reflect.TypeOf(keyItem{}), []interface{}{"b"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := cache.Lookup(tt.objType, 1, tt.keys...)
+			objs := cache.LookupObjs(tt.objType, 1, tt.keys...)
+			if tt.want == 0 {
+				if obj != nil || len(objs) != 0 {
+					t.Fatalf("Lookup(%v) = %v %v, want nil", tt.keys, obj, objs)
+				}
+				return
+			}
+			if obj == nil || len(objs) != 1 || objs[0] != obj || reflect.ValueOf(obj).Elem().FieldByName("Num").Int() != int64(tt.want) {
+				t.Fatalf("Lookup(%v) = %+v %v, want Num %d", tt.keys, obj, objs, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to interface{}
+		want     []uint32
+	}{
+		{"int", 2, 4, []uint32{2, 3, 4}},
+		{"mixed types", uint8(2), uint64(3), []uint32{2, 3}},
+		{"negative from", -1, 2, []uint32{1, 2}},
+		{"to beyond uint32", 4, uint64(1) << 40, []uint32{4, 5}},
+		{"empty", 4, 2, []uint32{}},
+	}
+	for _, ordered := range []bool{false, true} {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				c := newTestContainer(t, fakedb.New(), testItemType, WithPreload(true), WithOrdered(ordered))
+				for _, id := range []uint32{5, 3, 1, 4, 2} {
+					c.Replace(&testItem{Sid: 1, Id: id})
+				}
+				ids := make([]uint32, 0)
+				for _, obj := range c.LookupRange(1, tt.from, tt.to) {
+					ids = append(ids, obj.(*testItem).Id)
+				}
+				if !reflect.DeepEqual(ids, tt.want) {
+					t.Fatalf("ordered %v LookupRange(%v, %v) = %v, want %v", ordered, tt.from, tt.to, ids, tt.want)
+				}
+			})
+		}
+	}
+}
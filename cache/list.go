@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// 向定长列表容器追加obj(自动设置第二主键为自增序号)，超出上限的旧obj会被淘汰并由updater批量删除
+func (c *Container) Append(obj interface{}) error {
+	return c.AppendReason(obj, "")
+}
+
+// 向定长列表容器追加obj，并在变更记录中写入原因
+func (c *Container) AppendReason(obj interface{}, reason string) error {
+	err := validate(obj)
+	if err != nil {
+		return err
+	}
+	sid, err := c.checkSid(obj)
+	if err != nil {
+		return err
+	}
+	cargo, lock := c.lockCargo(sid)
+	defer lock.Unlock()
+	list, ok := cargo.(ListCargoInt)
+	if !ok {
+		return fmt.Errorf("cache container is not a list, objType:%s", c.objType)
+	}
+	// 序号在sid的写入锁内分配，追加前按下一个序号占用唯一索引
+	reflect.ValueOf(obj).Elem().Field(1).SetUint(uint64(cargo.GetNextUid()))
+	err = c.reserveUnique(sid, c.keysOf(obj), nil, obj)
+	if err != nil {
+		return err
+	}
+	c.markChange(sid)
+	evicted := list.Append(obj)
+	c.notify(OP_INSERT, sid, c.keysOf(obj), nil, obj, reason)
+	for _, old := range evicted {
+		c.notify(OP_DELETE, sid, c.keysOf(old), old, nil, "evict")
+	}
+	return nil
+}
+
+// 定长列表容器中某个玩家最新的n个obj(按序号从旧到新排列)
+func (c *Container) Latest(sid uint64, n int) []interface{} {
+	list, ok := c.getCargo(sid, false).(ListCargoInt)
+	if !ok {
+		return nil
+	}
+	return list.Latest(n)
+}
@@ -0,0 +1,65 @@
+package merge
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/fengzhu0601/gotools/logger"
+)
+
+// 合服命令入口，游戏服务器注册需要合并的obj类型后调用：
+//
+//	func main() {
+//		merge.Main(func(m *merge.Merger) {
+//			m.Register(&merge.TypeRule{ObjType: reflect.TypeOf(Item{})})
+//			m.Register(&merge.TypeRule{ObjType: reflect.TypeOf(Mail{}), Shard: mailShard})
+//			m.Register(&merge.TypeRule{ObjType: reflect.TypeOf(Guild{}), Global: true, SidFields: []string{"LeaderSid"}})
+//		})
+//	}
+//
+// 参数：-config 合服配置文件(json格式的Config)，-dry-run 只生成报告，-progress 进度文件
+func Main(register func(m *Merger)) {
+	configPath := flag.String("config", "merge.json", "merge config file (json)")
+	dryRun := flag.Bool("dry-run", false, "only print the report, do not write the target database")
+	progressPath := flag.String("progress", "", "progress file, overrides the config")
+	logPath := flag.String("log", "merge.log", "log file")
+	flag.Parse()
+
+	logger.InitLogger(*logPath, true)
+	cfg := &Config{}
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		exit(err)
+	}
+	err = json.Unmarshal(data, cfg)
+	if err != nil {
+		exit(err)
+	}
+	if *dryRun {
+		cfg.DryRun = true
+	}
+	if *progressPath != "" {
+		cfg.ProgressFile = *progressPath
+	}
+
+	m, err := New(cfg)
+	if err != nil {
+		exit(err)
+	}
+	register(m)
+	report, err := m.Run(context.Background())
+	if report != nil {
+		fmt.Print(report.String())
+	}
+	if err != nil {
+		exit(err)
+	}
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, "merge error:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,447 @@
+package merge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/fengzhu0601/gotools/cache"
+	"github.com/fengzhu0601/gotools/cache/bulk"
+	"github.com/fengzhu0601/gotools/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const defaultBatchSize = 5000
+
+// 合服配置
+type Config struct {
+	Sources      [2]*cache.DBConfig // 两个源库(第一个为主服)
+	Target       *cache.DBConfig    // 目标库
+	BatchSize    int                // 每批读取和写入的行数(默认5000)
+	ProgressFile string             // 进度文件(为空时不支持中断后继续)
+	DryRun       bool               // 只生成报告，不写入目标库
+}
+
+// 合服工具：读取两个源库中注册的所有obj类型，改写冲突的sid和相关字段后写入目标库
+type Merger struct {
+	cfg      *Config
+	rules    []*TypeRule
+	src      [2]*gorm.DB
+	dst      *gorm.DB
+	progress *progress
+}
+
+func New(cfg *Config) (*Merger, error) {
+	m := &Merger{cfg: cfg}
+	if m.cfg.BatchSize <= 0 {
+		m.cfg.BatchSize = defaultBatchSize
+	}
+	for i, dbCfg := range cfg.Sources {
+		d, err := cache.OpenDB(dbCfg)
+		if err != nil {
+			return nil, err
+		}
+		m.src[i] = d
+	}
+	d, err := cache.OpenDB(cfg.Target)
+	if err != nil {
+		return nil, err
+	}
+	m.dst = d
+	return m, nil
+}
+
+// 注册需要合并的obj类型(按注册顺序合并)
+func (m *Merger) Register(rule *TypeRule) {
+	if rule.Conflict == nil {
+		rule.Conflict = KeepFirst
+	}
+	m.rules = append(m.rules, rule)
+}
+
+// 执行合并，返回合并报告
+func (m *Merger) Run(ctx context.Context) (*Report, error) {
+	var err error
+	if m.cfg.DryRun {
+		m.progress, err = loadProgress("")
+	} else {
+		m.progress, err = loadProgress(m.cfg.ProgressFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.progress.SidMap == nil {
+		err = m.buildSidMap(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report := &Report{DryRun: m.cfg.DryRun, SidMap: m.progress.SidMap}
+	for _, rule := range m.rules {
+		sch, err := m.parseSchema(rule.ObjType)
+		if err != nil {
+			return report, err
+		}
+		tr := &TableReport{Table: sch.Table}
+		report.Tables = append(report.Tables, tr)
+		if !m.cfg.DryRun {
+			for _, table := range rule.tables(sch.Table) {
+				err = m.dst.Table(table).AutoMigrate(reflect.New(rule.ObjType).Interface())
+				if err != nil {
+					return report, err
+				}
+			}
+		}
+		if rule.Global {
+			err = m.mergeGlobal(ctx, rule, sch, tr)
+		} else {
+			err = m.mergePlayer(ctx, rule, sch, tr)
+		}
+		if err != nil {
+			return report, err
+		}
+		logger.Info("merge table done", tr.String())
+	}
+	return report, nil
+}
+
+func (m *Merger) parseSchema(objType reflect.Type) (*schema.Schema, error) {
+	stmt := &gorm.Statement{DB: m.dst}
+	err := stmt.Parse(reflect.New(objType).Interface())
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Schema, nil
+}
+
+// 找出第二个源库中和第一个源库冲突的sid，从两个库最大的sid之后依次分配新sid
+func (m *Merger) buildSidMap(ctx context.Context) error {
+	var sids [2]map[uint64]bool
+	var maxSid uint64 = 0
+	for source := range m.src {
+		sids[source] = make(map[uint64]bool)
+		for _, rule := range m.rules {
+			if rule.Global {
+				continue
+			}
+			sch, err := m.parseSchema(rule.ObjType)
+			if err != nil {
+				return err
+			}
+			for _, table := range rule.tables(sch.Table) {
+				var list []uint64
+				err = m.src[source].WithContext(ctx).Table(table).Distinct("sid").Pluck("sid", &list).Error
+				if err != nil {
+					return err
+				}
+				for _, sid := range list {
+					sids[source][sid] = true
+					if sid > maxSid {
+						maxSid = sid
+					}
+				}
+			}
+		}
+	}
+
+	collide := make([]uint64, 0)
+	for sid := range sids[SOURCE_SECOND] {
+		if sids[SOURCE_FIRST][sid] {
+			collide = append(collide, sid)
+		}
+	}
+	sort.Slice(collide, func(i, j int) bool {
+		return collide[i] < collide[j]
+	})
+	m.progress.SidMap = make(map[uint64]uint64, len(collide))
+	for i, sid := range collide {
+		m.progress.SidMap[sid] = maxSid + uint64(i) + 1
+	}
+	logger.Info("merge sid map built", len(sids[SOURCE_FIRST]), len(sids[SOURCE_SECOND]), len(collide))
+	return m.saveProgress()
+}
+
+// 把源库的sid映射成目标库的sid
+func (m *Merger) mapSidFunc(source int) func(uint64) uint64 {
+	return func(sid uint64) uint64 {
+		if source == SOURCE_SECOND {
+			if newSid, exit := m.progress.SidMap[sid]; exit {
+				return newSid
+			}
+		}
+		return sid
+	}
+}
+
+// 按规则改写obj
+func (m *Merger) rewrite(rule *TypeRule, source int, obj interface{}) {
+	mapSid := m.mapSidFunc(source)
+	v := reflect.ValueOf(obj).Elem()
+	if !rule.Global {
+		setSid(v.Field(0), mapSid(sidOf(v.Field(0))))
+	}
+	for _, name := range rule.SidFields {
+		field := v.FieldByName(name)
+		setSid(field, mapSid(sidOf(field)))
+	}
+	if rule.Rewrite != nil {
+		rule.Rewrite(source, obj, mapSid)
+	}
+}
+
+// 合并玩家数据表：sid改写后不会冲突，分批读取后直接写入(分表时逐个读取源库分表，按新sid写入目标库分表)
+func (m *Merger) mergePlayer(ctx context.Context, rule *TypeRule, sch *schema.Schema, tr *TableReport) error {
+	for source := range m.src {
+		skipped := true
+		for _, table := range rule.tables(sch.Table) {
+			key := progressKey(table, source)
+			done := m.progress.Done[key]
+			if done < 0 {
+				continue
+			}
+			skipped = false
+			after, err := m.lastKey(sch, key)
+			if err != nil {
+				return err
+			}
+			for {
+				objs, err := m.readPage(ctx, source, rule.ObjType, sch, table, after)
+				if err != nil {
+					return err
+				}
+				if len(objs) == 0 {
+					break
+				}
+				// 改写前记录最后一行在源库中的主键
+				after = primaryValues(sch, objs[len(objs)-1])
+				groups := make(map[string][]interface{})
+				for _, obj := range objs {
+					m.rewrite(rule, source, obj)
+					target := rule.tableOf(sch.Table, sidOf(reflect.ValueOf(obj).Elem().Field(0)))
+					groups[target] = append(groups[target], obj)
+				}
+				for target, list := range groups {
+					err = m.write(ctx, target, list)
+					if err != nil {
+						return err
+					}
+				}
+				done += len(objs)
+				tr.Read[source] += len(objs)
+				tr.Written += len(objs)
+				m.progress.Done[key] = done
+				err = m.saveLastKey(key, after)
+				if err != nil {
+					return err
+				}
+				err = m.saveProgress()
+				if err != nil {
+					return err
+				}
+			}
+			m.progress.Done[key] = -1
+			delete(m.progress.Last, key)
+			err = m.saveProgress()
+			if err != nil {
+				return err
+			}
+		}
+		tr.Skipped[source] = skipped
+	}
+	return nil
+}
+
+// 合并全服共享表：读取两个库的全部数据(分表时读取所有分表)，主键冲突时按策略选择保留的数据，写入目标库对应的分表
+func (m *Merger) mergeGlobal(ctx context.Context, rule *TypeRule, sch *schema.Schema, tr *TableReport) error {
+	key := progressKey(sch.Table, SOURCE_FIRST)
+	if m.progress.Done[key] < 0 {
+		tr.Skipped = [2]bool{true, true}
+		return nil
+	}
+	merged := make(map[string]interface{})
+	order := make([]string, 0)
+	for source := range m.src {
+		for _, table := range rule.tables(sch.Table) {
+			err := m.readGlobal(ctx, rule, sch, source, table, merged, &order, tr)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	groups := make(map[string][]interface{})
+	tables := make([]string, 0)
+	for _, pk := range order {
+		obj := merged[pk]
+		target := sch.Table
+		if rule.Shard != nil {
+			target = rule.tableOf(sch.Table, sidOf(reflect.ValueOf(obj).Elem().Field(0)))
+		}
+		if _, exit := groups[target]; !exit {
+			tables = append(tables, target)
+		}
+		groups[target] = append(groups[target], obj)
+	}
+	for _, table := range tables {
+		objs := groups[table]
+		for i := 0; i < len(objs); i += m.cfg.BatchSize {
+			end := i + m.cfg.BatchSize
+			if end > len(objs) {
+				end = len(objs)
+			}
+			err := m.write(ctx, table, objs[i:end])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	tr.Written = len(order)
+	for source := range m.src {
+		m.progress.Done[progressKey(sch.Table, source)] = -1
+	}
+	return m.saveProgress()
+}
+
+// 读取全服共享表的一个源库分表，合并到merged中
+func (m *Merger) readGlobal(ctx context.Context, rule *TypeRule, sch *schema.Schema, source int, table string, merged map[string]interface{}, order *[]string, tr *TableReport) error {
+	var after []interface{}
+	for {
+		objs, err := m.readPage(ctx, source, rule.ObjType, sch, table, after)
+		if err != nil {
+			return err
+		}
+		if len(objs) == 0 {
+			return nil
+		}
+		after = primaryValues(sch, objs[len(objs)-1])
+		for _, obj := range objs {
+			m.rewrite(rule, source, obj)
+			pk := primaryKey(sch, obj)
+			if exist, exit := merged[pk]; exit {
+				merged[pk] = rule.Conflict(exist, obj)
+				tr.Conflicts++
+				continue
+			}
+			merged[pk] = obj
+			*order = append(*order, pk)
+		}
+		tr.Read[source] += len(objs)
+	}
+}
+
+// 按主键顺序读取主键大于after的一页数据(after为nil时从头读取)
+//
+// 按主键分页而不是按偏移量，源库读取期间有增删时不会跳过或重复读取已有的行
+func (m *Merger) readPage(ctx context.Context, source int, objType reflect.Type, sch *schema.Schema, table string, after []interface{}) ([]interface{}, error) {
+	slice := reflect.New(reflect.SliceOf(reflect.PtrTo(objType)))
+	columns := strings.Join(sch.PrimaryFieldDBNames, ",")
+	tx := m.src[source].WithContext(ctx).Table(table)
+	if after != nil {
+		marks := strings.TrimSuffix(strings.Repeat("?,", len(after)), ",")
+		tx = tx.Where("("+columns+") > ("+marks+")", after...)
+	}
+	err := tx.Order(columns).Limit(m.cfg.BatchSize).Find(slice.Interface()).Error
+	if err != nil {
+		return nil, err
+	}
+	datas := slice.Elem()
+	objs := make([]interface{}, datas.Len())
+	for i := range objs {
+		objs[i] = datas.Index(i).Interface()
+	}
+	return objs, nil
+}
+
+// 写入目标库(dry run时不写入)
+func (m *Merger) write(ctx context.Context, table string, objs []interface{}) error {
+	if m.cfg.DryRun || len(objs) == 0 {
+		return nil
+	}
+	return bulk.BulkUpdateWithTableName(m.dst.WithContext(ctx), table, objs)
+}
+
+// 进度文件中记录的最后一行主键，按主键字段类型解析
+func (m *Merger) lastKey(sch *schema.Schema, key string) ([]interface{}, error) {
+	raws := m.progress.Last[key]
+	if raws == nil {
+		return nil, nil
+	}
+	if len(raws) != len(sch.PrimaryFields) {
+		return nil, fmt.Errorf("merge progress last key error, key:%s", key)
+	}
+	after := make([]interface{}, len(raws))
+	for i, field := range sch.PrimaryFields {
+		v := reflect.New(field.FieldType)
+		err := json.Unmarshal(raws[i], v.Interface())
+		if err != nil {
+			return nil, err
+		}
+		after[i] = v.Elem().Interface()
+	}
+	return after, nil
+}
+
+func (m *Merger) saveLastKey(key string, after []interface{}) error {
+	raws := make([]json.RawMessage, len(after))
+	for i, value := range after {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		raws[i] = data
+	}
+	m.progress.Last[key] = raws
+	return nil
+}
+
+func (m *Merger) saveProgress() error {
+	if m.cfg.DryRun {
+		return nil
+	}
+	return m.progress.save()
+}
+
+// 整数字段的sid
+func sidOf(field reflect.Value) uint64 {
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(field.Int())
+	default:
+		return field.Uint()
+	}
+}
+
+// 按字段类型设置sid(有符号的sid字段用SetInt)
+func setSid(field reflect.Value, sid uint64) {
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		field.SetInt(int64(sid))
+	default:
+		field.SetUint(sid)
+	}
+}
+
+// obj的主键值
+func primaryValues(sch *schema.Schema, obj interface{}) []interface{} {
+	v := reflect.ValueOf(obj).Elem()
+	values := make([]interface{}, len(sch.PrimaryFields))
+	for i, field := range sch.PrimaryFields {
+		values[i] = v.FieldByName(field.Name).Interface()
+	}
+	return values
+}
+
+// obj的主键字符串
+func primaryKey(sch *schema.Schema, obj interface{}) string {
+	v := reflect.ValueOf(obj).Elem()
+	keys := make([]string, len(sch.PrimaryFields))
+	for i, field := range sch.PrimaryFields {
+		keys[i] = fmt.Sprint(v.FieldByName(field.Name).Interface())
+	}
+	return strings.Join(keys, ",")
+}
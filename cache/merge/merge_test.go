@@ -0,0 +1,263 @@
+package merge
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fengzhu0601/gotools/cache"
+	"github.com/fengzhu0601/gotools/cache/internal/fakedb"
+	"github.com/fengzhu0601/gotools/logger"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "merge_test")
+	if err != nil {
+		panic(err)
+	}
+	logger.InitLogger(filepath.Join(dir, "merge.log"), false)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+type mergeItem struct {
+	Sid uint64 `gorm:"primaryKey"`
+	Id  uint32 `gorm:"primaryKey"`
+	Num int
+}
+
+var mergeItemType = reflect.TypeOf(mergeItem{})
+
+var limitRe = regexp.MustCompile(`LIMIT (\d+)`)
+
+// 假的源库表：按(sid, id)主键排序，支持主键分页查询和DISTINCT sid
+type fakeTable struct {
+	lock   sync.Mutex
+	tables map[string][][2]uint64 // 表名 -> (sid, id)
+	onPage func(table string)     // 每读取一页后调用(可以模拟读取期间的修改)
+}
+
+func (ft *fakeTable) query(query string, args []interface{}) (*fakedb.Rows, error) {
+	ft.lock.Lock()
+	var table string
+	var rows [][2]uint64
+	for name, list := range ft.tables {
+		if strings.Contains(query, "FROM `"+name+"`") {
+			table, rows = name, append([][2]uint64(nil), list...)
+		}
+	}
+	ft.lock.Unlock()
+	if table == "" {
+		return nil, nil
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i][0] < rows[j][0] || rows[i][0] == rows[j][0] && rows[i][1] < rows[j][1]
+	})
+	if strings.Contains(query, "DISTINCT") {
+		result := &fakedb.Rows{Columns: []string{"sid"}}
+		seen := make(map[uint64]bool)
+		for _, row := range rows {
+			if !seen[row[0]] {
+				seen[row[0]] = true
+				result.Values = append(result.Values, []driver.Value{int64(row[0])})
+			}
+		}
+		return result, nil
+	}
+	if strings.Contains(query, "OFFSET") {
+		panic("merge paging by offset: " + query)
+	}
+	result := &fakedb.Rows{Columns: []string{"sid", "id", "num"}}
+	limit, _ := strconv.Atoi(limitRe.FindStringSubmatch(query)[1])
+	for _, row := range rows {
+		if len(args) == 2 {
+			sid, id := args[0].(uint64), uint64(args[1].(uint32))
+			if row[0] < sid || row[0] == sid && row[1] <= id {
+				continue
+			}
+		}
+		if len(result.Values) == limit {
+			break
+		}
+		result.Values = append(result.Values, []driver.Value{int64(row[0]), int64(row[1]), int64(row[0]*100 + row[1])})
+	}
+	if ft.onPage != nil {
+		ft.onPage(table)
+	}
+	return result, nil
+}
+
+// 写入目标库的行(表名 -> (sid, id, num))
+func writtenRows(db *fakedb.DB) map[string][][3]uint64 {
+	written := make(map[string][][3]uint64)
+	for _, stmt := range db.ExecsOf("REPLACE INTO") {
+		table := strings.Split(stmt.Query, "`")[1]
+		for i := 0; i+2 < len(stmt.Args); i += 3 {
+			written[table] = append(written[table], [3]uint64{
+				stmt.Args[i].(uint64), uint64(stmt.Args[i+1].(uint32)), uint64(stmt.Args[i+2].(int)),
+			})
+		}
+	}
+	for _, rows := range written {
+		sort.Slice(rows, func(i, j int) bool {
+			return rows[i][0] < rows[j][0] || rows[i][0] == rows[j][0] && rows[i][1] < rows[j][1]
+		})
+	}
+	return written
+}
+
+func newTestMerger(batch int, sources [2]*fakeTable) (*Merger, *fakedb.DB) {
+	m := &Merger{cfg: &Config{BatchSize: batch}, progress: &progress{Done: make(map[string]int), Last: make(map[string][]json.RawMessage)}}
+	for i, ft := range sources {
+		db := fakedb.New()
+		db.QueryFn = ft.query
+		m.src[i] = db.Gorm()
+	}
+	dst := fakedb.New()
+	m.dst = dst.Gorm()
+	return m, dst
+}
+
+func TestBuildSidMap(t *testing.T) {
+	shard := &cache.ShardConfig{Num: 2}
+	first := &fakeTable{tables: map[string][][2]uint64{
+		"merge_item_00": {{2, 1}, {4, 1}},
+		"merge_item_01": {{1, 1}, {3, 1}},
+	}}
+	second := &fakeTable{tables: map[string][][2]uint64{
+		"merge_item_00": {{2, 1}, {2, 2}},
+		"merge_item_01": {{3, 1}, {5, 1}},
+	}}
+	m, _ := newTestMerger(10, [2]*fakeTable{first, second})
+	m.Register(&TypeRule{ObjType: mergeItemType, Shard: shard})
+	m.Register(&TypeRule{ObjType: mergeItemType, Global: true})
+	if err := m.buildSidMap(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint64]uint64{2: 6, 3: 7}
+	if !reflect.DeepEqual(m.progress.SidMap, want) {
+		t.Fatalf("SidMap = %v, want %v", m.progress.SidMap, want)
+	}
+	mapSid := m.mapSidFunc(SOURCE_SECOND)
+	if mapSid(2) != 6 || mapSid(5) != 5 || m.mapSidFunc(SOURCE_FIRST)(2) != 2 {
+		t.Fatalf("mapSid error")
+	}
+}
+
+func TestConflictStrategy(t *testing.T) {
+	a := &mergeItem{Sid: 1, Id: 1, Num: 5}
+	b := &mergeItem{Sid: 1, Id: 1, Num: 9}
+	tests := []struct {
+		name     string
+		strategy ConflictStrategy
+		a, b     interface{}
+		want     interface{}
+	}{
+		{"keep first", KeepFirst, a, b, a},
+		{"keep second", KeepSecond, a, b, b},
+		{"keep max second", KeepMax("Num"), a, b, b},
+		{"keep max first", KeepMax("Num"), b, a, b},
+		{"keep max tie", KeepMax("Num"), a, &mergeItem{Sid: 1, Id: 1, Num: 5}, a},
+		{"keep max uint", KeepMax("Id"), a, &mergeItem{Sid: 1, Id: 2}, &mergeItem{Sid: 1, Id: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.strategy(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("strategy() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 按主键分页：读取期间删除已读取的行不会跳过后面的行，每行只写入一次
+func TestMergePlayerKeyset(t *testing.T) {
+	first := &fakeTable{tables: map[string][][2]uint64{"merge_item": {{1, 1}, {1, 2}, {1, 3}, {2, 1}, {2, 2}}}}
+	second := &fakeTable{tables: map[string][][2]uint64{"merge_item": {{1, 1}, {3, 1}}}}
+	deleted := false
+	first.onPage = func(table string) {
+		if !deleted {
+			deleted = true
+			first.lock.Lock()
+			first.tables[table] = first.tables[table][1:]
+			first.lock.Unlock()
+		}
+	}
+	m, dst := newTestMerger(2, [2]*fakeTable{first, second})
+	m.progress.SidMap = map[uint64]uint64{1: 4}
+	rule := &TypeRule{ObjType: mergeItemType}
+	sch, err := m.parseSchema(mergeItemType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &TableReport{Table: sch.Table}
+	if err := m.mergePlayer(context.Background(), rule, sch, tr); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][][3]uint64{"merge_item": {
+		{1, 1, 101}, {1, 2, 102}, {1, 3, 103}, {2, 1, 201}, {2, 2, 202}, {3, 1, 301}, {4, 1, 101},
+	}}
+	if got := writtenRows(dst); !reflect.DeepEqual(got, want) {
+		t.Fatalf("written = %v, want %v", got, want)
+	}
+	if tr.Read != [2]int{5, 2} || m.progress.Done["merge_item#0"] != -1 || len(m.progress.Last) != 0 {
+		t.Fatalf("Read = %v Done = %v Last = %v", tr.Read, m.progress.Done, m.progress.Last)
+	}
+}
+
+// 从进度文件记录的最后一行主键继续读取
+func TestMergePlayerResume(t *testing.T) {
+	first := &fakeTable{tables: map[string][][2]uint64{"merge_item": {{1, 1}, {1, 2}, {2, 1}}}}
+	second := &fakeTable{tables: map[string][][2]uint64{}}
+	m, dst := newTestMerger(10, [2]*fakeTable{first, second})
+	m.progress.SidMap = map[uint64]uint64{}
+	m.progress.Done["merge_item#0"] = 2
+	m.saveLastKey("merge_item#0", []interface{}{uint64(1), uint32(2)})
+	sch, _ := m.parseSchema(mergeItemType)
+	if err := m.mergePlayer(context.Background(), &TypeRule{ObjType: mergeItemType}, sch, &TableReport{}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][][3]uint64{"merge_item": {{2, 1, 201}}}
+	if got := writtenRows(dst); !reflect.DeepEqual(got, want) {
+		t.Fatalf("written = %v, want %v", got, want)
+	}
+}
+
+// 分表的全服共享表：读取所有分表，冲突按策略处理，按sid写入目标库对应的分表
+func TestMergeGlobalShard(t *testing.T) {
+	first := &fakeTable{tables: map[string][][2]uint64{
+		"merge_item_00": {{2, 1}},
+		"merge_item_01": {{1, 1}, {3, 1}},
+	}}
+	second := &fakeTable{tables: map[string][][2]uint64{
+		"merge_item_00": {{2, 1}, {4, 1}},
+		"merge_item_01": {{5, 1}},
+	}}
+	m, dst := newTestMerger(1, [2]*fakeTable{first, second})
+	m.progress.SidMap = map[uint64]uint64{2: 100}
+	rule := &TypeRule{ObjType: mergeItemType, Global: true, Shard: &cache.ShardConfig{Num: 2}, Conflict: KeepSecond}
+	sch, _ := m.parseSchema(mergeItemType)
+	tr := &TableReport{Table: sch.Table}
+	if err := m.mergeGlobal(context.Background(), rule, sch, tr); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][][3]uint64{
+		"merge_item_00": {{2, 1, 201}, {4, 1, 401}},
+		"merge_item_01": {{1, 1, 101}, {3, 1, 301}, {5, 1, 501}},
+	}
+	if got := writtenRows(dst); !reflect.DeepEqual(got, want) {
+		t.Fatalf("written = %v, want %v", got, want)
+	}
+	if tr.Read != [2]int{3, 3} || tr.Conflicts != 1 || tr.Written != 5 {
+		t.Fatalf("report = %+v", tr)
+	}
+}
@@ -0,0 +1,65 @@
+package merge
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// 合并进度(写入进度文件，中断后可以继续)
+type progress struct {
+	path   string
+	SidMap map[uint64]uint64            // 第二个源库中冲突的sid -> 新sid
+	Done   map[string]int               // 表#源库 -> 已写入的行数(-1表示已完成)
+	Last   map[string][]json.RawMessage // 表#源库 -> 已写入的最后一行主键(从下一行继续读取)
+}
+
+func loadProgress(path string) (*progress, error) {
+	p := &progress{
+		path: path,
+		Done: make(map[string]int),
+		Last: make(map[string][]json.RawMessage),
+	}
+	if path == "" {
+		return p, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, p)
+	if err != nil {
+		return nil, err
+	}
+	if p.Done == nil {
+		p.Done = make(map[string]int)
+	}
+	if p.Last == nil {
+		p.Last = make(map[string][]json.RawMessage)
+	}
+	return p, nil
+}
+
+// 保存进度(先写临时文件再改名，防止中断时写坏进度文件)
+func (p *progress) save() error {
+	if p.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := p.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
+
+func progressKey(table string, source int) string {
+	return fmt.Sprintf("%s#%d", table, source)
+}
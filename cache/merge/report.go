@@ -0,0 +1,37 @@
+package merge
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 单个表的合并结果
+type TableReport struct {
+	Table     string
+	Read      [2]int  // 从两个源库读取的行数
+	Written   int     // 写入目标库的行数(dry run时为将要写入的行数)
+	Conflicts int     // 全服共享表的主键冲突数量
+	Skipped   [2]bool // 之前已完成而跳过的源库
+}
+
+func (t *TableReport) String() string {
+	return fmt.Sprintf("%s read:%d+%d written:%d conflicts:%d skipped:%v",
+		t.Table, t.Read[SOURCE_FIRST], t.Read[SOURCE_SECOND], t.Written, t.Conflicts, t.Skipped)
+}
+
+// 合并报告
+type Report struct {
+	DryRun bool
+	SidMap map[uint64]uint64 // 第二个源库中冲突的sid -> 新sid
+	Tables []*TableReport
+}
+
+func (r *Report) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "merge report dryRun:%v remappedSids:%d\n", r.DryRun, len(r.SidMap))
+	for _, table := range r.Tables {
+		sb.WriteString(table.String())
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
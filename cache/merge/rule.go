@@ -0,0 +1,84 @@
+package merge
+
+import (
+	"reflect"
+
+	"github.com/fengzhu0601/gotools/cache"
+)
+
+// 源数据库序号
+const (
+	SOURCE_FIRST  = 0 // 第一个源库(主服)
+	SOURCE_SECOND = 1 // 第二个源库(被合并的服)
+)
+
+// 主键冲突时选择保留的数据(a来自第一个源库，b来自第二个源库)
+type ConflictStrategy func(a interface{}, b interface{}) interface{}
+
+// 保留第一个源库的数据
+func KeepFirst(a interface{}, b interface{}) interface{} {
+	return a
+}
+
+// 保留第二个源库的数据
+func KeepSecond(a interface{}, b interface{}) interface{} {
+	return b
+}
+
+// 保留指定数值字段较大的数据(如战力、更新时间)
+func KeepMax(field string) ConflictStrategy {
+	return func(a interface{}, b interface{}) interface{} {
+		va := reflect.ValueOf(a).Elem().FieldByName(field)
+		vb := reflect.ValueOf(b).Elem().FieldByName(field)
+		if less(va, vb) {
+			return b
+		}
+		return a
+	}
+}
+
+func less(a reflect.Value, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	case reflect.String:
+		return a.String() < b.String()
+	default:
+		return false
+	}
+}
+
+// 一个obj类型的合并规则
+type TypeRule struct {
+	ObjType   reflect.Type
+	Global    bool               // 全服共享表(preload/全局容器)：不按sid改写，主键冲突时按Conflict处理(分表时按第一个主键分表)
+	SidFields []string           // 其他引用sid的字段(如好友sid、公会会长sid)，按sid映射改写
+	Conflict  ConflictStrategy   // 全服共享表主键冲突时的处理(默认KeepFirst)
+	Shard     *cache.ShardConfig // 分表配置(和容器的WithShard一致)，源库按所有分表读取，改写sid后写入目标库对应的分表
+	// 自定义改写(如重新分配冲突的公会id)，在sid改写之后调用，mapSid把源库的sid映射成目标库的sid
+	Rewrite func(source int, obj interface{}, mapSid func(uint64) uint64)
+}
+
+// 规则对应的所有物理表(分表时为所有分表)
+func (r *TypeRule) tables(tableName string) []string {
+	if r.Shard == nil {
+		return []string{tableName}
+	}
+	tables := make([]string, r.Shard.Num)
+	for i := range tables {
+		tables[i] = r.Shard.Table(tableName, i)
+	}
+	return tables
+}
+
+// sid所在的物理表
+func (r *TypeRule) tableOf(tableName string, sid uint64) string {
+	if r.Shard == nil {
+		return tableName
+	}
+	return r.Shard.Table(tableName, r.Shard.Index(sid))
+}
@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const defaultLoadTimeout = 3 * time.Second
+
+// 内存回收策略
+type EvictPolicy byte
+
+const (
+	EVICT_GC    EvictPolicy = 0 // SetGC后延迟GCSeconds秒回收(默认)
+	EVICT_NEVER EvictPolicy = 1 // 从不回收
+	EVICT_IDLE  EvictPolicy = 2 // 超过GCSeconds秒没有访问就回收(不需要SetGC)
+)
+
+// 容器配置，未设置的项使用DBConfig中的全局配置
+type containerOptions struct {
+	preload         bool            // 是否预加载
+	updateSize      int             // 每次批量更新的数据量
+	updateGap       time.Duration   // 批量更新间隔(数据最大延迟)
+	gcSeconds       int64           // 内存回收延迟(秒)
+	eviction        EvictPolicy     // 内存回收策略
+	loadTimeout     time.Duration   // 从数据库加载的超时时间
+	tableName       string          // 表名
+	db              *gorm.DB        // 数据库连接
+	shard           *ShardConfig    // 分表配置
+	writeThrough    bool            // 写穿透(Replace/Delete同步写入数据库)
+	highWater       *HighWater      // 积压警戒线
+	poisonThreshold int             // 连续失败n次后二分隔离问题数据(<=0不隔离)
+	deadLetter      DeadLetterStore // 死信存储
+	audit           *AuditConfig    // 变更记录配置
+	verify          *VerifyConfig   // 抽样校验配置
+	pollInterval    time.Duration   // 拉取外部修改的间隔(0表示不拉取)
+	pollColumn      string          // 拉取外部修改使用的更新时间列
+	ordered         bool            // 使用有序载体
+	list            bool            // 使用定长列表载体
+	listCap         int             // 定长列表保留的最大条数
+	counterFields   []string        // 按增量写入的计数字段
+	sequenceBlock   uint32          // 持久化id分配器每次预留的id数量(0表示不使用)
+	global          bool            // 全局容器(按自身主键存储，没有sid)
+	flushPolicy     FlushPolicy     // 写库策略(默认DefaultFlushPolicy)
+
+	indexes    []indexDef        // 二级索引
+	rankings   []RankConfig      // 排行榜
+	aggregates []AggregateConfig // 聚合
+}
+
+// 容器配置项
+type ContainerOption func(*containerOptions)
+
+// 以全局配置作为默认值
+func defaultOptions(cache *Cache) *containerOptions {
+	dbCfg := cache.dbConfig
+	return &containerOptions{
+		updateSize:      dbCfg.UpdateSize,
+		updateGap:       time.Duration(dbCfg.UpdateGap) * time.Second,
+		gcSeconds:       dbCfg.GCSeconds,
+		eviction:        EVICT_GC,
+		loadTimeout:     defaultLoadTimeout,
+		poisonThreshold: defaultPoisonThreshold,
+	}
+}
+
+// 预加载整张表
+func WithPreload(preload bool) ContainerOption {
+	return func(o *containerOptions) {
+		o.preload = preload
+	}
+}
+
+// 每次批量更新的数据量
+func WithUpdateSize(size int) ContainerOption {
+	return func(o *containerOptions) {
+		o.updateSize = size
+	}
+}
+
+// 数据变更后最多延迟多久写入数据库
+func WithMaxStaleness(gap time.Duration) ContainerOption {
+	return func(o *containerOptions) {
+		o.updateGap = gap
+	}
+}
+
+// 写库策略：决定更新协程轮到容器时是否写入(默认DefaultFlushPolicy)
+func WithFlushPolicy(policy FlushPolicy) ContainerOption {
+	return func(o *containerOptions) {
+		o.flushPolicy = policy
+	}
+}
+
+// 内存回收延迟(秒)
+func WithGCSeconds(seconds int64) ContainerOption {
+	return func(o *containerOptions) {
+		o.gcSeconds = seconds
+	}
+}
+
+// 内存回收策略
+func WithEviction(policy EvictPolicy) ContainerOption {
+	return func(o *containerOptions) {
+		o.eviction = policy
+	}
+}
+
+// 从数据库加载的超时时间，超时后抛出panic
+func WithLoadTimeout(timeout time.Duration) ContainerOption {
+	return func(o *containerOptions) {
+		o.loadTimeout = timeout
+	}
+}
+
+// 指定表名(默认按类型名解析)
+func WithTableName(name string) ContainerOption {
+	return func(o *containerOptions) {
+		o.tableName = name
+	}
+}
+
+// 指定数据库连接(默认使用cache的连接，指定后不再使用从库)
+func WithDB(db *gorm.DB) ContainerOption {
+	return func(o *containerOptions) {
+		o.db = db
+	}
+}
+
+// 按sid分表
+func WithShard(shard *ShardConfig) ContainerOption {
+	return func(o *containerOptions) {
+		if shard == nil || shard.Num <= 0 {
+			panic("shard num error")
+		}
+		o.shard = shard
+	}
+}
+
+// 写穿透模式：Replace/Delete同步写入数据库成功后才更新内存(用于支付发货等关键数据)
+func WithWriteThrough(writeThrough bool) ContainerOption {
+	return func(o *containerOptions) {
+		o.writeThrough = writeThrough
+	}
+}
+
+// 待写入数据的警戒线，超过后告警并把容器标记为不健康
+func WithHighWater(hw HighWater) ContainerOption {
+	return func(o *containerOptions) {
+		o.highWater = &hw
+	}
+}
+
+// 批量写入连续失败n次后，二分查找导致失败的obj并隔离(<=0表示不隔离，一直重试)
+func WithPoisonThreshold(threshold int) ContainerOption {
+	return func(o *containerOptions) {
+		o.poisonThreshold = threshold
+	}
+}
+
+// 被隔离obj的死信存储(不设置时只输出日志，问题数据保持变更状态，修改后或退避一段时间后重新写入)
+func WithDeadLetter(store DeadLetterStore) ContainerOption {
+	return func(o *containerOptions) {
+		o.deadLetter = store
+	}
+}
+
+// 使用有序载体(只支持双主键)：LookupObjs按第二主键排序返回，LookupRange按区间二分查找
+func WithOrdered(ordered bool) ContainerOption {
+	return func(o *containerOptions) {
+		o.ordered = ordered
+	}
+}
+
+// 使用定长列表载体(只支持双主键，第二主键为自增序号)：通过Append追加，只保留最新的cap条(<=0表示不限制)
+func WithList(cap int) ContainerOption {
+	return func(o *containerOptions) {
+		o.list = true
+		o.listCap = cap
+	}
+}
+
+// 记录每次Replace/Delete的变更(时间、sid、主键、前后的值和原因)，由updater批量写入
+func WithAudit(cfg AuditConfig) ContainerOption {
+	return func(o *containerOptions) {
+		o.audit = &cfg
+	}
+}
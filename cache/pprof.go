@@ -24,6 +24,15 @@ type ContainerProf struct {
 	DBDeleteNum   uint64 // db删除的Obj总数
 	CellReads     int64  // cell读次数
 	CellWrites    int64  // cell写次数
+	Healthy       bool   // 是否没有超过积压警戒线
+	DirtyAge      int64  // 最早一次未写入变更的时长(秒)
+	DeadLetterNum uint64 // 被隔离的问题数据总数
+	EventDropNum  uint64 // 订阅者丢弃的事件数量
+	VerifyNum     uint64 // 校验的sid总数
+	MismatchNum   uint64 // 校验发现的不一致obj总数
+	RepairNum     uint64 // 校验修复的obj总数
+
+	Aggregates []*AggregateProf // 聚合结果
 }
 
 func (c *Cache) PrintCache(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,213 @@
+package cache
+
+import (
+	"container/heap"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// 遍历容器中的所有obj，fn返回false时停止遍历
+//
+// fn在cargo的读锁内调用，不能在fn中修改同一个容器的数据
+func (c *Container) Range(fn func(sid uint64, obj interface{}) bool) {
+	c.cells.Range(func(k any, v any) bool {
+		sid := k.(uint64)
+		return v.(*Cell).cargo.RangeObjs(func(obj interface{}) bool {
+			return fn(sid, obj)
+		})
+	})
+}
+
+// 容器中的obj数量
+func (c *Container) countObjs() int {
+	num := 0
+	c.Range(func(sid uint64, obj interface{}) bool {
+		num++
+		return true
+	})
+	return num
+}
+
+// 容器查询：遍历容器中的obj，按条件过滤、排序和限制数量，不会先复制全部obj
+//
+//	objs := container.Query().Where(func(obj interface{}) bool {
+//		return obj.(*Item).CfgId == 1001
+//	}).OrderBy("Num", true).Limit(10).All()
+type Query struct {
+	container *Container
+	filters   []func(obj interface{}) bool
+	field     *reflect.StructField // 排序字段(nil表示不排序)
+	desc      bool                 // 是否降序
+	limit     int                  // 返回数量上限(<=0表示不限制)
+}
+
+// 新建查询
+func (c *Container) Query() *Query {
+	return &Query{container: c}
+}
+
+// 过滤条件(多个条件同时满足)
+func (q *Query) Where(fn func(obj interface{}) bool) *Query {
+	q.filters = append(q.filters, fn)
+	return q
+}
+
+// 按字段排序，字段不存在时panic
+func (q *Query) OrderBy(field string, desc bool) *Query {
+	f, exit := q.container.objType.FieldByName(field)
+	if !exit {
+		panic(fmt.Sprintf("cache query field not found, objType:%s field:%s", q.container.objType, field))
+	}
+	q.field = &f
+	q.desc = desc
+	return q
+}
+
+// 返回数量上限
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+func (q *Query) match(obj interface{}) bool {
+	for _, fn := range q.filters {
+		if !fn(obj) {
+			return false
+		}
+	}
+	return true
+}
+
+// 满足条件的obj数量(不受Limit限制)
+func (q *Query) Count() int {
+	num := 0
+	q.container.Range(func(sid uint64, obj interface{}) bool {
+		if q.match(obj) {
+			num++
+		}
+		return true
+	})
+	return num
+}
+
+// 第一个满足条件的obj(排序时为排在最前的obj)，没有时返回nil
+func (q *Query) First() interface{} {
+	limit := q.limit
+	q.limit = 1
+	objs := q.All()
+	q.limit = limit
+	if len(objs) == 0 {
+		return nil
+	}
+	return objs[0]
+}
+
+// 满足条件的obj
+func (q *Query) All() []interface{} {
+	if q.field == nil {
+		objs := make([]interface{}, 0)
+		q.container.Range(func(sid uint64, obj interface{}) bool {
+			if q.match(obj) {
+				objs = append(objs, obj)
+			}
+			return q.limit <= 0 || len(objs) < q.limit
+		})
+		return objs
+	}
+
+	if q.limit <= 0 {
+		objs := make([]interface{}, 0)
+		q.container.Range(func(sid uint64, obj interface{}) bool {
+			if q.match(obj) {
+				objs = append(objs, obj)
+			}
+			return true
+		})
+		sort.Slice(objs, func(i, j int) bool {
+			return q.before(objs[i], objs[j])
+		})
+		return objs
+	}
+
+	// 有数量上限时只保留前limit个，堆顶是其中排在最后的obj
+	h := &queryHeap{query: q}
+	q.container.Range(func(sid uint64, obj interface{}) bool {
+		if !q.match(obj) {
+			return true
+		}
+		if h.Len() < q.limit {
+			heap.Push(h, obj)
+		} else if q.before(obj, h.objs[0]) {
+			h.objs[0] = obj
+			heap.Fix(h, 0)
+		}
+		return true
+	})
+	objs := h.objs
+	sort.Slice(objs, func(i, j int) bool {
+		return q.before(objs[i], objs[j])
+	})
+	return objs
+}
+
+// a是否排在b前面
+func (q *Query) before(a interface{}, b interface{}) bool {
+	va := reflect.ValueOf(a).Elem().FieldByIndex(q.field.Index)
+	vb := reflect.ValueOf(b).Elem().FieldByIndex(q.field.Index)
+	if q.desc {
+		return compareValue(vb, va) < 0
+	}
+	return compareValue(va, vb) < 0
+}
+
+// 比较两个相同类型的字段值(a<b返回-1，a==b返回0，a>b返回1)
+func compareValue(a reflect.Value, b reflect.Value) int {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareOrdered(a.Int() < b.Int(), a.Int() > b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return compareOrdered(a.Uint() < b.Uint(), a.Uint() > b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return compareOrdered(a.Float() < b.Float(), a.Float() > b.Float())
+	case reflect.String:
+		return compareOrdered(a.String() < b.String(), a.String() > b.String())
+	case reflect.Bool:
+		return compareOrdered(!a.Bool() && b.Bool(), a.Bool() && !b.Bool())
+	}
+	if ta, ok := a.Interface().(time.Time); ok {
+		tb := b.Interface().(time.Time)
+		return compareOrdered(ta.Before(tb), ta.After(tb))
+	}
+	return 0
+}
+
+func compareOrdered(less bool, greater bool) int {
+	if less {
+		return -1
+	}
+	if greater {
+		return 1
+	}
+	return 0
+}
+
+// 排序的堆(堆顶是排在最后的obj)
+type queryHeap struct {
+	query *Query
+	objs  []interface{}
+}
+
+func (h *queryHeap) Len() int { return len(h.objs) }
+func (h *queryHeap) Less(i, j int) bool {
+	return h.query.before(h.objs[j], h.objs[i])
+}
+func (h *queryHeap) Swap(i, j int)      { h.objs[i], h.objs[j] = h.objs[j], h.objs[i] }
+func (h *queryHeap) Push(x interface{}) { h.objs = append(h.objs, x) }
+func (h *queryHeap) Pop() interface{} {
+	n := len(h.objs)
+	obj := h.objs[n-1]
+	h.objs = h.objs[:n-1]
+	return obj
+}
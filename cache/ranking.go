@@ -0,0 +1,314 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+const (
+	rankMaxLevel = 32   // 跳表最大层数
+	rankLevelP   = 0.25 // 跳表节点升层的概率
+)
+
+// 排行榜配置
+type RankConfig struct {
+	Name  string                      // 排行榜名称
+	Score func(obj interface{}) int64 // 分数(越大越靠前)
+	Tie   func(obj interface{}) int64 // 分数相同时的排序值(越小越靠前，如达成时间)，nil时按主键排序
+	Skip  func(obj interface{}) bool  // 不参与排行的obj(可为nil)
+}
+
+// 排行榜中的一项
+type RankEntry struct {
+	Rank  int // 名次(从1开始)
+	Sid   uint64
+	Keys  []interface{} // 除sid外的主键值(整数为uint64，负数为int64，字符串不变)
+	Score int64         // 分数
+	Obj   interface{}   // 对应的obj
+}
+
+// 排行榜：在容器的obj上维护的有序视图(跳表)，每次Replace/Delete时更新，查询名次和区间都是O(log n)
+//
+// 非预加载容器的排行榜只包含已加载到内存中的cell
+type Ranking struct {
+	cfg   RankConfig
+	lock  sync.RWMutex
+	list  *skipList
+	nodes map[string]*rankNode // 主键 -> 跳表节点
+}
+
+// 在容器上建立排行榜，通过Container.Ranking(name)查询
+func WithRanking(cfg RankConfig) ContainerOption {
+	return func(o *containerOptions) {
+		if cfg.Score == nil {
+			panic(fmt.Sprintf("cache ranking score func is nil, name:%s", cfg.Name))
+		}
+		o.rankings = append(o.rankings, cfg)
+	}
+}
+
+func newRanking(cfg RankConfig) *Ranking {
+	return &Ranking{
+		cfg:   cfg,
+		list:  newSkipList(),
+		nodes: make(map[string]*rankNode),
+	}
+}
+
+// 获取排行榜，不存在时返回nil
+func (c *Container) Ranking(name string) *Ranking {
+	return c.rankings[name]
+}
+
+func (r *Ranking) observe(sid uint64, keys []interface{}, old interface{}, obj interface{}) {
+	key := indexKey(sid, keys)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	// 调用方可能原地修改obj后再Replace，通过主键找到之前的节点
+	if node, exit := r.nodes[key]; exit {
+		r.list.delete(node)
+		delete(r.nodes, key)
+	}
+	if obj == nil || (r.cfg.Skip != nil && r.cfg.Skip(obj)) {
+		return
+	}
+	node := &rankNode{sid: sid, keys: keys, score: r.cfg.Score(obj), obj: obj}
+	if r.cfg.Tie != nil {
+		node.tie = r.cfg.Tie(obj)
+	}
+	r.list.insert(node)
+	r.nodes[key] = node
+}
+
+// 参与排行的数量
+func (r *Ranking) Len() int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.list.length
+}
+
+// 名次(从1开始)，不在排行榜中返回0
+func (r *Ranking) Rank(sid uint64, keys ...interface{}) int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	node, exit := r.nodes[indexKey(sid, anyKeys(keys))]
+	if !exit {
+		return 0
+	}
+	return r.list.rankOf(node)
+}
+
+// 排行榜中的某一项，不在排行榜中返回nil
+func (r *Ranking) Get(sid uint64, keys ...interface{}) *RankEntry {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	node, exit := r.nodes[indexKey(sid, anyKeys(keys))]
+	if !exit {
+		return nil
+	}
+	return node.entry(r.list.rankOf(node))
+}
+
+// 前n名
+func (r *Ranking) Top(n int) []*RankEntry {
+	return r.Range(1, n)
+}
+
+// 某一项前后各k名(包含自己)，不在排行榜中返回nil
+func (r *Ranking) Around(sid uint64, k int, keys ...interface{}) []*RankEntry {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	node, exit := r.nodes[indexKey(sid, anyKeys(keys))]
+	if !exit {
+		return nil
+	}
+	rank := r.list.rankOf(node)
+	start := rank - k
+	if start < 1 {
+		start = 1
+	}
+	return r.rangeLocked(start, rank+k-start+1)
+}
+
+// 分页查询(page从1开始)
+func (r *Ranking) Page(page int, size int) []*RankEntry {
+	if page < 1 || size <= 0 {
+		return nil
+	}
+	return r.Range((page-1)*size+1, size)
+}
+
+// 从第start名开始的n项
+func (r *Ranking) Range(start int, n int) []*RankEntry {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.rangeLocked(start, n)
+}
+
+func (r *Ranking) rangeLocked(start int, n int) []*RankEntry {
+	entries := make([]*RankEntry, 0)
+	if start < 1 || n <= 0 {
+		return entries
+	}
+	node := r.list.byRank(start)
+	for rank := start; node != nil && rank < start+n; rank++ {
+		entries = append(entries, node.entry(rank))
+		node = node.levels[0].next
+	}
+	return entries
+}
+
+// 跳表节点(分数在插入时记录，原地修改obj不会影响跳表结构)
+type rankNode struct {
+	sid    uint64
+	keys   []interface{}
+	score  int64
+	tie    int64
+	obj    interface{}
+	levels []rankLevel
+}
+
+type rankLevel struct {
+	next *rankNode
+	span int // 到next跨过的节点数
+}
+
+func (n *rankNode) entry(rank int) *RankEntry {
+	return &RankEntry{Rank: rank, Sid: n.sid, Keys: n.keys, Score: n.score, Obj: n.obj}
+}
+
+// a是否排在b前面
+func (n *rankNode) before(b *rankNode) bool {
+	if n.score != b.score {
+		return n.score > b.score
+	}
+	if n.tie != b.tie {
+		return n.tie < b.tie
+	}
+	if n.sid != b.sid {
+		return n.sid < b.sid
+	}
+	for i := 0; i < len(n.keys) && i < len(b.keys); i++ {
+		if n.keys[i] != b.keys[i] {
+			return compareKey(n.keys[i], b.keys[i]) < 0
+		}
+	}
+	return false
+}
+
+// 带跨度的跳表，支持按名次查询
+type skipList struct {
+	head   *rankNode
+	level  int
+	length int
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		head:  &rankNode{levels: make([]rankLevel, rankMaxLevel)},
+		level: 1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < rankMaxLevel && rand.Float64() < rankLevelP {
+		level++
+	}
+	return level
+}
+
+func (sl *skipList) insert(node *rankNode) {
+	var update [rankMaxLevel]*rankNode
+	var rank [rankMaxLevel]int
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.levels[i].next != nil && x.levels[i].next.before(node) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].next
+		}
+		update[i] = x
+	}
+	level := randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = sl.head
+			update[i].levels[i].span = sl.length
+		}
+		sl.level = level
+	}
+	node.levels = make([]rankLevel, level)
+	for i := 0; i < level; i++ {
+		node.levels[i].next = update[i].levels[i].next
+		update[i].levels[i].next = node
+		node.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < sl.level; i++ {
+		update[i].levels[i].span++
+	}
+	sl.length++
+}
+
+func (sl *skipList) delete(node *rankNode) {
+	var update [rankMaxLevel]*rankNode
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && x.levels[i].next.before(node) {
+			x = x.levels[i].next
+		}
+		update[i] = x
+	}
+	if x.levels[0].next != node {
+		return
+	}
+	for i := 0; i < sl.level; i++ {
+		if update[i].levels[i].next == node {
+			update[i].levels[i].span += node.levels[i].span - 1
+			update[i].levels[i].next = node.levels[i].next
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+	for sl.level > 1 && sl.head.levels[sl.level-1].next == nil {
+		sl.level--
+	}
+	sl.length--
+}
+
+// 节点的名次(从1开始)
+func (sl *skipList) rankOf(node *rankNode) int {
+	rank := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && !node.before(x.levels[i].next) {
+			rank += x.levels[i].span
+			x = x.levels[i].next
+		}
+		if x == node {
+			return rank
+		}
+	}
+	return 0
+}
+
+// 第rank名的节点
+func (sl *skipList) byRank(rank int) *rankNode {
+	traversed := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && traversed+x.levels[i].span <= rank {
+			traversed += x.levels[i].span
+			x = x.levels[i].next
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package cache
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type rankObj struct {
+	Sid   uint64
+	Id    uint32
+	Score int64
+	Time  int64
+}
+
+func newTestRanking(tie bool) *Ranking {
+	cfg := RankConfig{
+		Name:  "test",
+		Score: func(obj interface{}) int64 { return obj.(*rankObj).Score },
+		Skip:  func(obj interface{}) bool { return obj.(*rankObj).Score < 0 },
+	}
+	if tie {
+		cfg.Tie = func(obj interface{}) int64 { return obj.(*rankObj).Time }
+	}
+	return newRanking(cfg)
+}
+
+func putRank(r *Ranking, obj *rankObj) {
+	r.observe(obj.Sid, []interface{}{uint64(obj.Id)}, nil, obj)
+}
+
+func rankSids(entries []*RankEntry) []uint64 {
+	sids := make([]uint64, 0, len(entries))
+	for _, e := range entries {
+		sids = append(sids, e.Sid)
+	}
+	return sids
+}
+
+func TestRankingOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		tie  bool
+		objs []*rankObj
+		want []uint64 // 按名次排列的sid
+	}{
+		{"empty", false, nil, []uint64{}},
+		{"score desc", false, []*rankObj{{Sid: 1, Score: 10}, {Sid: 2, Score: 30}, {Sid: 3, Score: 20}}, []uint64{2, 3, 1}},
+		{"same score by sid", false, []*rankObj{{Sid: 3, Score: 10}, {Sid: 1, Score: 10}, {Sid: 2, Score: 10}}, []uint64{1, 2, 3}},
+		{"same score by tie", true, []*rankObj{{Sid: 1, Score: 10, Time: 3}, {Sid: 2, Score: 10, Time: 1}, {Sid: 3, Score: 10, Time: 2}}, []uint64{2, 3, 1}},
+		{"skip", false, []*rankObj{{Sid: 1, Score: -1}, {Sid: 2, Score: 5}}, []uint64{2}},
+		{"update", false, []*rankObj{{Sid: 1, Score: 10}, {Sid: 2, Score: 20}, {Sid: 1, Score: 30}}, []uint64{1, 2}},
+		{"update to skip", false, []*rankObj{{Sid: 1, Score: 10}, {Sid: 2, Score: 20}, {Sid: 2, Score: -1}}, []uint64{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRanking(tt.tie)
+			for _, obj := range tt.objs {
+				putRank(r, obj)
+			}
+			if r.Len() != len(tt.want) {
+				t.Fatalf("Len() = %d, want %d", r.Len(), len(tt.want))
+			}
+			got := rankSids(r.Top(len(tt.want) + 1))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Top() = %v, want %v", got, tt.want)
+			}
+			for i, sid := range tt.want {
+				if rank := r.Rank(sid, uint32(0)); rank != i+1 {
+					t.Errorf("Rank(%d) = %d, want %d", sid, rank, i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestRankingRange(t *testing.T) {
+	r := newTestRanking(false)
+	// sid 1..10，分数越大sid越小
+	for sid := uint64(1); sid <= 10; sid++ {
+		putRank(r, &rankObj{Sid: sid, Score: int64(100 - sid)})
+	}
+	tests := []struct {
+		name string
+		got  func() []*RankEntry
+		want []uint64
+	}{
+		{"top", func() []*RankEntry { return r.Top(3) }, []uint64{1, 2, 3}},
+		{"top over len", func() []*RankEntry { return r.Top(20) }, []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{"range", func() []*RankEntry { return r.Range(4, 3) }, []uint64{4, 5, 6}},
+		{"range tail", func() []*RankEntry { return r.Range(9, 5) }, []uint64{9, 10}},
+		{"range out", func() []*RankEntry { return r.Range(11, 5) }, []uint64{}},
+		{"range zero start", func() []*RankEntry { return r.Range(0, 5) }, []uint64{}},
+		{"range zero n", func() []*RankEntry { return r.Range(1, 0) }, []uint64{}},
+		{"page", func() []*RankEntry { return r.Page(2, 4) }, []uint64{5, 6, 7, 8}},
+		{"page last", func() []*RankEntry { return r.Page(3, 4) }, []uint64{9, 10}},
+		{"page invalid", func() []*RankEntry { return r.Page(0, 4) }, []uint64{}},
+		{"around", func() []*RankEntry { return r.Around(5, 2, uint64(0)) }, []uint64{3, 4, 5, 6, 7}},
+		{"around head", func() []*RankEntry { return r.Around(1, 2, 0) }, []uint64{1, 2, 3}},
+		{"around tail", func() []*RankEntry { return r.Around(10, 2, int32(0)) }, []uint64{8, 9, 10}},
+		{"around missing", func() []*RankEntry { return r.Around(11, 2, 0) }, []uint64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := tt.got()
+			got := rankSids(entries)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for _, e := range entries {
+				if e.Rank != int(e.Sid) {
+					t.Errorf("sid %d rank = %d, want %d", e.Sid, e.Rank, e.Sid)
+				}
+			}
+		})
+	}
+}
+
+func TestRankingGet(t *testing.T) {
+	r := newTestRanking(false)
+	putRank(r, &rankObj{Sid: 1, Id: 7, Score: 10})
+	putRank(r, &rankObj{Sid: 2, Id: 7, Score: 20})
+	tests := []struct {
+		name  string
+		sid   uint64
+		key   interface{}
+		rank  int
+		score int64
+	}{
+		{"first", 2, uint32(7), 1, 20},
+		{"second", 1, 7, 2, 10},
+		{"uint64 key", 1, uint64(7), 2, 10},
+		{"missing key", 1, 8, 0, 0},
+		{"missing sid", 3, 7, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rank := r.Rank(tt.sid, tt.key); rank != tt.rank {
+				t.Errorf("Rank() = %d, want %d", rank, tt.rank)
+			}
+			entry := r.Get(tt.sid, tt.key)
+			if tt.rank == 0 {
+				if entry != nil {
+					t.Errorf("Get() = %+v, want nil", entry)
+				}
+				return
+			}
+			if entry == nil || entry.Rank != tt.rank || entry.Score != tt.score {
+				t.Errorf("Get() = %+v, want rank %d score %d", entry, tt.rank, tt.score)
+			}
+		})
+	}
+}
+
+// 随机插入、更新、删除后和排序结果比较
+func TestRankingRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	r := newTestRanking(false)
+	scores := make(map[uint64]int64)
+	for i := 0; i < 2000; i++ {
+		sid := uint64(rnd.Intn(300))
+		if rnd.Intn(5) == 0 {
+			r.observe(sid, []interface{}{uint64(0)}, nil, nil)
+			delete(scores, sid)
+			continue
+		}
+		score := int64(rnd.Intn(50))
+		putRank(r, &rankObj{Sid: sid, Score: score})
+		scores[sid] = score
+	}
+	want := make([]uint64, 0, len(scores))
+	for sid := range scores {
+		want = append(want, sid)
+	}
+	sort.Slice(want, func(i, j int) bool {
+		a, b := want[i], want[j]
+		if scores[a] != scores[b] {
+			return scores[a] > scores[b]
+		}
+		return a < b
+	})
+	got := rankSids(r.Top(len(want)))
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Top() = %v, want %v", got, want)
+	}
+	for i, sid := range want {
+		if rank := r.Rank(sid, 0); rank != i+1 {
+			t.Fatalf("Rank(%d) = %d, want %d", sid, rank, i+1)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/fengzhu0601/gotools/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const defaultPollColumn = "updated_at"
+
+// 重新加载的结果
+type ReloadResult struct {
+	ObjType  reflect.Type
+	Reloaded int // 重新加载的sid数量
+	Skipped  int // 有未写入变更而跳过的sid数量
+	Changed  int // 发生变化的obj数量
+}
+
+// 定时从数据库拉取外部修改过的数据(通过更新时间列)，不会发现数据库中被删除的数据
+//
+// 更新时间列需要在每次修改时更新(如ON UPDATE CURRENT_TIMESTAMP)，外部修改没有更新该列时不会被发现；
+// 全局容器没有sid，只统计该列在上次拉取之后的行数，有变化时重新加载整张表
+func WithReloadPolling(interval time.Duration, column string) ContainerOption {
+	return func(o *containerOptions) {
+		if column == "" {
+			column = defaultPollColumn
+		}
+		o.pollInterval = interval
+		o.pollColumn = column
+	}
+}
+
+// 从数据库重新加载一批sid(不传sid时重新加载全部数据)，有未写入变更的cell会被跳过
+//
+// 预加载容器全量重新加载时会加入数据库中新增的sid，非预加载容器只重新加载已在内存中的cell
+func (c *Container) Reload(ctx context.Context, sids ...uint64) (*ReloadResult, error) {
+	return c.reload(ctx, sids, false)
+}
+
+// 强制从数据库重新加载，有未写入变更的cell也以数据库为准
+func (c *Container) ReloadForce(ctx context.Context, sids ...uint64) (*ReloadResult, error) {
+	return c.reload(ctx, sids, true)
+}
+
+func (c *Container) reload(ctx context.Context, sids []uint64, force bool) (*ReloadResult, error) {
+	result := &ReloadResult{ObjType: c.objType}
+	fields, err := c.verifyFields()
+	if err != nil {
+		return result, err
+	}
+	// 持有updater锁，重新加载期间不会有数据写入数据库
+	c.updater.lock.Lock()
+	defer c.updater.lock.Unlock()
+
+	dbs := []*gorm.DB{c.db.WithContext(ctx)}
+	if len(sids) == 0 && c.preload {
+		groups := c.groupSids(c.loadedSids())
+		for index, table := range c.tables() {
+			datas, err := c.find(dbs, table, nil)
+			if err != nil {
+				return result, err
+			}
+			c.reloadDatas(ctx, groups[index], datas, fields, force, result)
+		}
+		c.logReload(result)
+		return result, nil
+	}
+	if len(sids) == 0 {
+		sids = c.loadedSids()
+	}
+	for index, group := range c.groupSids(sids) {
+		datas, err := c.find(dbs, c.shardTable(index), group)
+		if err != nil {
+			return result, err
+		}
+		c.reloadDatas(ctx, group, datas, fields, force, result)
+	}
+	c.logReload(result)
+	return result, nil
+}
+
+// 用数据库数据更新cell：sids为需要更新的sid，预加载容器还会加入datas中新增的sid
+func (c *Container) reloadDatas(ctx context.Context, sids []uint64, datas reflect.Value, fields []*schema.Field, force bool, result *ReloadResult) {
+	stored := make(map[uint64]map[string]interface{})
+	for i := 0; i < datas.Len(); i++ {
+		obj := datas.Index(i).Interface()
+		afterCacheLoad(obj)
+		sid, ok := c.sidOf(obj)
+		if !ok {
+			logger.Error("cache load invalid sid", c.objType, obj)
+			continue
+		}
+		if stored[sid] == nil {
+			stored[sid] = make(map[string]interface{})
+		}
+		stored[sid][joinKeys(c.keysOf(obj))] = obj
+	}
+	c.dbLoadNum += uint64(datas.Len())
+
+	all := make([]uint64, 0, len(sids)+len(stored))
+	seen := make(map[uint64]bool, len(sids))
+	for _, sid := range sids {
+		seen[sid] = true
+		all = append(all, sid)
+	}
+	for sid := range stored {
+		if !seen[sid] {
+			all = append(all, sid)
+		}
+	}
+
+	for _, sid := range all {
+		v, exit := c.cells.Load(sid)
+		if !exit {
+			// 非预加载容器不在内存中的sid，下次访问时会从数据库加载最新数据
+			if !c.preload || len(stored[sid]) == 0 {
+				continue
+			}
+			v = c.reloadCell(sid)
+		}
+		cell := v.(*Cell)
+		if cell.isChange() && !force {
+			result.Skipped++
+			continue
+		}
+		mismatches, _ := c.compareCell(ctx, sid, cell, stored[sid], fields)
+		result.Reloaded++
+		result.Changed += c.repairFromDB(cell, mismatches, force, "reload")
+		c.reseedSnaps(sid, cell, stored[sid])
+	}
+}
+
+// 以数据库为准修复不一致的obj，返回修复的数量
+//
+// 比较之后可能有新的写入，非强制时在载体锁内检查并跳过有未写入变更的obj，不会覆盖新写入的数据
+func (c *Container) repairFromDB(cell *Cell, mismatches []*Mismatch, force bool, reason string) int {
+	repaired := 0
+	for _, m := range mismatches {
+		lock := c.lockSid(m.Sid)
+		if m.Kind == MISMATCH_MISSING_DB {
+			if force {
+				cell.cargo.DeleteSynced(m.Cached)
+			} else if !cell.cargo.DeleteIfClean(m.Cached) {
+				lock.Unlock()
+				continue
+			}
+			c.notify(OP_DELETE, m.Sid, c.keysOf(m.Cached), m.Cached, nil, reason)
+		} else {
+			if force {
+				cell.cargo.ReplaceSynced(m.Stored)
+			} else if !cell.cargo.ReplaceIfClean(m.Stored) {
+				lock.Unlock()
+				continue
+			}
+			c.notifyReplace(m.Sid, c.keysOf(m.Stored), m.Cached, m.Stored, reason)
+		}
+		lock.Unlock()
+		repaired++
+	}
+	return repaired
+}
+
+// 预加载容器中新增sid的cell
+func (c *Container) reloadCell(sid uint64) *Cell {
+	c.cellLock.Lock()
+	defer c.cellLock.Unlock()
+	cell, exit := c.cells.Load(sid)
+	if exit {
+		return cell.(*Cell)
+	}
+	newCargo := c.newCargo()
+	newCell := &Cell{cargo: newCargo}
+	c.cellStore(sid, newCell)
+	return newCell
+}
+
+// 内存中所有的sid
+func (c *Container) loadedSids() []uint64 {
+	sids := make([]uint64, 0)
+	c.cells.Range(func(k any, v any) bool {
+		sids = append(sids, k.(uint64))
+		return true
+	})
+	return sids
+}
+
+func (c *Container) logReload(result *ReloadResult) {
+	logger.Info("cache reload", c.objType, "reloaded:", result.Reloaded, "skipped:", result.Skipped, "changed:", result.Changed)
+}
+
+// 定时拉取更新时间列在上次拉取之后的数据
+func (c *Container) runPolling(ctx context.Context) {
+	ticker := time.NewTicker(c.opts.pollInterval)
+	defer ticker.Stop()
+	since := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now()
+			// 多拉取一秒，防止数据库时间精度和时钟误差漏掉数据
+			sids, err := c.changedSids(ctx, since.Add(-time.Second))
+			if err != nil {
+				logger.Error("cache reload polling error", c.objType, err)
+				continue
+			}
+			since = now
+			if len(sids) == 0 {
+				continue
+			}
+			_, err = c.Reload(ctx, sids...)
+			if err != nil {
+				logger.Error("cache reload polling error", c.objType, err)
+			}
+		}
+	}
+}
+
+// 更新时间列在since之后的sid(全局容器有变化时返回globalSid)
+func (c *Container) changedSids(ctx context.Context, since time.Time) ([]uint64, error) {
+	sids := make([]uint64, 0)
+	if c.opts.global {
+		var num int64
+		err := c.db.WithContext(ctx).Table(c.tableName).Where(c.opts.pollColumn+" >= ?", since).Count(&num).Error
+		if err != nil || num == 0 {
+			return sids, err
+		}
+		return append(sids, globalSid), nil
+	}
+	for _, table := range c.tables() {
+		var list []uint64
+		err := c.db.WithContext(ctx).Table(table).
+			Where(c.opts.pollColumn+" >= ?", since).
+			Distinct("sid").Pluck("sid", &list).Error
+		if err != nil {
+			return nil, err
+		}
+		sids = append(sids, list...)
+	}
+	return sids, nil
+}
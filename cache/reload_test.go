@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fengzhu0601/gotools/cache/internal/fakedb"
+)
+
+// 重新加载：没有未写入变更的cell以数据库为准，有未写入变更的cell跳过(强制时以数据库为准)
+func TestReload(t *testing.T) {
+	tests := []struct {
+		name   string
+		dirty  bool  // 重新加载前写入未同步的变更
+		force  bool  // ReloadForce
+		dbNum  int64 // 数据库中的新值(0表示数据库中已删除)
+		want   *testItem
+		result ReloadResult
+	}{
+		{"clean", false, false, 5, &testItem{Sid: 1, Id: 1, Num: 5, Name: "db"}, ReloadResult{Reloaded: 1, Changed: 1}},
+		{"clean deleted in db", false, false, 0, nil, ReloadResult{Reloaded: 1, Changed: 1}},
+		{"dirty", true, false, 5, &testItem{Sid: 1, Id: 1, Num: 9}, ReloadResult{Skipped: 1}},
+		{"dirty force", true, true, 5, &testItem{Sid: 1, Id: 1, Num: 5, Name: "db"}, ReloadResult{Reloaded: 1, Changed: 1}},
+		{"unchanged", false, false, 1, &testItem{Sid: 1, Id: 1, Num: 1, Name: "db"}, ReloadResult{Reloaded: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakedb.New()
+			num := int64(1)
+			loadTestItemNum(db, &num)
+			c := newTestContainer(t, db, testItemType, WithPreload(true))
+			if tt.dirty {
+				c.Replace(&testItem{Sid: 1, Id: 1, Num: 9})
+			}
+			if tt.dbNum == 0 {
+				db.QueryFn = nil
+			} else {
+				num = tt.dbNum
+			}
+			reload := c.Reload
+			if tt.force {
+				reload = c.ReloadForce
+			}
+			result, err := reload(context.Background(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result.ObjType = nil
+			if !reflect.DeepEqual(*result, tt.result) {
+				t.Fatalf("Reload() = %+v, want %+v", *result, tt.result)
+			}
+			got, _ := c.Lookup(1, 1).(*testItem)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Lookup() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 比较之后有新的写入：非强制修复跳过有未写入变更的obj，不会覆盖新写入的数据
+func TestRepairFromDBIfClean(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     MismatchKind
+		force    bool
+		repaired int
+		want     *testItem
+	}{
+		{"field", MISMATCH_FIELD, false, 0, &testItem{Sid: 1, Id: 1, Num: 9}},
+		{"field force", MISMATCH_FIELD, true, 1, &testItem{Sid: 1, Id: 1, Num: 5, Name: "db"}},
+		{"missing db", MISMATCH_MISSING_DB, false, 0, &testItem{Sid: 1, Id: 1, Num: 9}},
+		{"missing db force", MISMATCH_MISSING_DB, true, 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContainer(t, fakedb.New(), testItemType, WithPreload(true))
+			cached := &testItem{Sid: 1, Id: 1, Num: 1}
+			c.Replace(cached)
+			c.updater.batchUpdate()
+			m := &Mismatch{Sid: 1, Keys: c.keysOf(cached), Kind: tt.kind, Cached: cached}
+			if tt.kind == MISMATCH_FIELD {
+				m.Stored = &testItem{Sid: 1, Id: 1, Num: 5, Name: "db"}
+			}
+
+			c.Replace(&testItem{Sid: 1, Id: 1, Num: 9})
+			cell, _ := c.cellLoad(1)
+			if got := c.repairFromDB(cell, []*Mismatch{m}, tt.force, "reload"); got != tt.repaired {
+				t.Fatalf("repairFromDB() = %d, want %d", got, tt.repaired)
+			}
+			got, _ := c.Lookup(1, 1).(*testItem)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Lookup() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 拉取外部修改：玩家容器按更新时间列查询sid，全局容器只统计行数
+func TestChangedSids(t *testing.T) {
+	since := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		opts    []ContainerOption
+		count   int64
+		want    []uint64
+		queries []string
+	}{
+		{"player", []ContainerOption{WithPreload(true)}, 0, []uint64{1, 2}, []string{"DISTINCT"}},
+		{"shard", []ContainerOption{WithPreload(true), WithShard(&ShardConfig{Num: 2})}, 0, []uint64{1, 2, 1, 2}, []string{"test_item_00", "test_item_01"}},
+		{"global changed", []ContainerOption{WithGlobal()}, 3, []uint64{globalSid}, []string{"count"}},
+		{"global unchanged", []ContainerOption{WithGlobal()}, 0, []uint64{}, []string{"count"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakedb.New()
+			c := newTestContainer(t, db, testItemType, append(tt.opts, WithReloadPolling(time.Hour, ""))...)
+			db.QueryFn = func(query string, args []interface{}) (*fakedb.Rows, error) {
+				if !reflect.DeepEqual(args, []interface{}{since}) {
+					t.Errorf("%s args = %v, want since", query, args)
+				}
+				if !strings.Contains(query, "updated_at >= ?") {
+					t.Errorf("query without poll column: %s", query)
+				}
+				if strings.Contains(query, "count") {
+					return &fakedb.Rows{Columns: []string{"count(*)"}, Values: [][]driver.Value{{tt.count}}}, nil
+				}
+				return &fakedb.Rows{Columns: []string{"sid"}, Values: [][]driver.Value{{int64(1)}, {int64(2)}}}, nil
+			}
+			got, err := c.changedSids(context.Background(), since)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("changedSids() = %v, want %v", got, tt.want)
+			}
+			queries := db.QueriesOf("")
+			if len(queries) != len(tt.queries) {
+				t.Fatalf("queries = %v, want %d", queries, len(tt.queries))
+			}
+			for i, sub := range tt.queries {
+				if !strings.Contains(queries[i].Query, sub) {
+					t.Fatalf("query %s, want %s", queries[i].Query, sub)
+				}
+			}
+		})
+	}
+}
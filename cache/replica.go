@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 配置了从库但未配置ReplicaLagSeconds时的延迟保护时间(秒)
+const defaultReplicaLagSeconds = 5
+
+// 只读从库配置(未配置的字段使用主库配置)
+type DBReplica struct {
+	DBHost string
+	DBPort int
+	DBUser string
+	DBPass string
+}
+
+// 初始化只读从库连接
+func (cache *Cache) initReplicas() error {
+	for _, replica := range cache.dbConfig.DBReplicas {
+		d, err := openDB(replicaConfig(cache.dbConfig, replica))
+		if err != nil {
+			return err
+		}
+		cache.readCons = append(cache.readCons, d)
+	}
+	return nil
+}
+
+// 从库的完整配置
+func replicaConfig(dbCfg *DBConfig, replica DBReplica) *DBConfig {
+	cfg := *dbCfg
+	cfg.DBReplicas = nil
+	cfg.DBHost = replica.DBHost
+	if replica.DBPort != 0 {
+		cfg.DBPort = replica.DBPort
+	}
+	if replica.DBUser != "" {
+		cfg.DBUser = replica.DBUser
+		cfg.DBPass = replica.DBPass
+	}
+	return &cfg
+}
+
+// 读数据时依次尝试的连接：轮询选出的从库优先，主库最后
+func (cache *Cache) readDBs() []*gorm.DB {
+	num := len(cache.readCons)
+	if num == 0 {
+		return []*gorm.DB{cache.dbCon}
+	}
+	return rotateDBs(cache.readCons, cache.dbCon, atomic.AddUint32(&cache.readIndex, 1))
+}
+
+// 从第index%len(replicas)个从库开始依次排列所有从库，主库放在最后
+func rotateDBs(replicas []*gorm.DB, primary *gorm.DB, index uint32) []*gorm.DB {
+	num := len(replicas)
+	dbs := make([]*gorm.DB, 0, num+1)
+	if num > 0 {
+		start := int(index % uint32(num))
+		for i := 0; i < num; i++ {
+			dbs = append(dbs, replicas[(start+i)%num])
+		}
+	}
+	return append(dbs, primary)
+}
+
+// 从库延迟保护时间(秒)，未配置时使用默认值
+func (cache *Cache) replicaLag() int64 {
+	if cache.dbConfig.ReplicaLagSeconds == 0 {
+		return defaultReplicaLagSeconds
+	}
+	return cache.dbConfig.ReplicaLagSeconds
+}
+
+// 是否开启从库延迟保护
+func (cache *Cache) lagGuard() bool {
+	return len(cache.readCons) > 0 && cache.replicaLag() > 0
+}
+
+// 容器读数据时依次尝试的连接(容器指定了连接时不使用从库)
+func (c *Container) readDBs() []*gorm.DB {
+	if c.db != c.cache.dbCon {
+		return []*gorm.DB{c.db}
+	}
+	return c.cache.readDBs()
+}
+
+// 容器是否开启从库延迟保护
+func (c *Container) lagGuard() bool {
+	return c.db == c.cache.dbCon && c.cache.lagGuard()
+}
+
+// 记录sid最近一次刷新到主库的时间
+func (c *Container) markFlushed(sid uint64, now int64) {
+	if c.lagGuard() {
+		c.flushTimes.Store(sid, now)
+	}
+}
+
+// 清理超过延迟保护时间的刷新记录
+func (c *Container) pruneFlushed(now int64) {
+	if !c.lagGuard() {
+		return
+	}
+	lag := c.cache.replicaLag()
+	c.flushTimes.Range(func(k any, v any) bool {
+		if v.(int64)+lag < now {
+			c.flushTimes.Delete(k)
+		}
+		return true
+	})
+}
+
+// 把sid分成需要读主库的(最近刷新过)和可以读从库的
+func (c *Container) splitByLag(sidList []uint64) ([]uint64, []uint64) {
+	return c.splitByLagAt(sidList, time.Now().Unix())
+}
+
+// 按now时刻把sid分成需要读主库的(lag秒内刷新过)和可以读从库的
+func (c *Container) splitByLagAt(sidList []uint64, now int64) ([]uint64, []uint64) {
+	if !c.lagGuard() {
+		return nil, sidList
+	}
+	lag := c.cache.replicaLag()
+	var primarySids, replicaSids []uint64
+	for _, sid := range sidList {
+		t, exit := c.flushTimes.Load(sid)
+		if exit && t.(int64)+lag >= now {
+			primarySids = append(primarySids, sid)
+		} else {
+			replicaSids = append(replicaSids, sid)
+		}
+	}
+	return primarySids, replicaSids
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// 测试用的连接及其名字(gorm.DB按值比较都相等，按名字比较顺序)
+func namedDBs(names ...string) ([]*gorm.DB, map[*gorm.DB]string) {
+	dbs := make([]*gorm.DB, 0, len(names))
+	nameOf := make(map[*gorm.DB]string)
+	for _, name := range names {
+		db := &gorm.DB{}
+		dbs = append(dbs, db)
+		nameOf[db] = name
+	}
+	return dbs, nameOf
+}
+
+func dbNames(dbs []*gorm.DB, nameOf map[*gorm.DB]string) []string {
+	names := make([]string, 0, len(dbs))
+	for _, db := range dbs {
+		names = append(names, nameOf[db])
+	}
+	return names
+}
+
+func TestRotateDBs(t *testing.T) {
+	dbs, nameOf := namedDBs("primary", "r0", "r1", "r2")
+	primary, replicas := dbs[0], dbs[1:]
+	tests := []struct {
+		name     string
+		replicas []*gorm.DB
+		index    uint32
+		want     []string
+	}{
+		{"no replica", nil, 7, []string{"primary"}},
+		{"first", replicas, 0, []string{"r0", "r1", "r2", "primary"}},
+		{"rotate", replicas, 1, []string{"r1", "r2", "r0", "primary"}},
+		{"wrap", replicas, 5, []string{"r2", "r0", "r1", "primary"}},
+		{"overflow", replicas, 1<<32 - 1, []string{"r0", "r1", "r2", "primary"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dbNames(rotateDBs(tt.replicas, primary, tt.index), nameOf); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("rotateDBs(%d) = %v, want %v", tt.index, got, tt.want)
+			}
+		})
+	}
+}
+
+// 每次读取轮询下一个从库，主库总是最后尝试
+func TestReadDBsRoundRobin(t *testing.T) {
+	dbs, nameOf := namedDBs("primary", "r0", "r1", "own")
+	cache := &Cache{dbCon: dbs[0], readCons: dbs[1:3]}
+	got := make([][]string, 0)
+	for i := 0; i < 3; i++ {
+		got = append(got, dbNames(cache.readDBs(), nameOf))
+	}
+	want := [][]string{{"r1", "r0", "primary"}, {"r0", "r1", "primary"}, {"r1", "r0", "primary"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("readDBs() = %v, want %v", got, want)
+	}
+
+	// 容器指定了连接时不使用从库
+	c := &Container{cache: cache, db: dbs[3]}
+	if got := dbNames(c.readDBs(), nameOf); !reflect.DeepEqual(got, []string{"own"}) {
+		t.Fatalf("container readDBs() = %v, want [own]", got)
+	}
+}
+
+func TestLagGuard(t *testing.T) {
+	tests := []struct {
+		name     string
+		replicas int
+		setting  int64
+		lag      int64
+		guard    bool
+	}{
+		{"default", 1, 0, defaultReplicaLagSeconds, true},
+		{"custom", 1, 3, 3, true},
+		{"disabled", 1, -1, -1, false},
+		{"no replica", 0, 0, defaultReplicaLagSeconds, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := &Cache{dbConfig: &DBConfig{ReplicaLagSeconds: tt.setting}, dbCon: &gorm.DB{}}
+			for i := 0; i < tt.replicas; i++ {
+				cache.readCons = append(cache.readCons, &gorm.DB{})
+			}
+			if lag := cache.replicaLag(); lag != tt.lag {
+				t.Errorf("replicaLag() = %d, want %d", lag, tt.lag)
+			}
+			if guard := cache.lagGuard(); guard != tt.guard {
+				t.Errorf("lagGuard() = %v, want %v", guard, tt.guard)
+			}
+		})
+	}
+}
+
+func TestSplitByLag(t *testing.T) {
+	const now = 1000
+	tests := []struct {
+		name     string
+		setting  int64
+		ownDB    bool
+		flushed  map[uint64]int64 // sid最近刷新时间
+		primary  []uint64
+		replicas []uint64
+	}{
+		{"never flushed", 0, false, nil, nil, []uint64{1, 2, 3}},
+		{"recently flushed", 0, false, map[uint64]int64{2: now - 1}, []uint64{2}, []uint64{1, 3}},
+		{"lag boundary", 0, false, map[uint64]int64{1: now - defaultReplicaLagSeconds, 3: now - defaultReplicaLagSeconds - 1}, []uint64{1}, []uint64{2, 3}},
+		{"custom lag", 10, false, map[uint64]int64{1: now - 8, 2: now - 11}, []uint64{1}, []uint64{2, 3}},
+		{"disabled", -1, false, map[uint64]int64{1: now}, nil, []uint64{1, 2, 3}},
+		{"own db", 0, true, map[uint64]int64{1: now}, nil, []uint64{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &gorm.DB{}
+			cache := &Cache{dbConfig: &DBConfig{ReplicaLagSeconds: tt.setting}, dbCon: primary, readCons: []*gorm.DB{{}}}
+			c := &Container{cache: cache, db: primary}
+			if tt.ownDB {
+				c.db = &gorm.DB{}
+			}
+			for sid, flushTime := range tt.flushed {
+				c.flushTimes.Store(sid, flushTime)
+			}
+			primarySids, replicaSids := c.splitByLagAt([]uint64{1, 2, 3}, now)
+			if !reflect.DeepEqual(primarySids, tt.primary) || !reflect.DeepEqual(replicaSids, tt.replicas) {
+				t.Fatalf("splitByLagAt() = %v %v, want %v %v", primarySids, replicaSids, tt.primary, tt.replicas)
+			}
+		})
+	}
+}
+
+// 只在开启延迟保护时记录刷新时间，过期的记录被清理
+func TestMarkFlushed(t *testing.T) {
+	primary := &gorm.DB{}
+	cache := &Cache{dbConfig: &DBConfig{ReplicaLagSeconds: 5}, dbCon: primary, readCons: []*gorm.DB{{}}}
+	c := &Container{cache: cache, db: primary}
+	c.markFlushed(1, 100)
+	c.markFlushed(2, 104)
+	c.pruneFlushed(106)
+	if _, exit := c.flushTimes.Load(uint64(1)); exit {
+		t.Errorf("sid 1 flush time not pruned")
+	}
+	if _, exit := c.flushTimes.Load(uint64(2)); !exit {
+		t.Errorf("sid 2 flush time pruned")
+	}
+
+	cache.dbConfig.ReplicaLagSeconds = -1
+	c.markFlushed(3, 106)
+	if _, exit := c.flushTimes.Load(uint64(3)); exit {
+		t.Errorf("flush time recorded without lag guard")
+	}
+}
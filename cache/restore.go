@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 恢复计划中的一项变更
+type RestoreChange struct {
+	ObjType reflect.Type
+	Keys    []interface{} // 除sid外的主键值(和obj的主键字段一一对应)
+	Op      Op            // 恢复时执行的操作
+	Current interface{}   // 当前的obj(恢复时插入的为nil)
+	Target  interface{}   // 恢复后的obj(恢复时删除的为nil)
+}
+
+// 玩家数据的恢复计划
+type RestorePlan struct {
+	cache   *Cache
+	Sid     uint64
+	At      time.Time
+	Changes []*RestoreChange
+	Skipped []reflect.Type // 没有开启变更记录、无法恢复的容器
+}
+
+// 根据当前缓存数据和变更记录，计算把某个玩家所有容器的数据恢复到at时刻的计划
+//
+// 返回的计划不会修改任何数据，确认Diff后调用Apply执行
+func (cache *Cache) RestoreSid(ctx context.Context, sid uint64, at time.Time) (*RestorePlan, error) {
+	plan := &RestorePlan{cache: cache, Sid: sid, At: at}
+	for _, container := range cache.containerList {
+		if container.auditor == nil || container.opts.global {
+			plan.Skipped = append(plan.Skipped, container.objType)
+			continue
+		}
+		changes, err := container.restoreChanges(ctx, sid, at)
+		if err != nil {
+			return nil, err
+		}
+		plan.Changes = append(plan.Changes, changes...)
+	}
+	return plan, nil
+}
+
+// 计算容器中某个玩家恢复到at时刻需要的变更
+func (c *Container) restoreChanges(ctx context.Context, sid uint64, at time.Time) ([]*RestoreChange, error) {
+	records, err := c.History(ctx, sid, at, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// 当前数据作为快照，倒序撤销at之后的变更
+	current := make(map[string]interface{})
+	for _, obj := range c.LookupObjs(sid) {
+		current[joinKeys(c.keysOf(obj))] = obj
+	}
+	target := make(map[string]interface{}, len(current))
+	for k, obj := range current {
+		target[k] = obj
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		rec := records[i]
+		if !rec.Time.After(at) {
+			continue
+		}
+		if rec.Before == "" {
+			delete(target, rec.Keys)
+			continue
+		}
+		obj := reflect.New(c.objType).Interface()
+		err = json.Unmarshal([]byte(rec.Before), obj)
+		if err != nil {
+			return nil, err
+		}
+		target[rec.Keys] = obj
+	}
+
+	keyStrs := make([]string, 0, len(current)+len(target))
+	for k := range current {
+		keyStrs = append(keyStrs, k)
+	}
+	for k := range target {
+		if _, exit := current[k]; !exit {
+			keyStrs = append(keyStrs, k)
+		}
+	}
+	sort.Strings(keyStrs)
+
+	var changes []*RestoreChange
+	for _, k := range keyStrs {
+		cur, tar := current[k], target[k]
+		change := &RestoreChange{ObjType: c.objType, Current: cur, Target: tar}
+		switch {
+		case cur == nil:
+			change.Op = OP_INSERT
+			change.Keys = c.keysOf(tar)
+		case tar == nil:
+			change.Op = OP_DELETE
+			change.Keys = c.keysOf(cur)
+		case !jsonEqual(cur, tar):
+			change.Op = OP_UPDATE
+			change.Keys = c.keysOf(cur)
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func jsonEqual(a interface{}, b interface{}) bool {
+	dataA, _ := json.Marshal(a)
+	dataB, _ := json.Marshal(b)
+	return string(dataA) == string(dataB)
+}
+
+// 可读的差异列表
+func (p *RestorePlan) Diff() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "restore sid:%d at:%s changes:%d\n", p.Sid, p.At.Format(time.RFC3339), len(p.Changes))
+	for _, change := range p.Changes {
+		cur, _ := json.Marshal(change.Current)
+		tar, _ := json.Marshal(change.Target)
+		fmt.Fprintf(&sb, "%s %s keys:%v\n  - %s\n  + %s\n", opName(change.Op), change.ObjType.Name(), change.Keys, cur, tar)
+	}
+	for _, objType := range p.Skipped {
+		fmt.Fprintf(&sb, "skip %s (audit not enabled)\n", objType.Name())
+	}
+	return sb.String()
+}
+
+// 通过Replace/Delete执行恢复计划，缓存和数据库保持一致
+func (p *RestorePlan) Apply(ctx context.Context) error {
+	reason := "restore " + p.At.Format(time.RFC3339)
+	for _, change := range p.Changes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		container := p.cache.containers[change.ObjType]
+		if change.Op == OP_DELETE {
+			if !container.DeleteReason(change.Current, reason) {
+				return fmt.Errorf("restore delete failed, objType:%s sid:%d keys:%v", change.ObjType, p.Sid, change.Keys)
+			}
+			continue
+		}
+		err := container.ReplaceReason(change.Target, reason)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func opName(op Op) string {
+	switch op {
+	case OP_INSERT:
+		return "insert"
+	case OP_UPDATE:
+		return "update"
+	case OP_DELETE:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/fengzhu0601/gotools/cache/internal/fakedb"
+)
+
+// 按变更记录生成恢复计划，执行后恢复到at时刻的数据(数据库中sid 1为{1 1 1 db})
+func TestRestoreSid(t *testing.T) {
+	tests := []struct {
+		name   string
+		before func(c *Container) // at之前的写入
+		after  func(c *Container) // at之后的写入
+		flush  bool               // 生成计划前把记录写入文件
+		ops    []Op
+		want   []testItem
+	}{
+		{"in place updates", nil, func(c *Container) {
+			obj := c.Lookup(1, 1).(*testItem)
+			obj.Num = 2
+			c.Replace(obj)
+			obj.Num = 3
+			c.Replace(obj)
+		}, false, []Op{OP_UPDATE}, []testItem{{Sid: 1, Id: 1, Num: 1, Name: "db"}}},
+		{"flushed records", nil, func(c *Container) {
+			obj := c.Lookup(1, 1).(*testItem)
+			obj.Num = 2
+			c.Replace(obj)
+		}, true, []Op{OP_UPDATE}, []testItem{{Sid: 1, Id: 1, Num: 1, Name: "db"}}},
+		{"before at", func(c *Container) {
+			obj := c.Lookup(1, 1).(*testItem)
+			obj.Num = 2
+			c.Replace(obj)
+		}, func(c *Container) {
+			obj := c.Lookup(1, 1).(*testItem)
+			obj.Num = 3
+			c.Replace(obj)
+		}, false, []Op{OP_UPDATE}, []testItem{{Sid: 1, Id: 1, Num: 2, Name: "db"}}},
+		{"undo insert", nil, func(c *Container) {
+			c.Replace(&testItem{Sid: 1, Id: 2, Num: 5})
+		}, false, []Op{OP_DELETE}, []testItem{{Sid: 1, Id: 1, Num: 1, Name: "db"}}},
+		{"undo delete", nil, func(c *Container) {
+			obj := c.Lookup(1, 1).(*testItem)
+			obj.Num = 2
+			c.Replace(obj)
+			c.Delete(obj)
+		}, false, []Op{OP_INSERT}, []testItem{{Sid: 1, Id: 1, Num: 1, Name: "db"}}},
+		{"no change", nil, func(c *Container) {
+			obj := c.Lookup(1, 1).(*testItem)
+			obj.Num = 2
+			c.Replace(obj)
+			obj.Num = 1
+			c.Replace(obj)
+		}, false, nil, []testItem{{Sid: 1, Id: 1, Num: 1, Name: "db"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakedb.New()
+			num := int64(1)
+			loadTestItemNum(db, &num)
+			c := newTestContainer(t, db, testItemType, WithPreload(true),
+				WithAudit(AuditConfig{Mode: AUDIT_FILE, Dir: t.TempDir()}))
+			if tt.before != nil {
+				tt.before(c)
+			}
+			time.Sleep(time.Millisecond)
+			at := time.Now()
+			time.Sleep(time.Millisecond)
+			tt.after(c)
+			if tt.flush {
+				c.auditor.flush()
+			}
+
+			plan, err := c.cache.RestoreSid(context.Background(), 1, at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ops []Op
+			for _, change := range plan.Changes {
+				ops = append(ops, change.Op)
+			}
+			if !reflect.DeepEqual(ops, tt.ops) {
+				t.Fatalf("plan ops = %v, want %v\n%s", ops, tt.ops, plan.Diff())
+			}
+			if err := plan.Apply(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			var got []testItem
+			for _, obj := range c.LookupObjs(1) {
+				got = append(got, *obj.(*testItem))
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i].Id < got[j].Id })
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("after Apply = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
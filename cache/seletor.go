@@ -1,14 +1,15 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 	"github.com/fengzhu0601/gotools/logger"
-	"reflect"
+	"gorm.io/gorm"
 	"time"
 )
 
 type selectReq struct {
-	sid      uint32        // 主键
+	sid      uint64        // 主键
 	backChan chan struct{} // 回复chan
 }
 
@@ -28,14 +29,14 @@ func newSelector(c *Container) *selector {
 }
 
 // 把一个请求放入waitList中，并等待结果
-func (s *selector) load(sid uint32) bool {
+func (s *selector) load(sid uint64) bool {
 	backChan := make(chan struct{})
 	newReq := &selectReq{
 		sid:      sid,
 		backChan: backChan,
 	}
 	s.waitList <- newReq
-	t := time.NewTimer(3 * time.Second)
+	t := time.NewTimer(s.container.opts.loadTimeout)
 	select {
 	case <-backChan:
 		break
@@ -46,65 +47,96 @@ func (s *selector) load(sid uint32) bool {
 	return true
 }
 
-// 每个selector运行两个gorutine
+// 每个selector运行两个gorutine(cache关闭时退出)
 // 第一个负责接收并打包请求
 // 第二个负责处理打包好的请求列表，批量从数据库加载数据
 func (s *selector) startRun() {
-	go func() {
-		var reqList []*selectReq
-		for {
-			req := <-s.waitList
-			if req != nil {
-				reqList = append(reqList, req)
-			}
-			// 如果加载协程就绪，就把打包好的请求发送
-			if !s.loading && len(reqList) > 0 {
-				s.loading = true
-				pass := make([]*selectReq, len(reqList))
-				copy(pass, reqList)
-				s.doList <- pass
-				reqList = reqList[0:0]
+	s.container.cache.goRun(s.collect)
+	s.container.cache.goRun(s.batchLoad)
+}
+
+// 接收并打包请求
+func (s *selector) collect(ctx context.Context) {
+	var reqList []*selectReq
+	for {
+		var req *selectReq
+		select {
+		case <-ctx.Done():
+			return
+		case req = <-s.waitList:
+		}
+		if req != nil {
+			reqList = append(reqList, req)
+		}
+		// 如果加载协程就绪，就把打包好的请求发送
+		if !s.loading && len(reqList) > 0 {
+			s.loading = true
+			pass := make([]*selectReq, len(reqList))
+			copy(pass, reqList)
+			select {
+			case <-ctx.Done():
+				return
+			case s.doList <- pass:
 			}
+			reqList = reqList[0:0]
 		}
-	}()
+	}
+}
 
-	go func() {
-		var sidList []uint32
-		for {
-			reqDoList := <-s.doList
-			// 处理打包好的请求列表
+// 处理打包好的请求列表
+func (s *selector) batchLoad(ctx context.Context) {
+	var sidList []uint64
+	for {
+		var reqDoList []*selectReq
+		select {
+		case <-ctx.Done():
+			return
+		case reqDoList = <-s.doList:
+		}
+		for _, req := range reqDoList {
+			sidList = append(sidList, req.sid)
+		}
+		err := s.loadFromDB(sidList)
+		if err != nil {
+			logger.Debug("loadFromDB error", s.container.objType, len(sidList))
+		} else {
 			for _, req := range reqDoList {
-				sidList = append(sidList, req.sid)
+				// 通知所有请求者，数据加载完成
+				close(req.backChan)
 			}
-			err := s.loadFromDB(sidList)
-			if err != nil {
-				logger.Debug("loadFromDB error", s.container.objType, len(sidList))
-			} else {
-				for _, req := range reqDoList {
-					// 通知所有请求者，数据加载完成
-					close(req.backChan)
-				}
-				sidList = sidList[0:0]
-				s.loading = false
-				s.waitList <- nil
+			sidList = sidList[0:0]
+			s.loading = false
+			select {
+			case <-ctx.Done():
+				return
+			case s.waitList <- nil:
 			}
 		}
-	}()
+	}
 }
 
-// 从db批量加载数据
-func (s *selector) loadFromDB(sidList []uint32) error {
-	sliceT := reflect.SliceOf(reflect.PtrTo(s.container.objType))
-	slice := reflect.New(sliceT)
-	sliceInt := slice.Interface()
-	tx := s.container.cache.dbCon.Model(sliceInt).Where("sid in (?)", sidList).Find(sliceInt)
-	if tx.Error != nil {
-		return tx.Error
+// 从db批量加载数据(分表时按分表分组查询)
+//
+// 配置从库时优先读从库，本进程最近刷新过的sid读主库
+func (s *selector) loadFromDB(sidList []uint64) error {
+	primarySids, replicaSids := s.container.splitByLag(sidList)
+	err := s.loadSids(primarySids, []*gorm.DB{s.container.db})
+	if err != nil {
+		return err
+	}
+	return s.loadSids(replicaSids, s.container.readDBs())
+}
+
+func (s *selector) loadSids(sidList []uint64, dbs []*gorm.DB) error {
+	for index, sids := range s.container.groupSids(sidList) {
+		table := s.container.shardTable(index)
+		datas, err := s.container.find(dbs, table, sids)
+		if err != nil {
+			return err
+		}
+		// 批量数据载入到容器中
+		// logger.Debug("loadFromDB ", s.container.objType, len(sids))
+		s.container.loadDBData(sids, datas)
 	}
-	// time.Sleep(1 * time.Second)
-	datas := slice.Elem()
-	// 批量数据载入到容器中
-	// logger.Debug("loadFromDB ", s.container.objType, len(sidList))
-	s.container.loadDBData(sidList, datas)
 	return nil
 }
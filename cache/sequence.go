@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/fengzhu0601/gotools/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	sequenceTable        = "cache_sequence"
+	defaultSequenceBlock = 100
+)
+
+// 每个玩家每个容器已分配出去的id(下一个可分配的id)
+type Sequence struct {
+	Name  string `gorm:"primaryKey;size:64"` // 容器表名
+	Sid   uint64 `gorm:"primaryKey"`
+	Value uint32 // 下一个可分配的id
+}
+
+// 持久化的id分配器：每次从序列表中预留一段id，分配过的id不会再使用(即使对应的obj已删除)
+type sequence struct {
+	container *Container
+	block     uint32               // 每次预留的id数量
+	lock      sync.Mutex           // 只保护blocks，预留id时持有各个sid自己的锁
+	blocks    map[uint64]*seqBlock // sid -> 预留的id段
+}
+
+// 预留的id段[next, end)
+type seqBlock struct {
+	lock sync.Mutex
+	next uint32
+	end  uint32
+}
+
+// 开启持久化的id分配器，通过NextId分配第二主键(代替GetNextUid的扫描)，block为每次预留的id数量(<=0时为100)
+func WithSequence(block int) ContainerOption {
+	return func(o *containerOptions) {
+		if block <= 0 {
+			block = defaultSequenceBlock
+		}
+		o.sequenceBlock = uint32(block)
+	}
+}
+
+func newSequence(container *Container, block uint32) *sequence {
+	err := container.db.Table(sequenceTable).AutoMigrate(&Sequence{})
+	if err != nil {
+		panic(err)
+	}
+	return &sequence{
+		container: container,
+		block:     block,
+		blocks:    make(map[uint64]*seqBlock),
+	}
+}
+
+// 分配下一个id(不同sid并发分配，预留id段时不会互相阻塞)
+func (s *sequence) next(sid uint64) (uint32, error) {
+	s.lock.Lock()
+	b := s.blocks[sid]
+	if b == nil {
+		b = &seqBlock{}
+		s.blocks[sid] = b
+	}
+	s.lock.Unlock()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.next >= b.end {
+		err := s.reserve(sid, b)
+		if err != nil {
+			return 0, err
+		}
+	}
+	id := b.next
+	b.next++
+	return id, nil
+}
+
+// 从序列表预留一段id，起点不小于缓存中已有的最大id+1(兼容之前用GetNextUid分配的数据)
+func (s *sequence) reserve(sid uint64, b *seqBlock) error {
+	c := s.container
+	floor := c.getCargo(sid, false).GetNextUid()
+	var next, end uint32
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		row := &Sequence{}
+		err := tx.Table(sequenceTable).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("name = ? AND sid = ?", c.tableName, sid).Take(row).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			row = &Sequence{Name: c.tableName, Sid: sid, Value: 1}
+		} else if err != nil {
+			return err
+		}
+		if row.Value < floor {
+			row.Value = floor
+		}
+		next = row.Value
+		row.Value += s.block
+		end = row.Value
+		return tx.Table(sequenceTable).Save(row).Error
+	})
+	if err != nil {
+		logger.Error("cache sequence reserve error", c.objType, sid, err)
+		return err
+	}
+	b.next, b.end = next, end
+	return nil
+}
+
+// 释放内存中预留的id段(cell回收时调用，剩余的id不会再使用)
+func (s *sequence) drop(sid uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.blocks, sid)
+}
+
+// 分配某个玩家的下一个id(容器需开启WithSequence)，并发调用也不会重复
+func (c *Container) NextId(sid uint64) (uint32, error) {
+	if c.sequence == nil {
+		return 0, fmt.Errorf("cache sequence not enabled, objType:%s", c.objType)
+	}
+	return c.sequence.next(sid)
+}
+
+// 分配第二主键：开启WithSequence时使用NextId，否则使用GetNextUid
+func (c *Container) nextUid(sid uint64) (uint32, error) {
+	if c.sequence != nil {
+		return c.sequence.next(sid)
+	}
+	return c.GetNextUid(sid), nil
+}
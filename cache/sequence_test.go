@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fengzhu0601/gotools/cache/internal/fakedb"
+)
+
+// 假的序列表：sid -> 下一个可分配的id，block不为空时读取该sid的序列会阻塞到关闭
+type fakeSequence struct {
+	lock   sync.Mutex
+	values map[uint64]uint32
+	block  map[uint64]chan struct{}
+}
+
+func newFakeSequence(db *fakedb.DB) *fakeSequence {
+	fs := &fakeSequence{values: make(map[uint64]uint32), block: make(map[uint64]chan struct{})}
+	db.QueryFn = func(query string, args []interface{}) (*fakedb.Rows, error) {
+		if !strings.Contains(query, sequenceTable) {
+			return nil, nil
+		}
+		sid := args[1].(uint64)
+		fs.lock.Lock()
+		ch := fs.block[sid]
+		fs.lock.Unlock()
+		if ch != nil {
+			<-ch
+		}
+		fs.lock.Lock()
+		defer fs.lock.Unlock()
+		value, exit := fs.values[sid]
+		if !exit {
+			return nil, nil
+		}
+		return &fakedb.Rows{Columns: []string{"name", "sid", "value"}, Values: [][]driver.Value{{args[0], int64(sid), int64(value)}}}, nil
+	}
+	db.ExecFn = func(query string, args []interface{}) (int64, error) {
+		if !strings.Contains(query, "UPDATE `"+sequenceTable+"`") {
+			return 0, nil
+		}
+		fs.lock.Lock()
+		defer fs.lock.Unlock()
+		fs.values[args[2].(uint64)] = args[0].(uint32)
+		return 1, nil
+	}
+	return fs
+}
+
+func (fs *fakeSequence) value(sid uint64) uint32 {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return fs.values[sid]
+}
+
+func TestSequenceReserve(t *testing.T) {
+	tests := []struct {
+		name     string
+		stored   uint32 // 序列表中的值(0表示没有记录)
+		cached   []uint32
+		ids      []uint32
+		reserves int
+		value    uint32 // 最后序列表中的值
+	}{
+		{"new sid", 0, nil, []uint32{1, 2}, 1, 3},
+		{"block exhausted", 0, nil, []uint32{1, 2, 3}, 2, 5},
+		{"stored", 10, nil, []uint32{10, 11, 12}, 2, 14},
+		{"floor from cached ids", 0, []uint32{5, 7}, []uint32{8, 9, 10}, 2, 12},
+		{"stored above cached", 20, []uint32{5}, []uint32{20}, 1, 22},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakedb.New()
+			c := newTestContainer(t, db, testItemType, WithPreload(true), WithSequence(2))
+			fs := newFakeSequence(db)
+			if tt.stored != 0 {
+				fs.values[1] = tt.stored
+			}
+			for _, id := range tt.cached {
+				c.Replace(&testItem{Sid: 1, Id: id})
+			}
+			db.Reset()
+			ids := make([]uint32, 0)
+			for range tt.ids {
+				id, err := c.NextId(1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, id)
+			}
+			if !reflect.DeepEqual(ids, tt.ids) {
+				t.Fatalf("NextId() = %v, want %v", ids, tt.ids)
+			}
+			if got := len(db.QueriesOf(sequenceTable)); got != tt.reserves {
+				t.Fatalf("reserves = %d, want %d", got, tt.reserves)
+			}
+			if got := fs.value(1); got != tt.value {
+				t.Fatalf("stored value = %d, want %d", got, tt.value)
+			}
+		})
+	}
+}
+
+// 回收cell后丢弃预留的id段，剩余的id不会再分配
+func TestSequenceDrop(t *testing.T) {
+	db := fakedb.New()
+	c := newTestContainer(t, db, testItemType, WithPreload(true), WithSequence(10))
+	newFakeSequence(db)
+	if id, _ := c.NextId(1); id != 1 {
+		t.Fatalf("NextId() = %d, want 1", id)
+	}
+	c.sequence.drop(1)
+	if id, _ := c.NextId(1); id != 11 {
+		t.Fatalf("NextId() after drop = %d, want 11", id)
+	}
+}
+
+// 同一sid并发分配不会重复，某个sid预留id时不阻塞其他sid
+func TestSequenceConcurrent(t *testing.T) {
+	db := fakedb.New()
+	c := newTestContainer(t, db, testItemType, WithPreload(true), WithSequence(3))
+	fs := newFakeSequence(db)
+
+	blocked := make(chan struct{})
+	fs.block[9] = blocked
+	done := make(chan uint32)
+	go func() {
+		id, _ := c.NextId(9)
+		done <- id
+	}()
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	seen := make(map[uint64]map[uint32]bool)
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(sid uint64) {
+			defer wg.Done()
+			id, err := c.NextId(sid)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if seen[sid] == nil {
+				seen[sid] = make(map[uint32]bool)
+			}
+			if seen[sid][id] {
+				t.Errorf("sid %d id %d allocated twice", sid, id)
+			}
+			seen[sid][id] = true
+		}(uint64(i%2 + 1))
+	}
+	wg.Wait()
+	if len(seen[1]) != 20 || len(seen[2]) != 20 {
+		t.Fatalf("allocated = %d %d, want 20", len(seen[1]), len(seen[2]))
+	}
+	close(blocked)
+	if id := <-done; id != 1 {
+		t.Fatalf("blocked sid NextId() = %d, want 1", id)
+	}
+}
+
+func TestNextIdNotEnabled(t *testing.T) {
+	c := newTestContainer(t, fakedb.New(), testItemType, WithPreload(true))
+	if _, err := c.NextId(1); err == nil {
+		t.Fatalf("NextId() without sequence no error")
+	}
+}
@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+const defaultShardFormat = "%s_%02d"
+
+// 分表配置(同一个容器按sid分散到多个物理表)
+type ShardConfig struct {
+	Num    int                  // 分表数量
+	Func   func(sid uint64) int // 自定义分表函数，返回[0, Num)的分表序号(默认sid % Num)
+	Format string               // 分表名格式(默认"%s_%02d"，如item_00)
+}
+
+// 解析obj类型对应的表名
+func parseTableName(db *gorm.DB, objType reflect.Type) string {
+	stmt := &gorm.Statement{DB: db}
+	err := stmt.Parse(reflect.New(objType).Interface())
+	if err != nil {
+		panic(err)
+	}
+	return stmt.Schema.Table
+}
+
+// sid所在的分表序号(自定义分表函数返回超出[0, Num)的序号时panic，避免写入错误的表)
+func (s *ShardConfig) Index(sid uint64) int {
+	if s.Func != nil {
+		index := s.Func(sid)
+		if index < 0 || index >= s.Num {
+			panic(fmt.Sprintf("shard func index out of range, sid:%d index:%d num:%d", sid, index, s.Num))
+		}
+		return index
+	}
+	return int(sid % uint64(s.Num))
+}
+
+// 分表序号对应的表名(合服等工具按相同的规则读写分表)
+func (s *ShardConfig) Table(tableName string, index int) string {
+	format := s.Format
+	if format == "" {
+		format = defaultShardFormat
+	}
+	return fmt.Sprintf(format, tableName, index)
+}
+
+// sid所在的分表序号
+func (c *Container) shardIndex(sid uint64) int {
+	if c.shard == nil {
+		return 0
+	}
+	return c.shard.Index(sid)
+}
+
+// 分表序号对应的表名
+func (c *Container) shardTable(index int) string {
+	if c.shard == nil {
+		return c.tableName
+	}
+	return c.shard.Table(c.tableName, index)
+}
+
+// sid所在的表名
+func (c *Container) tableOf(sid uint64) string {
+	return c.shardTable(c.shardIndex(sid))
+}
+
+// 容器对应的所有表名
+func (c *Container) tables() []string {
+	if c.shard == nil {
+		return []string{c.tableName}
+	}
+	tables := make([]string, c.shard.Num)
+	for i := 0; i < c.shard.Num; i++ {
+		tables[i] = c.shardTable(i)
+	}
+	return tables
+}
+
+// 按分表对sid分组
+func (c *Container) groupSids(sidList []uint64) map[int][]uint64 {
+	groups := make(map[int][]uint64)
+	for _, sid := range sidList {
+		index := c.shardIndex(sid)
+		groups[index] = append(groups[index], sid)
+	}
+	return groups
+}
+
+// 按分表对obj或key分组(第一个字段为sid)
+func (c *Container) groupBySid(list []interface{}) map[int][]interface{} {
+	if c.shard == nil {
+		// 不分表时不读取sid(全局容器的第一个字段不是sid)
+		return map[int][]interface{}{0: list}
+	}
+	groups := make(map[int][]interface{})
+	for _, item := range list {
+		// 待写入的obj和key在写入内存时已检查过sid
+		sid, _ := sidValue(reflect.ValueOf(item).Elem().Field(0))
+		index := c.shardIndex(sid)
+		groups[index] = append(groups[index], item)
+	}
+	return groups
+}
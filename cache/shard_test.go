@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShardIndex(t *testing.T) {
+	tests := []struct {
+		name  string
+		shard *ShardConfig
+		sid   uint64
+		want  int
+		panic bool
+	}{
+		{"no shard", nil, 123, 0, false},
+		{"mod", &ShardConfig{Num: 4}, 10, 2, false},
+		{"mod zero", &ShardConfig{Num: 4}, 8, 0, false},
+		{"mod big sid", &ShardConfig{Num: 10}, 1<<63 + 7, int((1<<63 + 7) % 10), false},
+		{"custom", &ShardConfig{Num: 4, Func: func(sid uint64) int { return int(sid / 100 % 4) }}, 250, 2, false},
+		{"custom negative", &ShardConfig{Num: 4, Func: func(sid uint64) int { return -1 }}, 1, 0, true},
+		{"custom over num", &ShardConfig{Num: 4, Func: func(sid uint64) int { return 4 }}, 1, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); (r != nil) != tt.panic {
+					t.Fatalf("shardIndex(%d) panic = %v, want panic %v", tt.sid, r, tt.panic)
+				}
+			}()
+			c := &Container{shard: tt.shard}
+			if got := c.shardIndex(tt.sid); got != tt.want {
+				t.Fatalf("shardIndex(%d) = %d, want %d", tt.sid, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShardTables(t *testing.T) {
+	tests := []struct {
+		name  string
+		shard *ShardConfig
+		want  []string
+	}{
+		{"no shard", nil, []string{"item"}},
+		{"default format", &ShardConfig{Num: 3}, []string{"item_00", "item_01", "item_02"}},
+		{"custom format", &ShardConfig{Num: 2, Format: "%s%d"}, []string{"item0", "item1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Container{shard: tt.shard, tableName: "item"}
+			if got := c.tables(); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("tables() = %v, want %v", got, tt.want)
+			}
+			if got := c.tableOf(uint64(len(tt.want) + 1)); got != tt.want[1%len(tt.want)] {
+				t.Fatalf("tableOf() = %s, want %s", got, tt.want[1%len(tt.want)])
+			}
+		})
+	}
+}
+
+func TestGroupSids(t *testing.T) {
+	tests := []struct {
+		name  string
+		shard *ShardConfig
+		sids  []uint64
+		want  map[int][]uint64
+	}{
+		{"no shard", nil, []uint64{1, 2, 3}, map[int][]uint64{0: {1, 2, 3}}},
+		{"shard", &ShardConfig{Num: 2}, []uint64{1, 2, 3, 4}, map[int][]uint64{0: {2, 4}, 1: {1, 3}}},
+		{"empty", &ShardConfig{Num: 2}, nil, map[int][]uint64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Container{shard: tt.shard}
+			if got := c.groupSids(tt.sids); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("groupSids(%v) = %v, want %v", tt.sids, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+const sidLockNum = 64
+
+// obj最近一次的值(json)，调用方原地修改obj后再Replace时用来还原变更前的值
+//
+// 有订阅者或开启变更记录时启用：加载和写入时更新，cell回收时清理
+type snapshots struct {
+	enabled int32 // 是否启用(订阅后启用)
+	lock    sync.Mutex
+	data    map[uint64]map[string][]byte
+}
+
+// 同一sid的写入(查找旧值、修改载体、发出事件)串行执行，保证事件按写入顺序送达
+//
+// 按sid取模分段加锁；加锁顺序为updater锁在前
+func (c *Container) lockSid(sid uint64) *sync.Mutex {
+	lock := &c.sidLocks[sid%sidLockNum]
+	lock.Lock()
+	return lock
+}
+
+func (c *Container) snapEnabled() bool {
+	return c.auditor != nil || atomic.LoadInt32(&c.snaps.enabled) == 1
+}
+
+// 启用快照，并记录内存中已有obj的值
+//
+// 启用前已经原地修改但还没有Replace的obj无法还原，事件的Old与New相同
+func (c *Container) enableSnaps() {
+	if !atomic.CompareAndSwapInt32(&c.snaps.enabled, 0, 1) || c.auditor != nil {
+		return
+	}
+	c.cells.Range(func(k any, v any) bool {
+		sid := k.(uint64)
+		lock := c.lockSid(sid)
+		objs := make([]interface{}, 0)
+		v.(*Cell).cargo.CollectAllObjs(&objs)
+		for _, obj := range objs {
+			c.seedSnap(sid, obj, false)
+		}
+		lock.Unlock()
+		return true
+	})
+}
+
+// 加载时记录obj的值，replace为false时不覆盖已有的值
+func (c *Container) seedSnap(sid uint64, obj interface{}, replace bool) {
+	if !c.snapEnabled() {
+		return
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return
+	}
+	keyStr := joinKeys(c.keysOf(obj))
+	c.snaps.lock.Lock()
+	defer c.snaps.lock.Unlock()
+	if c.snaps.data == nil {
+		c.snaps.data = make(map[uint64]map[string][]byte)
+	}
+	snaps := c.snaps.data[sid]
+	if snaps == nil {
+		snaps = make(map[string][]byte)
+		c.snaps.data[sid] = snaps
+	}
+	if _, exit := snaps[keyStr]; exit && !replace {
+		return
+	}
+	snaps[keyStr] = data
+}
+
+// 记录obj写入后的值，返回变更前的obj：old与obj是同一个对象时，用之前记录的值还原一个副本
+func (c *Container) swapSnap(sid uint64, keys []interface{}, old interface{}, obj interface{}) interface{} {
+	if !c.snapEnabled() {
+		return old
+	}
+	keyStr := joinKeys(keys)
+	var data []byte
+	if obj != nil {
+		data, _ = json.Marshal(obj)
+	}
+	c.snaps.lock.Lock()
+	if c.snaps.data == nil {
+		c.snaps.data = make(map[uint64]map[string][]byte)
+	}
+	snaps := c.snaps.data[sid]
+	prev, exit := snaps[keyStr]
+	if data != nil {
+		if snaps == nil {
+			snaps = make(map[string][]byte)
+			c.snaps.data[sid] = snaps
+		}
+		snaps[keyStr] = data
+	} else if snaps != nil {
+		delete(snaps, keyStr)
+	}
+	c.snaps.lock.Unlock()
+
+	if old == nil || old != obj || !exit {
+		return old
+	}
+	before := reflect.New(c.objType)
+	if json.Unmarshal(prev, before.Interface()) != nil {
+		return old
+	}
+	return before.Interface()
+}
+
+// 重新加载后没有未写入变更的cell，以数据库的值作为快照
+func (c *Container) reseedSnaps(sid uint64, cell *Cell, stored map[string]interface{}) {
+	if !c.snapEnabled() {
+		return
+	}
+	lock := c.lockSid(sid)
+	defer lock.Unlock()
+	if cell.isChange() {
+		return
+	}
+	for _, obj := range stored {
+		c.seedSnap(sid, obj, true)
+	}
+}
+
+// 玩家数据从内存回收后，清理记录的值
+func (c *Container) dropSnaps(sid uint64) {
+	c.snaps.lock.Lock()
+	delete(c.snaps.data, sid)
+	c.snaps.lock.Unlock()
+}
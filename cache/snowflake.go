@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	snowflakeEpoch    = 1672531200000 // 起始时间(2023-01-01 00:00:00 UTC)的毫秒数
+	snowflakeNodeBits = 10            // 节点位数
+	snowflakeSeqBits  = 12            // 毫秒内序号位数
+	snowflakeMaxNode  = 1<<snowflakeNodeBits - 1
+	snowflakeMaxSeq   = 1<<snowflakeSeqBits - 1
+)
+
+// 雪花id生成器：41位毫秒时间 + 10位节点 + 12位序号，用于跨玩家全局唯一的id(如拍卖单、邮件)
+//
+// 不同进程需要使用不同的节点号，时钟回拨时等待追上上次的时间
+type Snowflake struct {
+	lock   sync.Mutex
+	node   int64
+	lastMs int64
+	seq    int64
+	now    func() int64        // 当前毫秒数(测试时可替换)
+	sleep  func(time.Duration) // 等待时钟追上(测试时可替换)
+}
+
+func NewSnowflake(node int64) (*Snowflake, error) {
+	if node < 0 || node > snowflakeMaxNode {
+		return nil, fmt.Errorf("snowflake node must be in [0, %d]", snowflakeMaxNode)
+	}
+	return &Snowflake{
+		node:  node,
+		now:   func() int64 { return time.Now().UnixMilli() },
+		sleep: time.Sleep,
+	}, nil
+}
+
+// 生成下一个id
+func (s *Snowflake) Next() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	for now < s.lastMs {
+		s.sleep(time.Duration(s.lastMs-now) * time.Millisecond)
+		now = s.now()
+	}
+	if now == s.lastMs {
+		s.seq = (s.seq + 1) & snowflakeMaxSeq
+		if s.seq == 0 {
+			// 当前毫秒的序号用完，等到下一毫秒
+			for now <= s.lastMs {
+				now = s.now()
+			}
+		}
+	} else {
+		s.seq = 0
+	}
+	s.lastMs = now
+	return (now-snowflakeEpoch)<<(snowflakeNodeBits+snowflakeSeqBits) | s.node<<snowflakeSeqBits | s.seq
+}
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+// 可控的时钟：每次读取返回列表中的下一个值(用完后保持最后一个值)，等待时前进到等待结束的时间
+type fakeClock struct {
+	times []int64
+	slept []time.Duration
+}
+
+func (fc *fakeClock) now() int64 {
+	ms := fc.times[0]
+	if len(fc.times) > 1 {
+		fc.times = fc.times[1:]
+	}
+	return ms
+}
+
+func (fc *fakeClock) sleep(d time.Duration) {
+	fc.slept = append(fc.slept, d)
+}
+
+func newTestSnowflake(t *testing.T, node int64, times ...int64) (*Snowflake, *fakeClock) {
+	s, err := NewSnowflake(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := &fakeClock{times: times}
+	s.now, s.sleep = fc.now, fc.sleep
+	return s, fc
+}
+
+// 拆分雪花id为毫秒时间、节点和序号
+func splitSnowflake(id int64) (int64, int64, int64) {
+	return id>>(snowflakeNodeBits+snowflakeSeqBits) + snowflakeEpoch, id >> snowflakeSeqBits & snowflakeMaxNode, id & snowflakeMaxSeq
+}
+
+func TestSnowflake(t *testing.T) {
+	const ms = snowflakeEpoch + 1000
+	tests := []struct {
+		name  string
+		times []int64
+		seq   int64 // Next之前的序号
+		want  [3]int64
+		slept []time.Duration
+	}{
+		{"new ms", []int64{ms + 1}, 5, [3]int64{ms + 1, 3, 0}, nil},
+		{"same ms", []int64{ms}, 5, [3]int64{ms, 3, 6}, nil},
+		{"seq exhausted", []int64{ms, ms, ms, ms + 1}, snowflakeMaxSeq, [3]int64{ms + 1, 3, 0}, nil},
+		{"clock rollback", []int64{ms - 3, ms}, 5, [3]int64{ms, 3, 6}, []time.Duration{3 * time.Millisecond}},
+		{"clock rollback twice", []int64{ms - 3, ms - 1, ms + 2}, 5, [3]int64{ms + 2, 3, 0}, []time.Duration{3 * time.Millisecond, time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fc := newTestSnowflake(t, 3, tt.times...)
+			s.lastMs, s.seq = ms, tt.seq
+			msGot, node, seq := splitSnowflake(s.Next())
+			if got := [3]int64{msGot, node, seq}; got != tt.want {
+				t.Fatalf("Next() = %v, want %v", got, tt.want)
+			}
+			if len(fc.slept) != len(tt.slept) {
+				t.Fatalf("slept = %v, want %v", fc.slept, tt.slept)
+			}
+			for i := range tt.slept {
+				if fc.slept[i] != tt.slept[i] {
+					t.Fatalf("slept = %v, want %v", fc.slept, tt.slept)
+				}
+			}
+		})
+	}
+}
+
+// 时钟回拨后生成的id仍然递增
+func TestSnowflakeIncreasing(t *testing.T) {
+	const ms = snowflakeEpoch + 1000
+	s, _ := newTestSnowflake(t, 1, ms, ms, ms+5, ms+2, ms+5, ms+5, ms+6)
+	last := int64(0)
+	for i := 0; i < 5; i++ {
+		id := s.Next()
+		if id <= last {
+			t.Fatalf("id %d <= last %d", id, last)
+		}
+		last = id
+	}
+}
+
+func TestNewSnowflakeNode(t *testing.T) {
+	for _, node := range []int64{-1, snowflakeMaxNode + 1} {
+		if _, err := NewSnowflake(node); err == nil {
+			t.Fatalf("NewSnowflake(%d) no error", node)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package cache
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fengzhu0601/gotools/cache/cargo"
+)
+
+const defaultSubscribeBuffer = 1024
+
+// 变更类型
+type Op byte
+
+const (
+	OP_INSERT Op = 1 // 插入
+	OP_UPDATE Op = 2 // 更新
+	OP_DELETE Op = 3 // 删除
+)
+
+// 缓冲区满时的处理策略
+type DeliverPolicy byte
+
+const (
+	DELIVER_DROP  DeliverPolicy = 0 // 丢弃新事件，不阻塞写入(默认)
+	DELIVER_BLOCK DeliverPolicy = 1 // 阻塞写入，直到订阅者处理完
+)
+
+// 缓存写入事件
+//
+// 调用方原地修改obj后再Replace时，Old是按修改前的值(json)还原的副本(json中忽略的字段为零值)，New是缓存中的obj
+type ChangeEvent struct {
+	ObjType reflect.Type
+	Sid     uint64
+	Keys    []interface{} // 除sid外的主键值(按cargo.KeyOf统一类型，可用于比较)
+	Op      Op            // 变更类型
+	Old     interface{}   // 变更前的obj(插入时为nil)
+	New     interface{}   // 变更后的obj(删除时为nil)
+	Reason  string        // 调用方提供的原因
+}
+
+// 订阅配置项
+type SubscribeOption func(*subscription)
+
+// 每个worker的缓冲区大小
+func SubscribeBuffer(size int) SubscribeOption {
+	return func(s *subscription) {
+		s.buffer = size
+	}
+}
+
+// 并发处理事件的worker数量，同一个sid的事件总是由同一个worker按顺序处理
+func SubscribeWorkers(num int) SubscribeOption {
+	return func(s *subscription) {
+		s.workers = num
+	}
+}
+
+// 缓冲区满时的处理策略
+func SubscribePolicy(policy DeliverPolicy) SubscribeOption {
+	return func(s *subscription) {
+		s.policy = policy
+	}
+}
+
+// 一个订阅者
+type subscription struct {
+	buffer  int
+	workers int
+	policy  DeliverPolicy
+	chans   []chan ChangeEvent
+	dropNum uint64 // 丢弃的事件数量
+
+	lock   sync.RWMutex  // 投递时持有读锁，关闭channel时持有写锁
+	done   chan struct{} // 取消订阅时关闭，唤醒阻塞中的投递
+	closed bool
+	once   sync.Once
+	cancel func() // 取消订阅(只执行一次)
+}
+
+// 容器的所有订阅者
+type subscribers struct {
+	lock sync.RWMutex
+	list []*subscription
+}
+
+// 订阅容器的写入事件，返回取消订阅的函数
+//
+// 事件在Replace/Delete/DeleteObjs时发出，同一sid的写入串行执行，事件按写入顺序送达；
+// 订阅者处理慢时按DeliverPolicy丢弃或阻塞，默认丢弃，不会拖慢游戏逻辑；
+// 订阅后会记录内存中每个obj的json，用于还原原地修改前的值
+func (c *Container) Subscribe(fn func(ev ChangeEvent), opts ...SubscribeOption) func() {
+	sub := &subscription{
+		buffer:  defaultSubscribeBuffer,
+		workers: 1,
+	}
+	for _, opt := range opts {
+		opt(sub)
+	}
+	if sub.workers <= 0 {
+		sub.workers = 1
+	}
+	for i := 0; i < sub.workers; i++ {
+		ch := make(chan ChangeEvent, sub.buffer)
+		sub.chans = append(sub.chans, ch)
+		go func() {
+			for ev := range ch {
+				fn(ev)
+			}
+		}()
+	}
+	return c.addSubscription(sub)
+}
+
+// 以channel的形式订阅容器的写入事件，返回事件channel和取消订阅的函数(取消后channel会关闭)
+func (c *Container) SubscribeChan(size int, policy DeliverPolicy) (<-chan ChangeEvent, func()) {
+	ch := make(chan ChangeEvent, size)
+	sub := &subscription{
+		buffer:  size,
+		workers: 1,
+		policy:  policy,
+		chans:   []chan ChangeEvent{ch},
+	}
+	return ch, c.addSubscription(sub)
+}
+
+func (c *Container) addSubscription(sub *subscription) func() {
+	sub.done = make(chan struct{})
+	c.enableSnaps()
+	c.subscribers.lock.Lock()
+	c.subscribers.list = append(c.subscribers.list, sub)
+	c.subscribers.lock.Unlock()
+
+	sub.cancel = func() {
+		sub.once.Do(func() {
+			c.removeSubscription(sub)
+		})
+	}
+	return sub.cancel
+}
+
+func (c *Container) removeSubscription(sub *subscription) {
+	c.subscribers.lock.Lock()
+	for i, s := range c.subscribers.list {
+		if s == sub {
+			c.subscribers.list = append(c.subscribers.list[:i:i], c.subscribers.list[i+1:]...)
+			break
+		}
+	}
+	c.subscribers.lock.Unlock()
+
+	// 先唤醒阻塞的投递，等正在进行的投递结束后再关闭channel
+	close(sub.done)
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+	sub.closed = true
+	for _, ch := range sub.chans {
+		close(ch)
+	}
+}
+
+// 取消所有订阅(cache关闭时用)
+func (c *Container) unsubscribeAll() {
+	c.subscribers.lock.RLock()
+	list := c.subscribers.list
+	c.subscribers.lock.RUnlock()
+	for _, sub := range list {
+		sub.cancel()
+	}
+}
+
+// 发出写入事件(同时更新观察者和写入变更记录)，调用时持有sid的写入锁
+func (c *Container) notify(op Op, sid uint64, keys []interface{}, old interface{}, obj interface{}, reason string) {
+	c.observe(sid, keys, old, obj)
+	c.emit(op, sid, keys, old, obj, reason)
+}
+
+// 写入变更记录并投递给订阅者(不更新观察者)，调用时持有sid的写入锁
+func (c *Container) emit(op Op, sid uint64, keys []interface{}, old interface{}, obj interface{}, reason string) {
+	old = c.swapSnap(sid, keys, old, obj)
+	if c.auditor != nil {
+		c.auditor.record(op, sid, keys, old, obj, reason)
+	}
+	// 取消订阅时会重新分配list，这里拿到的切片不会被修改；在锁外投递，阻塞的订阅者不会卡住取消订阅
+	c.subscribers.lock.RLock()
+	list := c.subscribers.list
+	c.subscribers.lock.RUnlock()
+	if len(list) == 0 {
+		return
+	}
+	ev := ChangeEvent{
+		ObjType: c.objType,
+		Sid:     sid,
+		Keys:    keys,
+		Op:      op,
+		Old:     old,
+		New:     obj,
+		Reason:  reason,
+	}
+	for _, sub := range list {
+		sub.deliver(ev)
+	}
+}
+
+func (s *subscription) deliver(ev ChangeEvent) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return
+	}
+	ch := s.chans[int(ev.Sid%uint64(len(s.chans)))]
+	if s.policy == DELIVER_BLOCK {
+		select {
+		case ch <- ev:
+		case <-s.done:
+		}
+		return
+	}
+	select {
+	case ch <- ev:
+	default:
+		atomic.AddUint64(&s.dropNum, 1)
+	}
+}
+
+// 发出Replace对应的插入或更新事件
+func (c *Container) notifyReplace(sid uint64, keys []interface{}, old interface{}, obj interface{}, reason string) {
+	if old == nil {
+		c.notify(OP_INSERT, sid, keys, nil, obj, reason)
+	} else {
+		c.notify(OP_UPDATE, sid, keys, old, obj, reason)
+	}
+}
+
+// 除sid外的主键值(全局容器为所有主键)，整数统一转换成uint64，见cargo.KeyOf
+func (c *Container) keysOf(obj interface{}) []interface{} {
+	v := reflect.ValueOf(obj).Elem()
+	start := 1
+	if c.opts.global {
+		start = 0
+	}
+	keys := make([]interface{}, 0, c.keyNum-start)
+	for i := start; i < c.keyNum; i++ {
+		keys = append(keys, cargo.KeyOf(v.Field(i)))
+	}
+	return keys
+}
+
+// 订阅者丢弃的事件总数
+func (c *Container) eventDropNum() uint64 {
+	c.subscribers.lock.RLock()
+	defer c.subscribers.lock.RUnlock()
+	var num uint64 = 0
+	for _, sub := range c.subscribers.list {
+		num += atomic.LoadUint64(&sub.dropNum)
+	}
+	return num
+}
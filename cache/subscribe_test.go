@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fengzhu0601/gotools/cache/internal/fakedb"
+)
+
+// 数据库中sid 1的数据
+func loadTestItems(db *fakedb.DB) {
+	db.QueryFn = func(query string, args []interface{}) (*fakedb.Rows, error) {
+		if !strings.Contains(query, "FROM `test_item`") {
+			return nil, nil
+		}
+		return &fakedb.Rows{
+			Columns: []string{"sid", "id", "num", "name"},
+			Values:  [][]driver.Value{{int64(1), int64(1), int64(1), "db"}},
+		}, nil
+	}
+}
+
+func TestChangeEventOld(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(c *Container) interface{} // 返回写入后缓存中的obj
+		op    Op
+		old   *testItem
+	}{
+		{"in place after load", func(c *Container) interface{} {
+			obj := c.Lookup(1, 1).(*testItem)
+			obj.Num = 2
+			c.Replace(obj)
+			return obj
+		}, OP_UPDATE, &testItem{Sid: 1, Id: 1, Num: 1, Name: "db"}},
+		{"in place twice", func(c *Container) interface{} {
+			obj := c.Lookup(1, 1).(*testItem)
+			obj.Num = 2
+			c.Replace(obj)
+			obj.Num = 3
+			c.Replace(obj)
+			return obj
+		}, OP_UPDATE, &testItem{Sid: 1, Id: 1, Num: 2, Name: "db"}},
+		{"new obj", func(c *Container) interface{} {
+			obj := &testItem{Sid: 1, Id: 1, Num: 2}
+			c.Replace(obj)
+			return obj
+		}, OP_UPDATE, &testItem{Sid: 1, Id: 1, Num: 1, Name: "db"}},
+		{"insert", func(c *Container) interface{} {
+			obj := &testItem{Sid: 1, Id: 2, Num: 2}
+			c.Replace(obj)
+			return obj
+		}, OP_INSERT, nil},
+		{"delete after in place", func(c *Container) interface{} {
+			obj := c.Lookup(1, 1).(*testItem)
+			obj.Num = 2
+			c.Replace(obj)
+			c.Delete(obj)
+			return nil
+		}, OP_DELETE, &testItem{Sid: 1, Id: 1, Num: 2, Name: "db"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakedb.New()
+			loadTestItems(db)
+			c := newTestContainer(t, db, testItemType, WithPreload(true))
+			ch, cancel := c.SubscribeChan(10, DELIVER_BLOCK)
+			defer cancel()
+			obj := tt.write(c)
+
+			var ev ChangeEvent
+			for len(ch) > 0 {
+				ev = <-ch
+			}
+			if ev.Op != tt.op {
+				t.Fatalf("Op = %d, want %d", ev.Op, tt.op)
+			}
+			if tt.old == nil {
+				if ev.Old != nil {
+					t.Fatalf("Old = %+v, want nil", ev.Old)
+				}
+			} else if !reflect.DeepEqual(ev.Old, tt.old) {
+				t.Fatalf("Old = %+v, want %+v", ev.Old, tt.old)
+			}
+			if obj != nil && ev.New != obj {
+				t.Fatalf("New = %p, want cached obj %p", ev.New, obj)
+			}
+		})
+	}
+}
+
+// 并发写入同一sid时，事件顺序与写入顺序一致：每个事件的Old是上一个事件的New，最后的New是缓存中的obj
+func TestEventOrder(t *testing.T) {
+	db := fakedb.New()
+	c := newTestContainer(t, db, testItemType, WithPreload(true))
+	var lock sync.Mutex
+	events := make(map[uint64][]ChangeEvent)
+	cancel := c.Subscribe(func(ev ChangeEvent) {
+		lock.Lock()
+		events[ev.Sid] = append(events[ev.Sid], ev)
+		lock.Unlock()
+	}, SubscribeWorkers(2), SubscribePolicy(DELIVER_BLOCK))
+	defer cancel()
+
+	const writers, writes = 8, 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				c.Replace(&testItem{Sid: uint64(1 + i%2), Id: 1, Num: w*writes + i})
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		lock.Lock()
+		num := len(events[1]) + len(events[2])
+		lock.Unlock()
+		if num == writers*writes {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("received %d events, want %d", num, writers*writes)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for sid, list := range events {
+		var prev interface{}
+		for i, ev := range list {
+			if ev.Old != prev {
+				t.Fatalf("sid %d event %d Old = %+v, want previous New %+v", sid, i, ev.Old, prev)
+			}
+			prev = ev.New
+		}
+		if got := c.Lookup(sid, 1); got != prev {
+			t.Fatalf("sid %d Lookup() = %+v, want last New %+v", sid, got, prev)
+		}
+	}
+}
@@ -1,34 +1,112 @@
 package cache
 
 import (
+	"errors"
+	"sync"
+	"time"
+
 	"github.com/fengzhu0601/gotools/cache/bulk"
 	"github.com/fengzhu0601/gotools/logger"
+	mysqldriver "github.com/go-sql-driver/mysql"
 )
 
+// 重试也不会成功的数据错误(数据本身有问题)，只有这些错误的obj会被隔离
+var poisonErrNumbers = map[uint16]bool{
+	1048: true, // 列不能为null
+	1264: true, // 数值超出范围
+	1265: true, // 数据被截断
+	1366: true, // 不正确的值(如字符集)
+	1406: true, // 数据过长
+	1452: true, // 外键约束失败
+}
+
+// 写库策略：更新协程轮到容器时调用，返回true时开始写入容器的变更(last为上一轮写完的时间)
+type FlushPolicy func(c *Container, last time.Time, now time.Time) bool
+
 type updater struct {
 	container    *Container // 所属容器
 	updateTriger chan byte  // 等待加载数据的请求列表
+	lock         sync.Mutex // 同一时间只有一个批量更新
+	failNum      int        // 批量更新连续失败次数
+	lastFlush    time.Time  // 上一轮写完的时间(只在更新协程中访问)
 }
 
 func newUpdater(c *Container) *updater {
 	return &updater{
 		container:    c,
 		updateTriger: make(chan byte, 10),
+		lastFlush:    time.Now(),
+	}
+}
+
+// 默认写库策略：距上一轮写完超过updateGap(容器数据最大延迟)，积压超过警戒线时按加速间隔
+func DefaultFlushPolicy(c *Container, last time.Time, now time.Time) bool {
+	updateGap := c.opts.updateGap
+	if updateGap <= 0 {
+		updateGap = time.Second
+	}
+	return now.Sub(last) >= c.nextUpdateGap(updateGap)
+}
+
+// 是否到了写库时间
+func (u *updater) due(now time.Time) bool {
+	policy := u.container.opts.flushPolicy
+	if policy == nil {
+		policy = DefaultFlushPolicy
+	}
+	return policy(u.container, u.lastFlush, now)
+}
+
+// 一轮写库开始前先合并计数器增量
+func (u *updater) beginFlush() {
+	u.flushCounter()
+	u.container.emitCounter()
+}
+
+// 一轮写库结束，写入变更记录
+func (u *updater) endFlush(now time.Time) {
+	u.lastFlush = now
+	if u.container.auditor != nil {
+		u.container.auditor.flush()
+	}
+}
+
+// 把容器的变更记录全部写入数据库(写库出错时中断，等待下一次更新)
+func (u *updater) flush() {
+	u.flushCounter()
+	u.container.emitCounter()
+	for !u.batchUpdate() {
+	}
+	if u.container.auditor != nil {
+		u.container.auditor.flush()
 	}
 }
 
 func (u *updater) batchUpdate() bool {
-	updateSize := u.container.cache.dbConfig.UpdateSize
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	updateSize := u.container.opts.updateSize
 	updateObjs, deleteKeys := u.container.scanChangeObjs(uint32(updateSize))
+	// 退避中的问题数据不写入，写库后重新标记为变更
+	updateObjs, keepObjs := u.container.holdPoison(updateObjs)
 	// 更新,删除变更记录
 	err := u.replace(updateObjs)
 	if err != nil {
 		logger.Error("cache update error:", u.container.objType, len(updateObjs), err)
+		u.failNum++
+		var poisonObjs []interface{}
+		poisonObjs, err = u.isolate(updateObjs, err)
+		keepObjs = append(keepObjs, poisonObjs...)
+	}
+	if err != nil {
 		u.container.afterSyncDb(false)
 		return true
 	}
+	u.failNum = 0
+	u.container.releaseHeld(updateObjs, keepObjs)
 	updateNum := len(updateObjs)
 	u.container.dbUpdateNum += uint64(updateNum)
+	// 删除只按主键执行，不会因为数据内容失败，不做二分隔离，出错时整批保留等待下次写入
 	err = u.delete(deleteKeys)
 	if err != nil {
 		logger.Error("cache delete error:", u.container.objType, len(deleteKeys), err)
@@ -42,16 +120,109 @@ func (u *updater) batchUpdate() bool {
 		allUpdate = true
 	}
 	u.container.afterSyncDb(true)
+	if len(keepObjs) > 0 {
+		u.container.keepDirty(keepObjs)
+	}
 	// 还有其他内容，继续批量更新
 	return allUpdate
 }
 
-// 利用replace语句进行批量更新
+// 连续失败多次且数据库可用时，二分查找出导致整批失败的obj并隔离，其余obj正常写入
+//
+// 二分过程中遇到可重试的错误(如锁等待超时、死锁)时整批保留，等待下次写入;
+// 返回没有保存到死信存储、需要保持变更状态的问题数据
+func (u *updater) isolate(updateObjs []interface{}, err error) ([]interface{}, error) {
+	threshold := u.container.opts.poisonThreshold
+	if threshold <= 0 || u.failNum < threshold {
+		return nil, err
+	}
+	err = u.ping()
+	if err != nil {
+		return nil, err
+	}
+	var poisonObjs []interface{}
+	var poisonErrs []error
+	err = u.bisect(updateObjs, &poisonObjs, &poisonErrs)
+	if err != nil || len(poisonObjs) == 0 {
+		return nil, err
+	}
+	if u.container.quarantine(poisonObjs, poisonErrs) {
+		return nil, nil
+	}
+	// 没有保存到死信存储的数据不能丢弃
+	return poisonObjs, nil
+}
+
+// 二分写入，单个obj因数据错误写入失败时记为问题数据
+func (u *updater) bisect(objs []interface{}, poisonObjs *[]interface{}, poisonErrs *[]error) error {
+	if len(objs) == 0 {
+		return nil
+	}
+	err := u.replace(objs)
+	if err == nil {
+		return nil
+	}
+	if len(objs) == 1 {
+		// 数据库不可用或临时错误时不能判定为问题数据
+		if !isPoisonErr(err) {
+			return err
+		}
+		*poisonObjs = append(*poisonObjs, objs[0])
+		*poisonErrs = append(*poisonErrs, err)
+		return nil
+	}
+	mid := len(objs) / 2
+	err = u.bisect(objs[:mid], poisonObjs, poisonErrs)
+	if err != nil {
+		return err
+	}
+	return u.bisect(objs[mid:], poisonObjs, poisonErrs)
+}
+
+// 是否为重试也不会成功的数据错误
+func isPoisonErr(err error) bool {
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return poisonErrNumbers[mysqlErr.Number]
+	}
+	return false
+}
+
+// 检查数据库是否可用
+func (u *updater) ping() error {
+	sqlDB, err := u.container.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Ping()
+}
+
+// 利用replace语句进行批量更新(分表时按分表拆分)
 func (u *updater) replace(updateObjs []interface{}) error {
-	return bulk.BulkUpdate(u.container.cache.dbCon, updateObjs)
+	if len(updateObjs) == 0 {
+		return nil
+	}
+	for index, objs := range u.container.groupBySid(updateObjs) {
+		table := u.container.shardTable(index)
+		err := bulk.BulkUpdateWithTableName(u.container.db, table, objs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// 从数据库中批量删除
+// 从数据库中批量删除(分表时按分表拆分)
 func (u *updater) delete(deleteKeys []interface{}) error {
-	return bulk.BulkDelete(u.container.cache.dbCon, u.container.objType, deleteKeys)
+	if len(deleteKeys) == 0 {
+		return nil
+	}
+	for index, keys := range u.container.groupBySid(deleteKeys) {
+		table := u.container.shardTable(index)
+		err := bulk.BulkDeleteWithTableName(u.container.db, table, u.container.objType, keys)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/fengzhu0601/gotools/cache/internal/fakedb"
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+type testLetterStore struct {
+	letters []*DeadLetter
+}
+
+func (s *testLetterStore) Save(letters []*DeadLetter) error {
+	s.letters = append(s.letters, letters...)
+	return nil
+}
+
+// REPLACE语句中包含问题数据时返回errNum错误，返回成功写入的id
+func failOnName(db *fakedb.DB, bad map[string]bool, errNum uint16) *[]uint32 {
+	written := make([]uint32, 0)
+	db.ExecFn = func(query string, args []interface{}) (int64, error) {
+		if len(args) == 0 {
+			return 0, nil
+		}
+		for i := 0; i+3 < len(args); i += 4 {
+			if bad[args[i+3].(string)] {
+				return 0, &mysqldriver.MySQLError{Number: errNum, Message: "bad row"}
+			}
+		}
+		for i := 0; i+3 < len(args); i += 4 {
+			written = append(written, args[i+1].(uint32))
+		}
+		return 1, nil
+	}
+	return &written
+}
+
+func TestIsolate(t *testing.T) {
+	tests := []struct {
+		name        string
+		threshold   int
+		bad         map[string]bool
+		errNum      uint16
+		quarantined []uint32
+		written     []uint32 // nil表示不检查(二分中途遇到可重试错误时，写入了哪些取决于遍历顺序)
+		dirty       bool     // 整批保留等待下次写入
+	}{
+		{"one poison", 1, map[string]bool{"b": true}, 1406, []uint32{2}, []uint32{1, 3, 4}, false},
+		{"two poison", 1, map[string]bool{"a": true, "d": true}, 1366, []uint32{1, 4}, []uint32{2, 3}, false},
+		{"all poison", 1, map[string]bool{"a": true, "b": true, "c": true, "d": true}, 1264, []uint32{1, 2, 3, 4}, []uint32{}, false},
+		{"retryable error", 1, map[string]bool{"b": true}, 1205, []uint32{}, nil, true},
+		{"below threshold", 2, map[string]bool{"b": true}, 1406, []uint32{}, []uint32{}, true},
+		{"disabled", 0, map[string]bool{"b": true}, 1406, []uint32{}, []uint32{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakedb.New()
+			store := &testLetterStore{}
+			c := newTestContainer(t, db, testItemType, WithPreload(true), WithPoisonThreshold(tt.threshold), WithDeadLetter(store))
+			for i, name := range []string{"a", "b", "c", "d"} {
+				c.Replace(&testItem{Sid: 1, Id: uint32(i + 1), Name: name})
+			}
+			written := failOnName(db, tt.bad, tt.errNum)
+			c.updater.batchUpdate()
+
+			quarantined := make([]uint32, 0)
+			for _, letter := range store.letters {
+				item := &testItem{}
+				if err := json.Unmarshal([]byte(letter.Obj), item); err != nil {
+					t.Fatal(err)
+				}
+				quarantined = append(quarantined, item.Id)
+			}
+			sort.Slice(quarantined, func(i, j int) bool { return quarantined[i] < quarantined[j] })
+			sort.Slice(*written, func(i, j int) bool { return (*written)[i] < (*written)[j] })
+			if !reflect.DeepEqual(quarantined, tt.quarantined) {
+				t.Errorf("quarantined = %v, want %v", quarantined, tt.quarantined)
+			}
+			if tt.written != nil && !reflect.DeepEqual(*written, tt.written) {
+				t.Errorf("written = %v, want %v", *written, tt.written)
+			}
+			cell, _ := c.cellLoad(1)
+			if cell.isChange() != tt.dirty {
+				t.Errorf("cell changed = %v, want %v", cell.isChange(), tt.dirty)
+			}
+		})
+	}
+}
+
+func TestNextFlush(t *testing.T) {
+	tests := []struct {
+		name      string
+		due       []bool
+		start     int
+		want      int // 选中的容器(-1表示没有)
+		nextIndex int
+	}{
+		{"first due", []bool{true, false, false}, 0, 0, 1},
+		{"skip not due", []bool{false, false, true}, 0, 2, 0},
+		{"start from index", []bool{true, true, false}, 1, 1, 2},
+		{"wrap around", []bool{true, false, false}, 1, 0, 1},
+		{"none due", []bool{false, false, false}, 2, -1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := &Cache{}
+			for _, due := range tt.due {
+				due := due
+				c := &Container{opts: &containerOptions{flushPolicy: func(*Container, time.Time, time.Time) bool { return due }}}
+				c.updater = newUpdater(c)
+				cache.containerList = append(cache.containerList, c)
+			}
+			got, nextIndex := cache.nextFlush(tt.start, time.Now())
+			want := (*Container)(nil)
+			if tt.want >= 0 {
+				want = cache.containerList[tt.want]
+			}
+			if got != want || nextIndex != tt.nextIndex {
+				t.Fatalf("nextFlush(%d) = %p %d, want %p %d", tt.start, got, nextIndex, want, tt.nextIndex)
+			}
+		})
+	}
+}
+
+func TestDefaultFlushPolicy(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		gap       time.Duration
+		highWater *HighWater
+		unhealthy int32
+		since     time.Duration // 距上一轮写完的时间
+		want      bool
+	}{
+		{"due", time.Second, nil, 0, 2 * time.Second, true},
+		{"not due", time.Second, nil, 0, 500 * time.Millisecond, false},
+		{"default gap", 0, nil, 0, time.Second, true},
+		{"aggressive", time.Second, &HighWater{Aggressive: true}, 1, 200 * time.Millisecond, true},
+		{"aggressive gap", time.Second, &HighWater{Aggressive: true, AggressiveGap: 300 * time.Millisecond}, 1, 200 * time.Millisecond, false},
+		{"aggressive but healthy", time.Second, &HighWater{Aggressive: true}, 0, 200 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Container{opts: &containerOptions{updateGap: tt.gap, highWater: tt.highWater}}
+			c.backlog.unhealthy = tt.unhealthy
+			if got := DefaultFlushPolicy(c, now.Add(-tt.since), now); got != tt.want {
+				t.Fatalf("DefaultFlushPolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 关闭时停止更新协程和加载协程，写入剩余数据并取消订阅
+func TestClose(t *testing.T) {
+	db := fakedb.New()
+	cache := newTestCache(t, db)
+	cache.InitContainer(testItemType)
+	cache.goRun(cache.loopUpdate)
+	c := cache.containers[testItemType]
+	events, _ := c.SubscribeChan(10, DELIVER_DROP)
+	c.Replace(&testItem{Sid: 1, Id: 1})
+
+	done := make(chan struct{})
+	go func() {
+		cache.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Close() did not return")
+	}
+	if len(db.ExecsOf("REPLACE INTO")) != 1 {
+		t.Errorf("REPLACE execs = %v, want 1", db.ExecsOf("REPLACE INTO"))
+	}
+	for range events {
+	}
+}
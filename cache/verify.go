@@ -0,0 +1,323 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/fengzhu0601/gotools/cache/bulk"
+	"github.com/fengzhu0601/gotools/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const defaultVerifySampleNum = 100
+
+// 不一致的类型
+type MismatchKind byte
+
+const (
+	MISMATCH_FIELD         MismatchKind = 1 // 字段值不同
+	MISMATCH_MISSING_DB    MismatchKind = 2 // 缓存中有，数据库中没有
+	MISMATCH_MISSING_CACHE MismatchKind = 3 // 数据库中有，缓存中没有
+)
+
+// 发现不一致时的修复方式
+type RepairMode byte
+
+const (
+	REPAIR_NONE    RepairMode = 0 // 只报告
+	REPAIR_FROM_DB RepairMode = 1 // 以数据库为准，覆盖缓存
+	REPAIR_TO_DB   RepairMode = 2 // 以缓存为准，写入数据库
+)
+
+// 后台抽样校验配置
+type VerifyConfig struct {
+	Interval  time.Duration // 抽样间隔(<=0不开启后台抽样)
+	SampleNum int           // 每次抽样的sid数量(默认100)
+	Repair    RepairMode    // 修复方式(Verify也使用该配置)
+}
+
+// 一条不一致的数据
+type Mismatch struct {
+	Sid    uint64
+	Keys   []interface{} // 除sid外的主键值
+	Kind   MismatchKind  // 不一致的类型
+	Fields []string      // 值不同的字段(MISMATCH_FIELD)
+	Cached interface{}   // 缓存中的obj
+	Stored interface{}   // 数据库中的obj
+}
+
+// 校验结果
+type VerifyResult struct {
+	ObjType    reflect.Type
+	Checked    int         // 校验的sid数量
+	Skipped    int         // 跳过的sid数量(不在内存中或有未写入的变更)
+	ObjNum     int         // 比较的obj数量
+	Mismatches []*Mismatch // 不一致的数据
+	Repaired   int         // 修复的obj数量
+}
+
+// 校验统计
+type verifyStat struct {
+	checkNum    uint64 // 校验的sid总数
+	mismatchNum uint64 // 发现的不一致obj总数
+	repairNum   uint64 // 修复的obj总数
+}
+
+// 设置后台抽样校验：定时选取内存中没有待写入变更的sid，从主库重新读取并和缓存比较
+func WithVerify(cfg VerifyConfig) ContainerOption {
+	return func(o *containerOptions) {
+		if cfg.SampleNum <= 0 {
+			cfg.SampleNum = defaultVerifySampleNum
+		}
+		o.verify = &cfg
+	}
+}
+
+// 校验一批sid的缓存和数据库是否一致，按容器配置的修复方式处理不一致的数据
+func (c *Container) Verify(ctx context.Context, sids []uint64) (*VerifyResult, error) {
+	repair := REPAIR_NONE
+	if c.opts.verify != nil {
+		repair = c.opts.verify.Repair
+	}
+	return c.VerifyRepair(ctx, sids, repair)
+}
+
+// 校验一批sid的缓存和数据库是否一致，按指定的修复方式处理不一致的数据
+//
+// 只校验已在内存中且没有待写入变更的cell，校验时持有updater锁，不会和写库交错
+func (c *Container) VerifyRepair(ctx context.Context, sids []uint64, repair RepairMode) (*VerifyResult, error) {
+	result := &VerifyResult{ObjType: c.objType}
+	fields, err := c.verifyFields()
+	if err != nil {
+		return result, err
+	}
+	c.updater.lock.Lock()
+	defer c.updater.lock.Unlock()
+
+	cells := make(map[uint64]*Cell)
+	for _, sid := range sids {
+		cell, exit := c.cells.Load(sid)
+		if !exit || cell.(*Cell).isChange() {
+			result.Skipped++
+			continue
+		}
+		cells[sid] = cell.(*Cell)
+	}
+	clean := make([]uint64, 0, len(cells))
+	for sid := range cells {
+		clean = append(clean, sid)
+	}
+
+	for index, group := range c.groupSids(clean) {
+		table := c.shardTable(index)
+		datas, err := c.find([]*gorm.DB{c.db.WithContext(ctx)}, table, group)
+		if err != nil {
+			return result, err
+		}
+		stored := make(map[uint64]map[string]interface{})
+		for i := 0; i < datas.Len(); i++ {
+			obj := datas.Index(i).Interface()
+			afterCacheLoad(obj)
+			sid, ok := c.sidOf(obj)
+			if !ok {
+				logger.Error("cache load invalid sid", c.objType, obj)
+				continue
+			}
+			if stored[sid] == nil {
+				stored[sid] = make(map[string]interface{})
+			}
+			stored[sid][joinKeys(c.keysOf(obj))] = obj
+		}
+		for _, sid := range group {
+			cell := cells[sid]
+			// 读取数据库期间有新的变更，跳过
+			if cell.isChange() {
+				result.Skipped++
+				continue
+			}
+			result.Checked++
+			mismatches, objNum := c.compareCell(ctx, sid, cell, stored[sid], fields)
+			result.ObjNum += objNum
+			if len(mismatches) == 0 {
+				continue
+			}
+			result.Mismatches = append(result.Mismatches, mismatches...)
+			if repair != REPAIR_NONE {
+				repaired, err := c.repair(ctx, table, cell, mismatches, repair, "verify repair")
+				if err != nil {
+					logger.Error("cache verify repair error", c.objType, sid, err)
+					continue
+				}
+				result.Repaired += repaired
+			}
+		}
+	}
+
+	atomic.AddUint64(&c.verifyStat.checkNum, uint64(result.Checked))
+	atomic.AddUint64(&c.verifyStat.mismatchNum, uint64(len(result.Mismatches)))
+	atomic.AddUint64(&c.verifyStat.repairNum, uint64(result.Repaired))
+	for _, m := range result.Mismatches {
+		logger.Error("cache verify mismatch", c.objType, m.Sid, m.Keys, m.Kind, m.Fields)
+	}
+	return result, nil
+}
+
+// 比较单个cell和数据库中的数据，返回不一致的数据和比较的obj数量
+func (c *Container) compareCell(ctx context.Context, sid uint64, cell *Cell, stored map[string]interface{}, fields []*schema.Field) ([]*Mismatch, int) {
+	cached := make([]interface{}, 0)
+	cell.cargo.CollectAllObjs(&cached)
+	mismatches := make([]*Mismatch, 0)
+	objNum := len(cached)
+	for _, obj := range cached {
+		keys := c.keysOf(obj)
+		key := joinKeys(keys)
+		dbObj, exit := stored[key]
+		if !exit {
+			mismatches = append(mismatches, &Mismatch{Sid: sid, Keys: keys, Kind: MISMATCH_MISSING_DB, Cached: obj})
+			continue
+		}
+		delete(stored, key)
+		diff := diffFields(ctx, fields, obj, dbObj)
+		if len(diff) > 0 {
+			mismatches = append(mismatches, &Mismatch{Sid: sid, Keys: keys, Kind: MISMATCH_FIELD, Fields: diff, Cached: obj, Stored: dbObj})
+		}
+	}
+	objNum += len(stored)
+	for _, dbObj := range stored {
+		mismatches = append(mismatches, &Mismatch{Sid: sid, Keys: c.keysOf(dbObj), Kind: MISMATCH_MISSING_CACHE, Stored: dbObj})
+	}
+	return mismatches, objNum
+}
+
+// 修复不一致的数据(调用时已持有updater锁)，返回修复的数量，reason为以数据库为准修复时写入变更事件的原因
+func (c *Container) repair(ctx context.Context, table string, cell *Cell, mismatches []*Mismatch, repair RepairMode, reason string) (int, error) {
+	if repair == REPAIR_TO_DB {
+		updateObjs := make([]interface{}, 0)
+		deleteObjs := make([]interface{}, 0)
+		for _, m := range mismatches {
+			if m.Kind == MISMATCH_MISSING_CACHE {
+				deleteObjs = append(deleteObjs, m.Stored)
+			} else {
+				updateObjs = append(updateObjs, m.Cached)
+			}
+		}
+		db := c.db.WithContext(ctx)
+		if len(updateObjs) > 0 {
+			beforeFlush(updateObjs)
+			err := bulk.BulkUpdateWithTableName(db, table, updateObjs)
+			if err != nil {
+				return 0, err
+			}
+			c.dbUpdateNum += uint64(len(updateObjs))
+		}
+		if len(deleteObjs) > 0 {
+			err := bulk.BulkDeleteObjsWithTableName(db, table, c.keyNum, deleteObjs)
+			if err != nil {
+				return 0, err
+			}
+			c.dbDeleteNum += uint64(len(deleteObjs))
+		}
+		return len(mismatches), nil
+	}
+	// 比较之后写入的obj不会被数据库中的旧值覆盖
+	return c.repairFromDB(cell, mismatches, false, reason), nil
+}
+
+// 参与比较的字段(数据库中的列)
+func (c *Container) verifyFields() ([]*schema.Field, error) {
+	stmt := &gorm.Statement{DB: c.db}
+	err := stmt.Parse(reflect.New(c.objType).Interface())
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]*schema.Field, 0, len(stmt.Schema.Fields))
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+// 值不同的字段名
+func diffFields(ctx context.Context, fields []*schema.Field, a interface{}, b interface{}) []string {
+	va := reflect.ValueOf(a)
+	vb := reflect.ValueOf(b)
+	diff := make([]string, 0)
+	for _, field := range fields {
+		fa := field.ReflectValueOf(ctx, va).Interface()
+		fb := field.ReflectValueOf(ctx, vb).Interface()
+		if !fieldEqual(fa, fb) {
+			diff = append(diff, field.Name)
+		}
+	}
+	return diff
+}
+
+func fieldEqual(a interface{}, b interface{}) bool {
+	// 指针字段(如*time.Time)比较指向的值
+	va := reflect.ValueOf(a)
+	vb := reflect.ValueOf(b)
+	if va.Kind() == reflect.Ptr && vb.Kind() == reflect.Ptr {
+		if va.IsNil() || vb.IsNil() {
+			return va.IsNil() == vb.IsNil()
+		}
+		return fieldEqual(va.Elem().Interface(), vb.Elem().Interface())
+	}
+	// 数据库中的时间只精确到秒，时区也可能不同
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		return ok && ta.Truncate(time.Second).Equal(tb.Truncate(time.Second))
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// 后台定时抽样校验
+func (c *Container) runVerify(ctx context.Context) {
+	cfg := c.opts.verify
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sids := c.sampleSids(cfg.SampleNum)
+			if len(sids) == 0 {
+				continue
+			}
+			result, err := c.Verify(ctx, sids)
+			if err != nil {
+				logger.Error("cache verify error", c.objType, err)
+				continue
+			}
+			if len(result.Mismatches) > 0 {
+				logger.Error("cache verify found mismatches", result.String())
+			}
+		}
+	}
+}
+
+// 选取一批没有待写入变更的sid(sync.Map的遍历起点是随机的)
+func (c *Container) sampleSids(num int) []uint64 {
+	sids := make([]uint64, 0, num)
+	c.cells.Range(func(k any, v any) bool {
+		if len(sids) >= num {
+			return false
+		}
+		if !v.(*Cell).isChange() {
+			sids = append(sids, k.(uint64))
+		}
+		return true
+	})
+	return sids
+}
+
+func (r *VerifyResult) String() string {
+	return fmt.Sprintf("%s checked:%d skipped:%d objs:%d mismatches:%d repaired:%d",
+		r.ObjType, r.Checked, r.Skipped, r.ObjNum, len(r.Mismatches), r.Repaired)
+}
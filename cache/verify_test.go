@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fengzhu0601/gotools/cache/internal/fakedb"
+)
+
+type verifyItem struct {
+	Sid      uint64 `gorm:"primaryKey"`
+	Id       uint32 `gorm:"primaryKey"`
+	Num      *int
+	Time     time.Time
+	LoginAt  *time.Time
+	Tags     []byte
+	Computed int `gorm:"-"`
+}
+
+var verifyItemType = reflect.TypeOf(verifyItem{})
+
+func TestFieldEqual(t *testing.T) {
+	shanghai := time.FixedZone("UTC+8", 8*3600)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	one, two, oneAgain := 1, 2, 1
+	var nilInt *int
+	tests := []struct {
+		name string
+		a, b interface{}
+		want bool
+	}{
+		{"int", 1, 1, true},
+		{"int diff", 1, 2, false},
+		{"string", "a", "a", true},
+		{"bytes", []byte("ab"), []byte("ab"), true},
+		{"bytes diff", []byte("ab"), []byte("ac"), false},
+		{"pointer same value", &one, &oneAgain, true},
+		{"pointer diff value", &one, &two, false},
+		{"pointer both nil", nilInt, (*int)(nil), true},
+		{"pointer one nil", &one, nilInt, false},
+		{"pointer nil first", nilInt, &one, false},
+		{"time equal", now, now, true},
+		{"time sub second", now.Add(999 * time.Millisecond), now, true},
+		{"time next second", now.Add(time.Second), now, false},
+		{"time zone", now.In(shanghai), now, true},
+		{"time zone diff", time.Date(2024, 1, 2, 3, 4, 5, 0, shanghai), now, false},
+		{"time and string", now, "2024-01-02", false},
+		{"time pointer", &now, func() *time.Time { v := now.Add(500 * time.Millisecond).In(shanghai); return &v }(), true},
+		{"time pointer nil", &now, (*time.Time)(nil), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fieldEqual(tt.a, tt.b); got != tt.want {
+				t.Fatalf("fieldEqual(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffFields(t *testing.T) {
+	c := newTestContainer(t, fakedb.New(), verifyItemType, WithPreload(true))
+	fields, err := c.verifyFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	login := now.Add(-time.Hour)
+	loginDB := login.Add(300 * time.Millisecond).In(time.FixedZone("UTC+8", 8*3600))
+	later := login.Add(time.Minute)
+	one, oneDB, two := 1, 1, 2
+	base := verifyItem{Sid: 1, Id: 1, Num: &one, Time: now, LoginAt: &login, Tags: []byte("a"), Computed: 1}
+	tests := []struct {
+		name   string
+		modify func(obj *verifyItem)
+		want   []string
+	}{
+		{"same", nil, []string{}},
+		{"db precision and zone", func(obj *verifyItem) {
+			obj.Num, obj.LoginAt, obj.Time = &oneDB, &loginDB, now.Add(100*time.Millisecond)
+		}, []string{}},
+		{"ignored field", func(obj *verifyItem) { obj.Computed = 2 }, []string{}},
+		{"pointer value", func(obj *verifyItem) { obj.Num = &two }, []string{"Num"}},
+		{"nil pointer", func(obj *verifyItem) { obj.Num, obj.LoginAt = nil, nil }, []string{"Num", "LoginAt"}},
+		{"time", func(obj *verifyItem) { obj.Time, obj.LoginAt = now.Add(time.Second), &later }, []string{"Time", "LoginAt"}},
+		{"bytes", func(obj *verifyItem) { obj.Tags = []byte("b") }, []string{"Tags"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := base
+			if tt.modify != nil {
+				tt.modify(&stored)
+			}
+			cached := base
+			got := diffFields(context.Background(), fields, &cached, &stored)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("diffFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/fengzhu0601/gotools/cache/bulk"
+	"github.com/fengzhu0601/gotools/logger"
+)
+
+// 同步更新或插入某个obj：先写入数据库，成功后才更新内存
+//
+// 写库时持有updater锁，不会和批量更新交错。同一sid待写入的其他变更不受影响，仍由updater写入;
+// 释放updater锁后才发出写入事件(仍持有sid的写入锁)，阻塞的订阅者不会卡住写库;
+// 非预加载容器的sid加载后马上被回收时，内存中没有这个sid，只写数据库(不发出事件)，下次访问时从数据库加载
+func (c *Container) ReplaceSync(ctx context.Context, obj interface{}) error {
+	return c.replaceSync(ctx, obj, "")
+}
+
+func (c *Container) replaceSync(ctx context.Context, obj interface{}, reason string) error {
+	err := validate(obj)
+	if err != nil {
+		return err
+	}
+	sid, err := c.checkSid(obj)
+	if err != nil {
+		return err
+	}
+	keys := c.keysOf(obj)
+	cargo := c.getCargo(sid, false)
+	c.updater.lock.Lock()
+	lock := c.lockSid(sid)
+	defer lock.Unlock()
+	if cargo == nil {
+		beforeFlush([]interface{}{obj})
+		err = bulk.BulkUpdateWithTableName(c.db.WithContext(ctx), c.tableOf(sid), []interface{}{obj})
+		c.updater.lock.Unlock()
+		return err
+	}
+	old := cargo.GetObj(keys...)
+	err = c.reserveUnique(sid, keys, old, obj)
+	if err != nil {
+		c.updater.lock.Unlock()
+		return err
+	}
+	beforeFlush([]interface{}{obj})
+	err = bulk.BulkUpdateWithTableName(c.db.WithContext(ctx), c.tableOf(sid), []interface{}{obj})
+	if err != nil {
+		c.releaseUnique(c.uniqueIndexes(), sid, keys, old)
+		c.updater.lock.Unlock()
+		return err
+	}
+	cargo.ReplaceSynced(obj)
+	c.dbUpdateNum++
+	c.markFlushed(sid, time.Now().Unix())
+	c.updater.lock.Unlock()
+
+	c.notifyReplace(sid, keys, old, obj, reason)
+	return nil
+}
+
+// 同步删除某个obj：先从数据库删除，成功后才更新内存
+func (c *Container) DeleteSync(ctx context.Context, obj interface{}) error {
+	return c.deleteSync(ctx, obj, "")
+}
+
+func (c *Container) deleteSync(ctx context.Context, obj interface{}, reason string) error {
+	sid, err := c.checkSid(obj)
+	if err != nil {
+		return err
+	}
+	keys := c.keysOf(obj)
+	cargo := c.getCargo(sid, false)
+	c.updater.lock.Lock()
+	lock := c.lockSid(sid)
+	defer lock.Unlock()
+	err = bulk.BulkDeleteObjsWithTableName(c.db.WithContext(ctx), c.tableOf(sid), c.keyNum, []interface{}{obj})
+	if err != nil || cargo == nil {
+		// 内存中没有这个sid时只删除数据库
+		c.updater.lock.Unlock()
+		return err
+	}
+	old := cargo.GetObj(keys...)
+	cargo.DeleteSynced(obj)
+	c.dbDeleteNum++
+	c.markFlushed(sid, time.Now().Unix())
+	c.updater.lock.Unlock()
+
+	if old != nil {
+		c.notify(OP_DELETE, sid, keys, old, nil, reason)
+	}
+	return nil
+}
+
+// 同步删除某个玩家的所有obj
+func (c *Container) DeleteObjsSync(ctx context.Context, sid uint64) error {
+	return c.deleteObjsSync(ctx, sid, "")
+}
+
+func (c *Container) deleteObjsSync(ctx context.Context, sid uint64, reason string) error {
+	cargo := c.getCargo(sid, false)
+	c.updater.lock.Lock()
+	lock := c.lockSid(sid)
+	defer lock.Unlock()
+	if cargo == nil {
+		// 内存中没有这个sid，不知道有哪些obj，按sid删除数据库中的所有数据
+		err := c.db.WithContext(ctx).Table(c.tableOf(sid)).Where("sid = ?", sid).Delete(reflect.New(c.objType).Interface()).Error
+		c.updater.lock.Unlock()
+		return err
+	}
+	objs := cargo.GetSomeObjs()
+	err := bulk.BulkDeleteObjsWithTableName(c.db.WithContext(ctx), c.tableOf(sid), c.keyNum, objs)
+	if err != nil {
+		c.updater.lock.Unlock()
+		return err
+	}
+	for _, obj := range objs {
+		cargo.DeleteSynced(obj)
+	}
+	c.dbDeleteNum += uint64(len(objs))
+	c.markFlushed(sid, time.Now().Unix())
+	c.updater.lock.Unlock()
+
+	for _, obj := range objs {
+		c.notify(OP_DELETE, sid, c.keysOf(obj), obj, nil, reason)
+	}
+	return nil
+}
+
+// 记录同步写库的错误
+func (c *Container) logSyncErr(err error) bool {
+	if err != nil {
+		logger.Error("cache write through error:", c.objType, err)
+		return false
+	}
+	return true
+}
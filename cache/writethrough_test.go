@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fengzhu0601/gotools/cache/internal/fakedb"
+)
+
+// 订阅者收到事件时updater锁是否已释放
+func subscribeUnlocked(c *Container) chan bool {
+	unlocked := make(chan bool, 10)
+	c.Subscribe(func(ev ChangeEvent) {
+		ok := c.updater.lock.TryLock()
+		if ok {
+			c.updater.lock.Unlock()
+		}
+		unlocked <- ok
+	}, SubscribePolicy(DELIVER_BLOCK))
+	return unlocked
+}
+
+func TestReplaceSync(t *testing.T) {
+	tests := []struct {
+		name    string
+		execErr error
+		want    *testItem // 写库后内存中的数据
+	}{
+		{"success", nil, &testItem{Sid: 1, Id: 1, Num: 2}},
+		{"db error", errors.New("db down"), &testItem{Sid: 1, Id: 1, Num: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakedb.New()
+			c := newTestContainer(t, db, testItemType, WithPreload(true), WithWriteThrough(true))
+			if err := c.Replace(&testItem{Sid: 1, Id: 1, Num: 1}); err != nil {
+				t.Fatal(err)
+			}
+			unlocked := subscribeUnlocked(c)
+			db.ExecFn = func(query string, args []interface{}) (int64, error) {
+				if c.updater.lock.TryLock() {
+					c.updater.lock.Unlock()
+					t.Errorf("%s executed without updater lock", query)
+				}
+				if query == "BEGIN" || query == "ROLLBACK" {
+					return 0, nil
+				}
+				return 1, tt.execErr
+			}
+			err := c.ReplaceSync(context.Background(), &testItem{Sid: 1, Id: 1, Num: 2})
+			if !errors.Is(err, tt.execErr) {
+				t.Fatalf("ReplaceSync() error = %v, want %v", err, tt.execErr)
+			}
+			if got := c.Lookup(1, 1).(*testItem); *got != *tt.want {
+				t.Fatalf("Lookup() = %+v, want %+v", got, tt.want)
+			}
+			select {
+			case ok := <-unlocked:
+				if tt.execErr != nil {
+					t.Fatalf("event sent on db error")
+				}
+				if !ok {
+					t.Fatalf("event sent while holding updater lock")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.execErr == nil {
+					t.Fatalf("no event after ReplaceSync")
+				}
+			}
+			if cell, _ := c.cellLoad(1); cell.isChange() {
+				t.Fatalf("cell changed after ReplaceSync")
+			}
+		})
+	}
+}
+
+func TestDeleteSync(t *testing.T) {
+	db := fakedb.New()
+	c := newTestContainer(t, db, testItemType, WithPreload(true), WithWriteThrough(true))
+	c.Replace(&testItem{Sid: 1, Id: 1})
+	c.Replace(&testItem{Sid: 1, Id: 2})
+	unlocked := subscribeUnlocked(c)
+
+	if err := c.DeleteSync(context.Background(), &testItem{Sid: 1, Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if !<-unlocked {
+		t.Fatalf("event sent while holding updater lock")
+	}
+	if c.Lookup(1, 1) != nil || c.Lookup(1, 2) == nil {
+		t.Fatalf("LookupObjs() after DeleteSync = %v", c.LookupObjs(1))
+	}
+	if err := c.DeleteObjsSync(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if !<-unlocked {
+		t.Fatalf("event sent while holding updater lock")
+	}
+	if objs := c.LookupObjs(1); len(objs) != 0 {
+		t.Fatalf("LookupObjs() after DeleteObjsSync = %v", objs)
+	}
+	if deletes := db.ExecsOf("DELETE FROM"); len(deletes) != 2 {
+		t.Fatalf("DELETE execs = %v, want 2", deletes)
+	}
+}
+
+// 加载后内存中没有这个sid(已被回收)时只写数据库
+func TestSyncNotLoaded(t *testing.T) {
+	db := fakedb.New()
+	c := newTestContainer(t, db, testItemType, WithWriteThrough(true))
+	// 加载请求直接返回，不放入cell，模拟加载后马上被回收
+	c.selector = newSelector(c)
+	go func() {
+		for req := range c.selector.waitList {
+			if req != nil {
+				close(req.backChan)
+			}
+		}
+	}()
+	events, _ := c.SubscribeChan(10, DELIVER_DROP)
+	ctx := context.Background()
+
+	if err := c.ReplaceSync(ctx, &testItem{Sid: 1, Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteSync(ctx, &testItem{Sid: 1, Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteObjsSync(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	close(c.selector.waitList)
+	if len(db.ExecsOf("REPLACE INTO")) != 1 {
+		t.Errorf("REPLACE execs = %v, want 1", db.ExecsOf("REPLACE INTO"))
+	}
+	deletes := db.ExecsOf("DELETE FROM")
+	if len(deletes) != 2 || len(deletes[1].Args) != 1 || deletes[1].Args[0] != uint64(1) {
+		t.Errorf("DELETE execs = %v, want delete by key and by sid", deletes)
+	}
+	if len(events) != 0 {
+		t.Errorf("events = %d, want 0", len(events))
+	}
+	if _, exit := c.cellLoad(1); exit {
+		t.Errorf("cell created for not loaded sid")
+	}
+}